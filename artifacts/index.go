@@ -0,0 +1,272 @@
+// Package artifacts writes the supply-chain files of the msi packages:
+// a CycloneDX sbom of the packaged files, the build metadata, and the SHA256SUMS files.
+package artifacts
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mh-cbon/go-msi/manifest"
+	"github.com/mh-cbon/go-msi/util"
+	"github.com/satori/go.uuid"
+)
+
+// SumsFile is the name of the checksums file, written next to the msi files.
+const SumsFile = "SHA256SUMS"
+
+// Metadata is the build metadata of an msi package.
+type Metadata struct {
+	Product     string            `json:"product"`
+	Company     string            `json:"company,omitempty"`
+	Version     string            `json:"version"`
+	Arch        string            `json:"arch,omitempty"`
+	Profile     string            `json:"profile,omitempty"`
+	ProductCode string            `json:"product-code"`
+	UpgradeCode string            `json:"upgrade-code"`
+	Msi         string            `json:"msi"`
+	Sha256      string            `json:"sha256"`
+	Timestamp   string            `json:"timestamp"`
+	Toolchain   map[string]string `json:"toolchain"` // the versions of go-msi and of the WiX toolset
+}
+
+// Sbom is a CycloneDX document listing the files packaged by an msi package.
+type Sbom struct {
+	BomFormat    string       `json:"bomFormat"`
+	SpecVersion  string       `json:"specVersion"`
+	SerialNumber string       `json:"serialNumber"`
+	Version      int          `json:"version"`
+	Metadata     SbomMetadata `json:"metadata"`
+	Components   []Component  `json:"components"`
+}
+
+// SbomMetadata is the metadata of an Sbom, the msi package is its component.
+type SbomMetadata struct {
+	Timestamp string    `json:"timestamp"`
+	Tools     []Tool    `json:"tools"`
+	Component Component `json:"component"`
+}
+
+// Tool is a tool which built the msi package.
+type Tool struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+// Component is the msi package, or a file it installs.
+type Component struct {
+	Type      string `json:"type"`
+	BomRef    string `json:"bom-ref,omitempty"`
+	Name      string `json:"name"`
+	Version   string `json:"version,omitempty"`
+	Publisher string `json:"publisher,omitempty"`
+	Hashes    []Hash `json:"hashes,omitempty"`
+}
+
+// Hash is the hash of a Component.
+type Hash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+// Timestamp returns the time of the build, formatted as RFC 3339,
+// it is the SOURCE_DATE_EPOCH environment variable when set, for reproducible builds.
+func Timestamp() string {
+	t := time.Now()
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		t = time.Unix(epoch, 0)
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+// NewMetadata returns the build metadata of the msi file of wixFile, the normalized manifest,
+// productCode is the product code of the msi file, toolchain the versions of the build tools.
+func NewMetadata(wixFile *manifest.WixManifest, msi, productCode string, toolchain map[string]string) (*Metadata, error) {
+	sum, err := util.ComputeSha256(msi)
+	if err != nil {
+		return nil, err
+	}
+	return &Metadata{
+		Product:     wixFile.Product,
+		Company:     wixFile.Company,
+		Version:     wixFile.Version,
+		Arch:        wixFile.Arch,
+		Profile:     wixFile.Profile,
+		ProductCode: "{" + strings.ToUpper(strings.Trim(productCode, "{}")) + "}",
+		UpgradeCode: "{" + strings.ToUpper(strings.Trim(wixFile.UpgradeCode, "{}")) + "}",
+		Msi:         filepath.Base(msi),
+		Sha256:      sum,
+		Timestamp:   Timestamp(),
+		Toolchain:   toolchain,
+	}, nil
+}
+
+// NewSbom returns the sbom of the msi file of wixFile, the normalized manifest,
+// it lists the files of wixFile, they are relative to dir, the build directory.
+// The serial number is derived from the hash of the msi file.
+func NewSbom(wixFile *manifest.WixManifest, msi, dir string, toolchain map[string]string) (*Sbom, error) {
+	sum, err := util.ComputeSha256(msi)
+	if err != nil {
+		return nil, err
+	}
+	ret := &Sbom{
+		BomFormat:    "CycloneDX",
+		SpecVersion:  "1.4",
+		SerialNumber: "urn:uuid:" + uuid.NewV5(uuid.NamespaceURL, "sha256:"+sum).String(),
+		Version:      1,
+		Metadata: SbomMetadata{
+			Timestamp: Timestamp(),
+			Component: Component{
+				Type:      "application",
+				BomRef:    filepath.Base(msi),
+				Name:      wixFile.Product,
+				Version:   wixFile.Version,
+				Publisher: wixFile.Company,
+				Hashes:    []Hash{{Alg: "SHA-256", Content: sum}},
+			},
+		},
+		Components: []Component{},
+	}
+	names := []string{}
+	for name := range toolchain {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ret.Metadata.Tools = append(ret.Metadata.Tools, Tool{Name: name, Version: toolchain[name]})
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{}
+	for _, f := range wixFile.SourceFiles() {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(dir, f)
+		}
+		if seen[f] {
+			continue
+		}
+		seen[f] = true
+		sum, err := util.ComputeSha256(f)
+		if err != nil {
+			return nil, err
+		}
+		name := f
+		if rel, err := filepath.Rel(cwd, f); err == nil {
+			name = rel
+		}
+		name = filepath.ToSlash(name)
+		ret.Components = append(ret.Components, Component{
+			Type:    "file",
+			BomRef:  name,
+			Name:    name,
+			Version: FileVersion(f),
+			Hashes:  []Hash{{Alg: "SHA-256", Content: sum}},
+		})
+	}
+	return ret, nil
+}
+
+// FileVersion returns the file version of the exe, or dll, file p,
+// read from its version resource, or an empty string.
+func FileVersion(p string) string {
+	dat, err := ioutil.ReadFile(p)
+	if err != nil || !bytes.HasPrefix(dat, []byte("MZ")) {
+		return ""
+	}
+	// VS_FIXEDFILEINFO starts with its signature, then its struct version,
+	// then the most and the least significant parts of the file version.
+	i := bytes.Index(dat, []byte{0xbd, 0x04, 0xef, 0xfe})
+	if i < 0 || len(dat) < i+16 {
+		return ""
+	}
+	ms := binary.LittleEndian.Uint32(dat[i+8:])
+	ls := binary.LittleEndian.Uint32(dat[i+12:])
+	return fmt.Sprintf("%d.%d.%d.%d", ms>>16, ms&0xffff, ls>>16, ls&0xffff)
+}
+
+// WriteJSON writes v to p, indented.
+func WriteJSON(p string, v interface{}) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, b.Bytes(), 0644)
+}
+
+// WriteSums writes the sha256 of the files to the SHA256SUMS file of their directory,
+// as sha256sum does, the other entries of an existing SHA256SUMS file are kept.
+// It returns the SHA256SUMS files written.
+func WriteSums(files []string) ([]string, error) {
+	dirs := map[string]map[string]string{}
+	order := []string{}
+	for _, f := range files {
+		dir := filepath.Dir(f)
+		if dirs[dir] == nil {
+			sums, err := readSums(filepath.Join(dir, SumsFile))
+			if err != nil {
+				return nil, err
+			}
+			dirs[dir] = sums
+			order = append(order, dir)
+		}
+		sum, err := util.ComputeSha256(f)
+		if err != nil {
+			return nil, err
+		}
+		dirs[dir][filepath.Base(f)] = sum
+	}
+
+	ret := []string{}
+	for _, dir := range order {
+		names := []string{}
+		for name := range dirs[dir] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var b bytes.Buffer
+		for _, name := range names {
+			fmt.Fprintf(&b, "%v  %v\n", dirs[dir][name], name)
+		}
+		p := filepath.Join(dir, SumsFile)
+		if err := ioutil.WriteFile(p, b.Bytes(), 0644); err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
+
+// readSums reads the entries of the SHA256SUMS file p, it is empty when p does not exist.
+func readSums(p string) (map[string]string, error) {
+	ret := map[string]string{}
+	f, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return ret, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.SplitN(s.Text(), " ", 2)
+		if len(fields) == 2 {
+			ret[strings.TrimLeft(fields[1], " *")] = fields[0]
+		}
+	}
+	return ret, s.Err()
+}
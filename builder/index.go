@@ -0,0 +1,746 @@
+// Package builder builds the msi packages of a manifest,
+// it is the pipeline of go-msi make, for the programs embedding go-msi.
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/mh-cbon/go-msi/artifacts"
+	"github.com/mh-cbon/go-msi/ico"
+	"github.com/mh-cbon/go-msi/logger"
+	"github.com/mh-cbon/go-msi/manifest"
+	"github.com/mh-cbon/go-msi/rtf"
+	"github.com/mh-cbon/go-msi/sign"
+	"github.com/mh-cbon/go-msi/tpls"
+	"github.com/mh-cbon/go-msi/util"
+	"github.com/mh-cbon/go-msi/wix"
+	"github.com/mh-cbon/go-msi/wxl"
+)
+
+// Options of a build.
+type Options struct {
+	Src           string        // directory of the wix templates
+	Templates     string        // directory of the templates, and *.tmpl blocks, overriding those of Src, optional
+	Out           string        // build directory, it is emptied, a temporary directory when empty
+	Msi           string        // path of the msi file to produce, or of the msix file with the msix Format
+	Format        string        // msi (default) or msix
+	Toolchain     wix.Toolchain // the WiX toolset, detected when nil
+	Jobs          int           // compile processes run at once
+	CacheDir      string        // directory of the compiled templates cache, empty disables it
+	Keep          bool          // keep the build directory
+	Deterministic bool          // derive the missing guids, instead of generating random guids
+	DryRun        bool          // stop before running the toolchain, the build directory is kept
+	Validate      bool          // run the ICE validation of the msi packages, an ICE error fails the build
+	Artifacts     bool          // write the sbom and the build metadata of the msi packages next to them
+	GoMsiVersion  string        // version of go-msi, written to the build metadata
+}
+
+// Result of a build.
+type Result struct {
+	Dir         string           // build directory, removed unless Options.Keep or Options.DryRun
+	GuidChanges []string         // the guids generated for the build, see manifest.WixManifest.GuidChanges
+	Manifest    []byte           // the resolved manifest, as JSON
+	Templates   []string         // the generated templates
+	Cmd         string           // the commands of the toolchain
+	Signed      []string         // the files signed, or to sign with DryRun
+	Msi         []string         // the msi files produced, one per language, or the msix file
+	Ices        []wix.IceMessage // the messages of the ICE validation, with Options.Validate
+	Artifacts   []string         // the sbom and build metadata files, with Options.Artifacts
+}
+
+// StageError is the error which failed a stage of the build,
+// manifest, templates, build or sign.
+type StageError struct {
+	Stage string
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return e.Err.Error()
+}
+
+func stageError(stage string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &StageError{Stage: stage, Err: err}
+}
+
+// Build builds the msi packages of wixFile, the loaded manifest,
+// it normalizes wixFile and rewrites its paths relatively to the build directory.
+// The toolchain commands stop when ctx is done, they are run by the runner of ctx, see wix.WithRunner.
+func Build(ctx context.Context, wixFile *manifest.WixManifest, opts Options) (*Result, error) {
+	if opts.Msi == "" {
+		return nil, stageError("manifest", fmt.Errorf("The msi file path must be set"))
+	}
+	if opts.Format != "" && opts.Format != "msi" && opts.Format != "msix" {
+		return nil, stageError("manifest", fmt.Errorf("Unknown format %q, expected msi or msix", opts.Format))
+	}
+	ret := &Result{Dir: opts.Out}
+	if ret.Dir == "" {
+		dir, err := ioutil.TempDir("", "go-msi")
+		if err != nil {
+			return nil, err
+		}
+		ret.Dir = dir
+	}
+	toolchain := opts.Toolchain
+	if toolchain == nil && opts.Format != "msix" {
+		var err error
+		if toolchain, err = wix.FindToolchain("auto"); err != nil {
+			return nil, stageError("build", err)
+		}
+	}
+
+	if err := prepareManifest(wixFile, opts, ret); err != nil {
+		return nil, stageError("manifest", err)
+	}
+
+	if err := CleanDir(ret.Dir, opts.CacheDir); err != nil {
+		return nil, err
+	}
+
+	if wixFile.Signing.Enabled() && wixFile.Signing.Executables {
+		if err := signFiles(ctx, wixFile.Signing, wixFile.Executables(), opts.DryRun, ret); err != nil {
+			return nil, stageError("sign", err)
+		}
+	}
+
+	if opts.Format == "msix" {
+		return buildMsix(ctx, wixFile, opts, ret)
+	}
+
+	if harvestsCom(wixFile) {
+		done := logger.Default.Stage("harvest")
+		err := harvestCom(ctx, wixFile, ret.Dir, opts.DryRun)
+		done(err)
+		if err != nil {
+			return nil, stageError("harvest", err)
+		}
+	}
+
+	done := logger.Default.Stage("templates")
+	err := generateTemplates(wixFile, opts, ret)
+	done(err, ret.Templates...)
+	if err != nil {
+		return nil, stageError("templates", err)
+	}
+
+	msiFile, err := filepath.Abs(opts.Msi)
+	if err != nil {
+		return nil, err
+	}
+	msi, err := relPath(ret.Dir, msiFile)
+	if err != nil {
+		return nil, err
+	}
+	msi = wixFile.BuildPath(msi)
+
+	ret.Cmd = toolchain.Cmd(wixFile, ret.Templates, msi, wixFile.Arch)
+	if err = ioutil.WriteFile(filepath.Join(ret.Dir, "build.bat"), []byte(ret.Cmd), 0644); err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun {
+		if wixFile.Signing.Enabled() {
+			ret.Signed = append(ret.Signed, wixFile.MsiFiles(msiFile)...)
+		}
+		return ret, nil
+	}
+
+	done = logger.Default.Stage("build")
+	err = toolchain.Build(ctx, wixFile, ret.Dir, ret.Templates, msi, wixFile.Arch, opts.Jobs, opts.CacheDir)
+	done(err, wixFile.MsiFiles(msiFile)...)
+	if err != nil {
+		return nil, stageError("build", err)
+	}
+	ret.Msi = wixFile.MsiFiles(msiFile)
+
+	if opts.Validate {
+		done = logger.Default.Stage("validate")
+		err = validate(ctx, toolchain, wixFile, ret)
+		done(err)
+		if err != nil {
+			return nil, stageError("validate", err)
+		}
+	}
+
+	if wixFile.Signing.Enabled() {
+		if err := signFiles(ctx, wixFile.Signing, ret.Msi, false, ret); err != nil {
+			return nil, stageError("sign", err)
+		}
+	}
+
+	if opts.Artifacts {
+		done = logger.Default.Stage("artifacts")
+		err = writeArtifacts(ctx, toolchain, wixFile, opts, ret)
+		done(err, ret.Artifacts...)
+		if err != nil {
+			return nil, stageError("artifacts", err)
+		}
+	}
+	return ret, cleanup(opts, ret)
+}
+
+// writeArtifacts writes the sbom, <msi>.cdx.json, and the build metadata, <msi>.build.json,
+// of the msi files of ret, before the build directory is removed.
+func writeArtifacts(ctx context.Context, toolchain wix.Toolchain, wixFile *manifest.WixManifest, opts Options, ret *Result) error {
+	name, version := wix.ToolchainVersion(toolchain)
+	tools := map[string]string{"go-msi": opts.GoMsiVersion, name: version}
+	for _, msi := range ret.Msi {
+		productCode := wixFile.ProductCode
+		if productCode == "" {
+			var err error
+			if productCode, err = wix.ProductCode(ctx, msi); err != nil {
+				return err
+			}
+		}
+		meta, err := artifacts.NewMetadata(wixFile, msi, productCode, tools)
+		if err != nil {
+			return err
+		}
+		sbom, err := artifacts.NewSbom(wixFile, msi, ret.Dir, tools)
+		if err != nil {
+			return err
+		}
+		if err := artifacts.WriteJSON(msi+".build.json", meta); err != nil {
+			return err
+		}
+		if err := artifacts.WriteJSON(msi+".cdx.json", sbom); err != nil {
+			return err
+		}
+		ret.Artifacts = append(ret.Artifacts, msi+".cdx.json", msi+".build.json")
+	}
+	return nil
+}
+
+// Target is a build of BuildTargets.
+type Target struct {
+	Name     string                // name of the target, such as its arch or its profile
+	Manifest *manifest.WixManifest // the loaded manifest, each target has its own
+	Options  Options               // the options of the build, Out and Msi must be distinct
+}
+
+// TargetResult is the result of the build of a target.
+type TargetResult struct {
+	Name   string
+	Result *Result
+	Err    error
+}
+
+// BuildTargets builds the targets at once, see Build.
+// The results are in the order of the targets,
+// the error lists the targets which failed.
+func BuildTargets(ctx context.Context, targets []Target) ([]TargetResult, error) {
+	outs := map[string]string{}
+	for _, t := range targets {
+		for _, p := range []string{t.Options.Out, t.Options.Msi} {
+			if p == "" {
+				continue
+			}
+			p, err := filepath.Abs(p)
+			if err != nil {
+				return nil, err
+			}
+			if other, ok := outs[p]; ok {
+				return nil, stageError("manifest", fmt.Errorf("The targets %q and %q both write %v", other, t.Name, p))
+			}
+			outs[p] = t.Name
+		}
+		for _, other := range targets {
+			if other.Name != t.Name && other.Manifest == t.Manifest {
+				return nil, stageError("manifest", fmt.Errorf("The targets %q and %q share their manifest", other.Name, t.Name))
+			}
+		}
+	}
+
+	rets := make([]TargetResult, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, t Target) {
+			defer wg.Done()
+			ret, err := Build(ctx, t.Manifest, t.Options)
+			rets[i] = TargetResult{Name: t.Name, Result: ret, Err: err}
+		}(i, t)
+	}
+	wg.Wait()
+
+	if len(rets) == 1 {
+		return rets, rets[0].Err
+	}
+	failed := []string{}
+	for _, r := range rets {
+		if r.Err != nil {
+			failed = append(failed, fmt.Sprintf("%v: %v", r.Name, r.Err))
+		}
+	}
+	if len(failed) > 0 {
+		return rets, fmt.Errorf("%d of %d targets failed:\n- %v", len(failed), len(targets), strings.Join(failed, "\n- "))
+	}
+	return rets, nil
+}
+
+// prepareManifest sets the missing guids of wixFile, then normalizes and validates it.
+func prepareManifest(wixFile *manifest.WixManifest, opts Options, ret *Result) error {
+	if wixFile.NeedGUID() {
+		before, err := json.Marshal(wixFile)
+		if err != nil {
+			return err
+		}
+		setGuids := wixFile.SetGuids
+		if opts.Deterministic || wixFile.StableGuids {
+			setGuids = wixFile.SetStableGuids
+		}
+		if _, err := setGuids(false); err != nil {
+			return err
+		}
+		if ret.GuidChanges, err = wixFile.GuidChanges(before); err != nil {
+			return err
+		}
+	}
+	if err := wixFile.Normalize(); err != nil {
+		return err
+	}
+	if err := wixFile.Validate(); err != nil {
+		return err
+	}
+	// the signing password is not printed, nor written, with the resolved manifest
+	resolved := *wixFile
+	resolved.Signing.Password = ""
+	byt, err := json.MarshalIndent(resolved, "", "  ")
+	ret.Manifest = byt
+	return err
+}
+
+// generateTemplates writes the templates, and the files they reference, into the build directory.
+func generateTemplates(wixFile *manifest.WixManifest, opts Options, ret *Result) error {
+	if err := wixFile.RewriteFilePaths(ret.Dir); err != nil {
+		return err
+	}
+	if err := PrepareLicense(wixFile, ret.Dir); err != nil {
+		return err
+	}
+	if err := PrepareIcons(wixFile, ret.Dir); err != nil {
+		return err
+	}
+	if err := PrepareLocalizations(wixFile, ret.Dir); err != nil {
+		return err
+	}
+	if err := PrepareConfigs(wixFile, ret.Dir); err != nil {
+		return err
+	}
+	templates, err := tpls.FindWithOverrides(opts.Src, opts.Templates, "*.wxs")
+	if err != nil {
+		return err
+	}
+	if len(templates) == 0 {
+		return fmt.Errorf("No templates *.wxs found in this directory")
+	}
+	partials, err := tpls.FindPartials(opts.Templates)
+	if err != nil {
+		return err
+	}
+	for _, tpl := range templates {
+		dst := filepath.Join(ret.Dir, filepath.Base(tpl))
+		if err := tpls.GenerateTemplate(wixFile, tpl, dst, partials...); err != nil {
+			return err
+		}
+		ret.Templates = append(ret.Templates, dst)
+	}
+	fragments, err := tpls.GenerateFragments(wixFile, templates, ret.Dir, partials...)
+	if err != nil {
+		return err
+	}
+	ret.Templates = append(ret.Templates, fragments...)
+	return nil
+}
+
+// harvestsCom tells if a com entry of wixFile is harvested.
+func harvestsCom(wixFile *manifest.WixManifest) bool {
+	for _, c := range wixFile.Com {
+		if c.Harvest {
+			return true
+		}
+	}
+	return false
+}
+
+// harvestCom runs heat on the files of the com entries of wixFile which set harvest,
+// a dry run does not run it, the templates miss their registration.
+func harvestCom(ctx context.Context, wixFile *manifest.WixManifest, dir string, dryRun bool) error {
+	for i, c := range wixFile.Com {
+		if !c.Harvest {
+			continue
+		}
+		file, err := filepath.Abs(c.File)
+		if err != nil {
+			return err
+		}
+		if dryRun {
+			logger.Default.Info("heat %s", strings.Join(wix.HeatArgs(file, c.FileKey+".heat"), " "))
+			continue
+		}
+		reg, err := wix.HarvestCom(ctx, dir, file, c.FileKey)
+		if err != nil {
+			return fmt.Errorf("Failed to harvest the COM registration of %q: %v", c.File, err)
+		}
+		wixFile.Com[i].HarvestedFile = reg.File
+		wixFile.Com[i].HarvestedRegistry = reg.Registry
+	}
+	return nil
+}
+
+// validate runs the ICE validation of the msi files of ret,
+// the messages are logged as warnings, it fails when an ICE reports an error.
+func validate(ctx context.Context, toolchain wix.Toolchain, wixFile *manifest.WixManifest, ret *Result) error {
+	errors := 0
+	for _, msi := range ret.Msi {
+		ices, err := toolchain.Validate(ctx, wixFile, ret.Dir, msi)
+		if err != nil {
+			return err
+		}
+		for _, ice := range ices {
+			logger.Default.Warn("%v", ice)
+			if ice.Level == "error" {
+				errors++
+			}
+		}
+		ret.Ices = append(ret.Ices, ices...)
+	}
+	if errors > 0 {
+		return fmt.Errorf("The ICE validation reported %d errors, fix them or add the ICEs to \"validation.suppress\"", errors)
+	}
+	return nil
+}
+
+// msixUnsupported returns the keys of wixFile a msix package ignores.
+func msixUnsupported(wixFile *manifest.WixManifest) []string {
+	ret := []string{}
+	keys := []struct {
+		name string
+		set  bool
+	}{
+		{"env", !wixFile.Env.Empty()},
+		{"registry", len(wixFile.Registry) > 0},
+		{"services", len(wixFile.Services) > 0},
+		{"scheduled-tasks", len(wixFile.ScheduledTasks) > 0},
+		{"drivers", len(wixFile.Drivers) > 0},
+		{"event-sources", len(wixFile.EventSources) > 0},
+		{"performance-counters", len(wixFile.PerfCategories) > 0},
+		{"com", len(wixFile.Com) > 0},
+		{"file-associations", len(wixFile.FileAssociations) > 0},
+		{"custom-actions", len(wixFile.CustomActions) > 0},
+		{"hooks", len(wixFile.Hooks) > 0},
+		{"launch-conditions", len(wixFile.Conditions) > 0},
+		{"configs", len(wixFile.Configs) > 0},
+	}
+	for _, k := range keys {
+		if k.set {
+			ret = append(ret, k.name)
+		}
+	}
+	return ret
+}
+
+// buildMsix builds the msix package of wixFile with makeappx,
+// its applications are the exe files its shortcuts target.
+func buildMsix(ctx context.Context, wixFile *manifest.WixManifest, opts Options, ret *Result) (*Result, error) {
+	for _, k := range msixUnsupported(wixFile) {
+		logger.Default.Warn("%q is not supported by msix packages, it is ignored", k)
+	}
+	if wixFile.Msix.Logo == "" {
+		return nil, stageError("manifest", fmt.Errorf(`"msix.logo" must be set to build a msix package`))
+	}
+	if len(wixFile.Msix.Applications) == 0 {
+		return nil, stageError("manifest", fmt.Errorf("The msix package has no application, add a shortcut to an installed exe file"))
+	}
+
+	done := logger.Default.Stage("templates")
+	err := layoutMsix(wixFile, opts, ret)
+	done(err, ret.Templates...)
+	if err != nil {
+		return nil, stageError("templates", err)
+	}
+
+	msixFile, err := filepath.Abs(opts.Msi)
+	if err != nil {
+		return nil, err
+	}
+	msix, err := relPath(ret.Dir, msixFile)
+	if err != nil {
+		return nil, err
+	}
+	ret.Cmd = wix.MsixCmd("layout", msix)
+	if err = ioutil.WriteFile(filepath.Join(ret.Dir, "build.bat"), []byte(ret.Cmd), 0644); err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun {
+		if wixFile.Signing.Enabled() {
+			ret.Signed = append(ret.Signed, msixFile)
+		}
+		return ret, nil
+	}
+
+	done = logger.Default.Stage("build")
+	err = wix.Msix(ctx, ret.Dir, "layout", msix)
+	done(err, msixFile)
+	if err != nil {
+		return nil, stageError("build", err)
+	}
+	ret.Msi = []string{msixFile}
+
+	if wixFile.Signing.Enabled() {
+		if err := signFiles(ctx, wixFile.Signing, ret.Msi, false, ret); err != nil {
+			return nil, stageError("sign", err)
+		}
+	}
+	return ret, cleanup(opts, ret)
+}
+
+// layoutMsix copies the files of wixFile, its logo and its AppxManifest.xml
+// into the layout directory of the build directory.
+func layoutMsix(wixFile *manifest.WixManifest, opts Options, ret *Result) error {
+	if err := wixFile.RewriteFilePaths(ret.Dir); err != nil {
+		return err
+	}
+	layout := filepath.Join(ret.Dir, "layout")
+	for _, f := range wixFile.Files.Items {
+		if err := copyFile(filepath.Join(layout, filepath.Base(f)), filepath.Join(ret.Dir, f)); err != nil {
+			return err
+		}
+	}
+	for _, g := range wixFile.FileGroups {
+		for _, f := range g.Items {
+			if err := copyFile(filepath.Join(layout, filepath.FromSlash(g.Dir), filepath.Base(f)), filepath.Join(ret.Dir, f)); err != nil {
+				return err
+			}
+		}
+	}
+	for _, tree := range wixFile.DirTrees {
+		if err := copyTree(tree, ret.Dir, layout); err != nil {
+			return err
+		}
+	}
+
+	if !ico.IsPng(wixFile.Msix.Logo) {
+		return fmt.Errorf("The msix logo %q must be a png file", wixFile.Msix.Logo)
+	}
+	if err := copyFile(filepath.Join(layout, "Assets", "logo.png"), wixFile.Msix.Logo); err != nil {
+		return err
+	}
+
+	templates, err := tpls.FindWithOverrides(filepath.Join(opts.Src, "msix"), opts.Templates, "AppxManifest.xml")
+	if err != nil {
+		return err
+	}
+	if len(templates) == 0 {
+		return fmt.Errorf("No AppxManifest.xml template found in this directory")
+	}
+	partials, err := tpls.FindPartials(opts.Templates)
+	if err != nil {
+		return err
+	}
+	dst := filepath.Join(layout, "AppxManifest.xml")
+	if err := tpls.GenerateTemplate(wixFile, templates[0], dst, partials...); err != nil {
+		return err
+	}
+	ret.Templates = append(ret.Templates, dst)
+	return nil
+}
+
+// relPath returns the absolute path p relative to dir.
+func relPath(dir, p string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(dir, p)
+}
+
+// copyTree copies the files of the harvested directory tree, their sources are relative to dir,
+// into the parent directory.
+func copyTree(tree manifest.WixDir, dir, parent string) error {
+	target := filepath.Join(parent, tree.Name)
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	for _, f := range tree.Files {
+		if err := copyFile(filepath.Join(target, filepath.Base(f.Source)), filepath.Join(dir, f.Source)); err != nil {
+			return err
+		}
+	}
+	for _, sub := range tree.Dirs {
+		if err := copyTree(sub, dir, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies src to dst, it creates the directory of dst.
+func copyFile(dst, src string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	return util.CopyFile(dst, src)
+}
+
+// signFiles signs the files, unless dryRun, and adds them to the Signed files of ret.
+func signFiles(ctx context.Context, spec manifest.SigningSpec, files []string, dryRun bool, ret *Result) error {
+	done := logger.Default.Stage("sign")
+	for _, f := range files {
+		if !dryRun {
+			if err := sign.Sign(ctx, spec, f); err != nil {
+				done(err)
+				return err
+			}
+			logger.Default.Info("Signed %s", f)
+		}
+		ret.Signed = append(ret.Signed, f)
+	}
+	done(nil, files...)
+	return nil
+}
+
+// cleanup removes the build directory, unless opts.Keep,
+// the cache of the compiled templates is kept for the next builds.
+func cleanup(opts Options, ret *Result) error {
+	if opts.Keep {
+		return nil
+	}
+	if opts.CacheDir != "" {
+		return CleanDir(ret.Dir, opts.CacheDir)
+	}
+	return os.RemoveAll(ret.Dir)
+}
+
+// CleanDir empties the dir directory but its keep sub directory,
+// it creates dir if it does not exist.
+func CleanDir(dir string, keep string) error {
+	if err := os.MkdirAll(dir, 0744); err != nil {
+		return err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		p := filepath.Join(dir, f.Name())
+		if keep != "" && p == filepath.Clean(keep) {
+			continue
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PrepareLicense converts the license file of the manifest to RTF into out
+// when it is not already an RTF file,
+// then rewrites its path relatively to out.
+func PrepareLicense(wixFile *manifest.WixManifest, out string) error {
+	if wixFile.License == "" {
+		return nil
+	}
+	out, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	target, err := filepath.Abs(wixFile.License)
+	if err != nil {
+		return err
+	}
+	if rtf.IsMarkdown(wixFile.License) {
+		target = filepath.Join(out, filepath.Base(wixFile.License)+".rtf")
+		if err := rtf.WriteMarkdownAsRtf(wixFile.License, target); err != nil {
+			return err
+		}
+	} else if !rtf.IsRtf(wixFile.License) {
+		target = filepath.Join(out, filepath.Base(wixFile.License)+".rtf")
+		if err := rtf.WriteAsUnicodeRtf(wixFile.License, target); err != nil {
+			return err
+		}
+	}
+	wixFile.License, err = filepath.Rel(out, target)
+	return err
+}
+
+// PrepareIcons converts the PNG icons of the shortcuts, and of the arp entry, to ICO into out,
+// icon paths must be relative to out.
+func PrepareIcons(wixFile *manifest.WixManifest, out string) error {
+	var err error
+	for i, s := range wixFile.Shortcuts.Items {
+		wixFile.Shortcuts.Items[i].Icon, err = prepareIcon(s.Icon, out, fmt.Sprintf("ShortcutIcon%d.ico", i))
+		if err != nil {
+			return fmt.Errorf("Failed to convert icon of shortcut %q: %v", s.Name, err)
+		}
+	}
+	wixFile.ARP.Icon, err = prepareIcon(wixFile.ARP.Icon, out, "ARPIcon.ico")
+	if err != nil {
+		return fmt.Errorf("Failed to convert icon of arp: %v", err)
+	}
+	return nil
+}
+
+// prepareIcon converts the PNG icon to ICO into the file name of out, and returns its new path,
+// each icon has its own name, the icons of the same name in different directories do not collide.
+func prepareIcon(icon, out, name string) (string, error) {
+	if icon == "" || !ico.IsPng(filepath.Join(out, icon)) {
+		return icon, nil
+	}
+	if err := ico.WriteFromPng(filepath.Join(out, icon), filepath.Join(out, name)); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// PrepareConfigs renders the configs of the manifest into out,
+// their file paths are relative to out.
+// A rendered file gets the modification time of its source, so it does not invalidate the wixobj cache.
+func PrepareConfigs(wixFile *manifest.WixManifest, out string) error {
+	configs := append([]manifest.WixConfig{}, wixFile.Configs...)
+	for i, c := range configs {
+		file := filepath.Join("configs", fmt.Sprint(i), c.Name)
+		target := filepath.Join(out, file)
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if !c.IsTemplate() {
+			if err := util.CopyFile(target, c.Source); err != nil {
+				return fmt.Errorf("Failed to copy the config %q: %v", c.Source, err)
+			}
+		} else if err := tpls.GenerateTemplate(wixFile, c.Source, target); err != nil {
+			return fmt.Errorf("Failed to render the config %q: %v", c.Source, err)
+		}
+		s, err := os.Stat(c.Source)
+		if err != nil {
+			return err
+		}
+		if err := os.Chtimes(target, s.ModTime(), s.ModTime()); err != nil {
+			return err
+		}
+		configs[i].File = file
+	}
+	wixFile.Configs = configs
+	return nil
+}
+
+// PrepareLocalizations writes the localization file of each language into out.
+func PrepareLocalizations(wixFile *manifest.WixManifest, out string) error {
+	for _, c := range wixFile.Cultures {
+		if err := wxl.Write(c, filepath.Join(out, c.WxlFile())); err != nil {
+			return fmt.Errorf("Failed to write the localization file of %q: %v", c.Name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package ico
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+var (
+	icoMagic = []byte{0, 0, 1, 0}
+	pngMagic = []byte{0x89, 'P', 'N', 'G', '\r', '\n', 0x1a, '\n'}
+)
+
+// IsIco Detects if the given src file is an ICO file.
+func IsIco(src string) bool {
+	return hasMagic(src, icoMagic)
+}
+
+// IsPng Detects if the given src file is a PNG file.
+func IsPng(src string) bool {
+	return hasMagic(src, pngMagic)
+}
+
+func hasMagic(src string, magic []byte) bool {
+	f, err := os.Open(src)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(f, head); err != nil {
+		return false
+	}
+	return bytes.Equal(head, magic)
+}
+
+// WriteFromPng Reads given PNG src file,
+// wraps it into an ICO file and writes the result to dst.
+// ICO files embedding a PNG image are supported since windows vista.
+func WriteFromPng(src string, dst string) error {
+	png, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	// the IHDR chunk follows the magic, it starts with the width and height.
+	if len(png) < 24 || !bytes.Equal(png[:8], pngMagic) {
+		return fmt.Errorf("%q is not a PNG file", src)
+	}
+	width := binary.BigEndian.Uint32(png[16:20])
+	height := binary.BigEndian.Uint32(png[20:24])
+	if width > 256 || height > 256 {
+		return fmt.Errorf("%q is %dx%d, an icon can not exceed 256x256", src, width, height)
+	}
+
+	var b bytes.Buffer
+	b.Write(icoMagic)
+	binary.Write(&b, binary.LittleEndian, uint16(1)) // image count
+	b.WriteByte(byte(width % 256))                   // 0 means 256
+	b.WriteByte(byte(height % 256))
+	b.WriteByte(0)                                    // palette size
+	b.WriteByte(0)                                    // reserved
+	binary.Write(&b, binary.LittleEndian, uint16(1))  // color planes
+	binary.Write(&b, binary.LittleEndian, uint16(32)) // bits per pixel
+	binary.Write(&b, binary.LittleEndian, uint32(len(png)))
+	binary.Write(&b, binary.LittleEndian, uint32(6+16)) // offset of the image data
+	b.Write(png)
+
+	return ioutil.WriteFile(dst, b.Bytes(), 0644)
+}
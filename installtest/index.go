@@ -0,0 +1,332 @@
+// Package installtest installs a msi package silently, checks the files, shortcuts,
+// environment variables and registry values of its manifest are installed,
+// then uninstalls it and checks they are removed.
+// It runs msiexec and reg, on windows only.
+package installtest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/mh-cbon/go-msi/logger"
+	"github.com/mh-cbon/go-msi/manifest"
+	"github.com/mh-cbon/go-msi/wix"
+)
+
+// Options of an install test.
+type Options struct {
+	Msi    string // path of the msi file to install
+	Target string // install directory, a temporary directory when empty
+	LogDir string // directory of the msiexec logs, the install directory parent when empty
+	Keep   bool   // leave the package installed, the uninstall is not checked, it requires Target
+}
+
+// Check is the result of the verification of an item of the manifest.
+type Check struct {
+	Phase string // install or uninstall
+	Item  string // such as file C:\hello\hello.exe
+	OK    bool
+}
+
+func (c Check) String() string {
+	status := "ok"
+	if !c.OK {
+		status = "FAIL"
+	}
+	return fmt.Sprintf("%-4v %v: %v", status, c.Phase, c.Item)
+}
+
+// Failed returns the failed checks.
+func Failed(checks []Check) []Check {
+	ret := []Check{}
+	for _, c := range checks {
+		if !c.OK {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// item is an installed item, exists tells if it is found,
+// removed tells if it must be removed by the uninstall.
+type item struct {
+	name    string
+	exists  func() (bool, error)
+	removed bool
+}
+
+// Run installs the msi package of wixFile, the normalized manifest whose paths are relative to the working directory,
+// with all its features, then uninstalls it, unless opts.Keep,
+// it returns the checks made after each step. An error is returned when msiexec fails.
+func Run(ctx context.Context, wixFile *manifest.WixManifest, opts Options) ([]Check, error) {
+	if runtime.GOOS != "windows" {
+		return nil, fmt.Errorf("The install test runs on windows only")
+	}
+	msi, err := filepath.Abs(opts.Msi)
+	if err != nil {
+		return nil, err
+	}
+	target := opts.Target
+	installed := false
+	if target == "" {
+		if opts.Keep {
+			return nil, fmt.Errorf("The install directory is required to keep the package installed")
+		}
+		if target, err = ioutil.TempDir("", "go-msi-test"); err != nil {
+			return nil, err
+		}
+		// the temporary directory is removed unless the package is left installed in it
+		defer func() {
+			if !installed {
+				os.RemoveAll(target)
+			}
+		}()
+	}
+	if target, err = filepath.Abs(target); err != nil {
+		return nil, err
+	}
+	logDir := opts.LogDir
+	if logDir == "" {
+		logDir = filepath.Dir(target)
+	}
+
+	items, err := installedItems(wixFile, target)
+	if err != nil {
+		return nil, err
+	}
+
+	checks := []Check{}
+	install := []string{"/i", msi, "/qn", "/norestart", "INSTALLDIR=" + target + `\`, "ADDLOCAL=ALL"}
+	if err := msiexec(ctx, filepath.Join(logDir, "install.log"), install...); err != nil {
+		return nil, err
+	}
+	installed = true
+	uninstall := func() error {
+		err := msiexec(ctx, filepath.Join(logDir, "uninstall.log"), "/x", msi, "/qn", "/norestart")
+		if err == nil {
+			installed = false
+		}
+		return err
+	}
+
+	for _, i := range items {
+		ok, err := i.exists()
+		if err != nil {
+			if !opts.Keep {
+				if uerr := uninstall(); uerr != nil {
+					logger.Default.Warn("The package is left installed: %v", uerr)
+				}
+			}
+			return checks, err
+		}
+		checks = append(checks, Check{Phase: "install", Item: i.name, OK: ok})
+	}
+	if opts.Keep {
+		return checks, nil
+	}
+
+	if err := uninstall(); err != nil {
+		return checks, err
+	}
+	for _, i := range items {
+		if !i.removed {
+			continue
+		}
+		ok, err := i.exists()
+		if err != nil {
+			return checks, err
+		}
+		checks = append(checks, Check{Phase: "uninstall", Item: i.name + " is removed", OK: !ok})
+	}
+	return checks, nil
+}
+
+// installedItems returns the items wixFile installs into target.
+func installedItems(wixFile *manifest.WixManifest, target string) ([]item, error) {
+	ret := []item{}
+	addFile := func(p string) {
+		ret = append(ret, item{name: "file " + p, exists: fileExists(p), removed: true})
+	}
+	for _, f := range wixFile.Files.Items {
+		addFile(filepath.Join(target, filepath.Base(f)))
+	}
+	for _, g := range wixFile.FileGroups {
+		for _, f := range g.Items {
+			addFile(filepath.Join(target, filepath.FromSlash(g.Dir), filepath.Base(f)))
+		}
+	}
+	if err := wixFile.RewriteFilePaths("."); err != nil {
+		return nil, err
+	}
+	var walk func(d manifest.WixDir, parent string)
+	walk = func(d manifest.WixDir, parent string) {
+		dir := filepath.Join(parent, d.Name)
+		for _, f := range d.Files {
+			addFile(filepath.Join(dir, filepath.Base(f.Source)))
+		}
+		for _, sub := range d.Dirs {
+			walk(sub, dir)
+		}
+	}
+	for _, tree := range wixFile.DirTrees {
+		walk(tree, target)
+	}
+
+	for _, s := range wixFile.Shortcuts.Items {
+		candidates := shortcutPaths(wixFile, s, target)
+		ret = append(ret, item{name: "shortcut " + candidates[0], exists: anyExists(candidates), removed: true})
+	}
+
+	expand := func(s string) string {
+		return strings.Replace(s, "[INSTALLDIR]", target+`\`, -1)
+	}
+	for _, e := range wixFile.Env.Vars {
+		if e.Action == "remove" {
+			continue
+		}
+		key := envKey(e.System == "yes")
+		value := ""
+		if e.Part == "first" || e.Part == "last" {
+			value = expand(e.Value)
+		}
+		ret = append(ret, item{
+			name:    fmt.Sprintf("env %v=%v", e.Name, expand(e.Value)),
+			exists:  regExists(key, e.Name, value, ""),
+			removed: e.Permanent != "yes",
+		})
+	}
+	for _, p := range wixFile.Env.Path {
+		dir := expand(p.Dir)
+		ret = append(ret, item{
+			name:    "PATH entry " + dir,
+			exists:  regExists(envKey(p.System != "no"), "PATH", dir, ""),
+			removed: true,
+		})
+	}
+	view := "/reg:32"
+	if wixFile.Arch == "amd64" || wixFile.Arch == "x64" || wixFile.Arch == "arm64" {
+		view = "/reg:64"
+	}
+	for _, r := range wixFile.Registry {
+		key := r.Root + `\` + r.Key
+		ret = append(ret, item{
+			name:    fmt.Sprintf("registry value %v %q", key, r.Name),
+			exists:  regExists(key, r.Name, "", view),
+			removed: !r.Permanent,
+		})
+	}
+	return ret, nil
+}
+
+// shortcutPaths returns the paths the shortcut s can be installed to, the per user and the all users locations.
+func shortcutPaths(wixFile *manifest.WixManifest, s manifest.WixShortcut, target string) []string {
+	name := s.Name + ".lnk"
+	programs := []string{
+		filepath.Join(os.Getenv("APPDATA"), "Microsoft", "Windows", "Start Menu", "Programs"),
+		filepath.Join(os.Getenv("ProgramData"), "Microsoft", "Windows", "Start Menu", "Programs"),
+	}
+	switch s.Location {
+	case "desktop":
+		return []string{
+			filepath.Join(os.Getenv("USERPROFILE"), "Desktop", name),
+			filepath.Join(os.Getenv("PUBLIC"), "Desktop", name),
+		}
+	case "startup":
+		return []string{filepath.Join(programs[0], "Startup", name), filepath.Join(programs[1], "Startup", name)}
+	case "programFolder":
+		return []string{filepath.Join(target, name)}
+	}
+	return []string{
+		filepath.Join(programs[0], wixFile.Shortcuts.StartMenuFolder, name),
+		filepath.Join(programs[1], wixFile.Shortcuts.StartMenuFolder, name),
+	}
+}
+
+// envKey returns the registry key of the system, or of the user, environment variables.
+func envKey(system bool) string {
+	if system {
+		return `HKLM\SYSTEM\CurrentControlSet\Control\Session Manager\Environment`
+	}
+	return `HKCU\Environment`
+}
+
+func fileExists(p string) func() (bool, error) {
+	return anyExists([]string{p})
+}
+
+func anyExists(paths []string) func() (bool, error) {
+	return func() (bool, error) {
+		for _, p := range paths {
+			if _, err := os.Stat(p); err == nil {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+var regValueRe = regexp.MustCompile(`^\s+(.*?)\s+(REG_\w+)\s*(.*)$`)
+
+// regExists tells if the registry key has the value name,
+// when segment is set, the value must contain it, as a ; separated segment.
+// view selects the 32 or 64 bits registry view.
+func regExists(key, name, segment, view string) func() (bool, error) {
+	return func() (bool, error) {
+		args := []string{"query", key}
+		if name == "" {
+			args = append(args, "/ve")
+		} else {
+			args = append(args, "/v", name)
+		}
+		if view != "" {
+			args = append(args, view)
+		}
+		out, err := exec.Command("reg", args...).Output()
+		if err != nil {
+			if _, ok := err.(*exec.ExitError); ok {
+				return false, nil // the value does not exist
+			}
+			return false, err
+		}
+		if segment == "" {
+			return true, nil
+		}
+		for _, line := range strings.Split(string(out), "\n") {
+			m := regValueRe.FindStringSubmatch(strings.TrimRight(line, "\r"))
+			if m == nil {
+				continue
+			}
+			for _, s := range strings.Split(m[3], ";") {
+				if strings.EqualFold(strings.TrimRight(s, `\`), strings.TrimRight(segment, `\`)) {
+					return true, nil
+				}
+			}
+		}
+		return false, nil
+	}
+}
+
+// msiexec runs msiexec with args, its verbose log is written to log,
+// the exit code 3010 tells a reboot is required, it is not an error.
+func msiexec(ctx context.Context, log string, args ...string) error {
+	args = append(args, "/l*v", log)
+	logger.Default.Debug("msiexec %v", strings.Join(args, " "))
+	cmd := exec.Command("msiexec", args...)
+	cmd.Stdout = logger.Default.Output("msiexec")
+	cmd.Stderr = cmd.Stdout
+	err := wix.Run(ctx, cmd)
+	if e, ok := err.(*wix.ToolError); ok && e.ExitCode == 3010 {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("msiexec %v failed: %v, see its log %v", args[0], err, log)
+	}
+	return nil
+}
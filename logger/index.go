@@ -0,0 +1,193 @@
+// Package logger reports the progress of the builds,
+// as text for the terminal, or as JSON events for the CI.
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Level is the verbosity of a text Logger.
+type Level int
+
+const (
+	// Quiet prints the warnings only.
+	Quiet Level = iota
+	// Normal prints the progress messages and the output of the tools.
+	Normal
+	// Verbose also prints the debug messages, such as the commands run.
+	Verbose
+)
+
+// Logger receives the progress of a build.
+type Logger interface {
+	// Info logs a progress message.
+	Info(format string, args ...interface{})
+	// Debug logs a detail, such as a command being run.
+	Debug(format string, args ...interface{})
+	// Warn logs a problem which does not fail the build.
+	Warn(format string, args ...interface{})
+	// Stage logs the start of the stage name,
+	// the returned func logs its end, with the error which failed it
+	// and the files it produced.
+	Stage(name string) func(err error, artifacts ...string)
+	// Output returns the writer receiving the output of the tool name, line by line.
+	Output(name string) io.Writer
+}
+
+// Default is the logger of the commands, set from their flags.
+var Default Logger = NewText(os.Stdout, Normal)
+
+// Text prints the messages to W, the output of the tools is prefixed with their name.
+type Text struct {
+	W     io.Writer
+	Level Level
+	mu    sync.Mutex
+}
+
+// NewText returns a Text logger of level writing to w.
+func NewText(w io.Writer, level Level) *Text {
+	return &Text{W: w, Level: level}
+}
+
+func (t *Text) printf(level Level, format string, args ...interface{}) {
+	if t.Level < level {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fmt.Fprintf(t.W, format+"\n", args...)
+}
+
+// Info prints the message unless the level is Quiet.
+func (t *Text) Info(format string, args ...interface{}) {
+	t.printf(Normal, format, args...)
+}
+
+// Debug prints the message when the level is Verbose.
+func (t *Text) Debug(format string, args ...interface{}) {
+	t.printf(Verbose, format, args...)
+}
+
+// Warn prints the message prefixed with Warning.
+func (t *Text) Warn(format string, args ...interface{}) {
+	t.printf(Quiet, "Warning: "+format, args...)
+}
+
+// Stage prints the start and the end of the stage when the level is Verbose.
+func (t *Text) Stage(name string) func(err error, artifacts ...string) {
+	start := time.Now()
+	t.Debug("%v started", name)
+	return func(err error, artifacts ...string) {
+		if err != nil {
+			t.Debug("%v failed after %v: %v", name, time.Since(start), err)
+			return
+		}
+		t.Debug("%v finished in %v", name, time.Since(start))
+	}
+}
+
+// Output returns a writer printing the lines of the tool name prefixed with [name],
+// unless the level is Quiet.
+func (t *Text) Output(name string) io.Writer {
+	return &lineWriter{fn: func(line string) {
+		t.printf(Normal, "[%v] %v", name, line)
+	}}
+}
+
+// JSON writes one JSON object per event to W.
+type JSON struct {
+	W  io.Writer
+	mu sync.Mutex
+}
+
+// NewJSON returns a JSON logger writing to w.
+func NewJSON(w io.Writer) *JSON {
+	return &JSON{W: w}
+}
+
+// Event is a JSON log entry.
+type Event struct {
+	Time      time.Time `json:"time"`
+	Event     string    `json:"event"` // info, debug, warn, stage-started, stage-finished or output
+	Message   string    `json:"message,omitempty"`
+	Stage     string    `json:"stage,omitempty"`
+	Duration  float64   `json:"duration,omitempty"` // seconds
+	Error     string    `json:"error,omitempty"`
+	Artifacts []string  `json:"artifacts,omitempty"`
+	Tool      string    `json:"tool,omitempty"`
+}
+
+func (j *JSON) write(e Event) {
+	e.Time = time.Now()
+	byt, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.W.Write(append(byt, '\n'))
+}
+
+// Info writes an info event.
+func (j *JSON) Info(format string, args ...interface{}) {
+	j.write(Event{Event: "info", Message: fmt.Sprintf(format, args...)})
+}
+
+// Debug writes a debug event.
+func (j *JSON) Debug(format string, args ...interface{}) {
+	j.write(Event{Event: "debug", Message: fmt.Sprintf(format, args...)})
+}
+
+// Warn writes a warn event.
+func (j *JSON) Warn(format string, args ...interface{}) {
+	j.write(Event{Event: "warn", Message: fmt.Sprintf(format, args...)})
+}
+
+// Stage writes a stage-started event, and a stage-finished event when the stage ends.
+func (j *JSON) Stage(name string) func(err error, artifacts ...string) {
+	start := time.Now()
+	j.write(Event{Event: "stage-started", Stage: name})
+	return func(err error, artifacts ...string) {
+		e := Event{Event: "stage-finished", Stage: name, Duration: time.Since(start).Seconds(), Artifacts: artifacts}
+		if err != nil {
+			e.Error = err.Error()
+		}
+		j.write(e)
+	}
+}
+
+// Output returns a writer writing an output event per line of the tool name.
+func (j *JSON) Output(name string) io.Writer {
+	return &lineWriter{fn: func(line string) {
+		j.write(Event{Event: "output", Tool: name, Message: line})
+	}}
+}
+
+// lineWriter calls fn for each complete line written,
+// a line written without its end is kept until the next write.
+type lineWriter struct {
+	fn  func(line string)
+	buf []byte
+	mu  sync.Mutex
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.fn(string(bytes.TrimRight(w.buf[:i], "\r")))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
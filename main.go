@@ -2,19 +2,32 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"embed"
+	"encoding/json"
 	"fmt"
+	"io/fs"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
 	"strings"
 
 	"github.com/Masterminds/semver"
+	"github.com/mh-cbon/go-msi/artifacts"
+	"github.com/mh-cbon/go-msi/builder"
+	"github.com/mh-cbon/go-msi/installtest"
+	"github.com/mh-cbon/go-msi/logger"
 	"github.com/mh-cbon/go-msi/manifest"
 	"github.com/mh-cbon/go-msi/rtf"
+	"github.com/mh-cbon/go-msi/scoop"
+	"github.com/mh-cbon/go-msi/sign"
 	"github.com/mh-cbon/go-msi/tpls"
 	"github.com/mh-cbon/go-msi/util"
+	"github.com/mh-cbon/go-msi/winget"
 	"github.com/mh-cbon/go-msi/wix"
 	"github.com/mh-cbon/stringexec"
 	"github.com/urfave/cli"
@@ -27,6 +40,89 @@ var VERSION = "0.0.0"
 // Should be used only for non windows systems to indicate template locations.
 var TPLPATH = "" // non-windows build, use ldflags to tell about that.
 
+// embeddedTemplates are the default templates, used when TPLPATH has no templates.
+//
+//go:embed templates
+var embeddedTemplates embed.FS
+
+// extractedTemplates is the temporary directory the embedded templates are extracted to.
+var extractedTemplates string
+
+// profileFlag selects the manifest profile to apply.
+var profileFlag = cli.StringFlag{
+	Name:  "profile",
+	Value: "",
+	Usage: "Name of the manifest profiles entry overriding the manifest",
+}
+
+var toolFlags = []cli.Flag{
+	cli.DurationFlag{
+		Name:  "tool-timeout",
+		Usage: "Maximum duration of each command of the WiX toolset, or of choco, such as 10m, unlimited by default",
+	},
+	cli.IntFlag{
+		Name:  "tool-retries",
+		Usage: "Count of the runs again of a command which timed out",
+	},
+	cli.StringFlag{
+		Name:  "tool-env",
+		Value: "",
+		Usage: "Comma separated KEY=value variables added to the environment of the commands",
+	},
+}
+
+// toolContext returns a context whose tool commands are run with the tool flags of c.
+func toolContext(c *cli.Context) (context.Context, error) {
+	opts := wix.ToolOptions{
+		Timeout: c.Duration("tool-timeout"),
+		Retries: c.Int("tool-retries"),
+		Env:     splitList(c.String("tool-env")),
+	}
+	for _, v := range opts.Env {
+		if !strings.Contains(v, "=") {
+			return nil, fmt.Errorf("Invalid --tool-env variable %q, expected KEY=value", v)
+		}
+	}
+	return wix.WithToolOptions(context.Background(), opts), nil
+}
+
+var pathMapFlag = cli.StringFlag{
+	Name:  "path-map",
+	Value: "",
+	Usage: "Comma separated host=build path prefixes, the file paths of the templates are written as the remote, or wine, WiX toolset sees them, such as /=Z:\\",
+}
+
+// signingFlags are the flags of the commands signing files,
+// they override the signing settings of the manifest.
+var signingFlags = []cli.Flag{
+	cli.StringFlag{
+		Name:  "sign-certificate",
+		Value: "",
+		Usage: "Path to the pfx file to sign with",
+	},
+	cli.StringFlag{
+		Name:  "sign-thumbprint",
+		Value: "",
+		Usage: "Sha1 thumbprint of the certificate of the store to sign with",
+	},
+	cli.StringFlag{
+		Name:   "sign-password",
+		Value:  "",
+		Usage:  "Password of the signing certificate",
+		EnvVar: "GO_MSI_SIGN_PASSWORD",
+	},
+	cli.StringFlag{
+		Name:  "sign-timestamp-url",
+		Value: "",
+		Usage: "URL of the RFC 3161 timestamp server",
+	},
+	cli.StringFlag{
+		Name:  "sign-digest",
+		Value: "",
+		Usage: "Digest algorithm, sha1, sha256 (default), sha384 or sha512",
+	},
+}
+
 func main() {
 
 	if TPLPATH == "" { // built for windows
@@ -46,16 +142,248 @@ func main() {
 	app.Version = VERSION
 	app.Usage = "Easy msi pakage for Go"
 	app.UsageText = "go-msi <cmd> <options>"
+	app.Flags = []cli.Flag{
+		cli.BoolFlag{
+			Name:  "verbose",
+			Usage: "Print the commands run and the build stages",
+		},
+		cli.BoolFlag{
+			Name:  "quiet, q",
+			Usage: "Print the warnings only, not the progress nor the output of the tools",
+		},
+		cli.BoolFlag{
+			Name:  "json-log",
+			Usage: "Print the progress as JSON events, one per line",
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		switch {
+		case c.Bool("json-log"):
+			logger.Default = logger.NewJSON(os.Stdout)
+		case c.Bool("quiet"):
+			logger.Default = logger.NewText(os.Stdout, logger.Quiet)
+		case c.Bool("verbose"):
+			logger.Default = logger.NewText(os.Stdout, logger.Verbose)
+		}
+		return nil
+	}
 	app.Commands = []cli.Command{
+		{
+			Name:   "init",
+			Usage:  "Create a wix manifest, asking for its values",
+			Action: initManifest,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "path, p",
+					Value: "wix.json",
+					Usage: "Path to the wix manifest file to create, its extension selects json, yaml or toml",
+				},
+				cli.BoolFlag{
+					Name:  "defaults, y",
+					Usage: "Do not ask, use the flags and the default values",
+				},
+				cli.BoolFlag{
+					Name:  "force, f",
+					Usage: "Overwrite an existing manifest file",
+				},
+				cli.StringFlag{
+					Name:  "product",
+					Value: "",
+					Usage: "Name of the product, defaults to the name of the current directory",
+				},
+				cli.StringFlag{
+					Name:  "company",
+					Value: "",
+					Usage: "Name of the company, defaults to the product name",
+				},
+				cli.StringFlag{
+					Name:  "version",
+					Value: "",
+					Usage: "Version of the product, defaults to 0.0.1",
+				},
+				cli.StringFlag{
+					Name:  "license",
+					Value: "",
+					Usage: "Path to the license file, defaults to the LICENSE file of the current directory",
+				},
+				cli.StringFlag{
+					Name:  "exe",
+					Value: "",
+					Usage: "Path to the main executable, defaults to the product name with an exe extension",
+				},
+				cli.StringFlag{
+					Name:  "templates",
+					Value: "",
+					Usage: "Directory to copy the wix templates to, to customize them",
+				},
+				cli.StringFlag{
+					Name:  "src, s",
+					Value: filepath.Join(TPLPATH, "templates"),
+					Usage: "Directory path to the wix templates files to copy",
+				},
+			},
+		},
+		{
+			Name:   "generate",
+			Usage:  "Create a wix manifest installing the files and directories of a build output directory",
+			Action: generateManifest,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "path, p",
+					Value: "wix.json",
+					Usage: "Path to the wix manifest file to create, its extension selects json, yaml or toml",
+				},
+				cli.StringFlag{
+					Name:  "src, s",
+					Value: "",
+					Usage: "Directory path to the files to install",
+				},
+				cli.BoolFlag{
+					Name:  "force, f",
+					Usage: "Overwrite an existing manifest file",
+				},
+				cli.StringFlag{
+					Name:  "product",
+					Value: "",
+					Usage: "Name of the product, defaults to the name of the current directory",
+				},
+				cli.StringFlag{
+					Name:  "company",
+					Value: "",
+					Usage: "Name of the company, defaults to the product name",
+				},
+				cli.StringFlag{
+					Name:  "version",
+					Value: "",
+					Usage: "Version of the product, defaults to 0.0.1",
+				},
+				cli.StringFlag{
+					Name:  "license",
+					Value: "",
+					Usage: "Path to the license file, defaults to the LICENSE file of the current directory",
+				},
+			},
+		},
 		{
 			Name:   "check-json",
-			Usage:  "Check the JSON wix manifest",
+			Usage:  "Check the JSON wix manifest, its values and the files it references",
 			Action: checkJSON,
 			Flags: []cli.Flag{
 				cli.StringFlag{
 					Name:  "path, p",
 					Value: "wix.json",
-					Usage: "Path to the wix manifest file",
+					Usage: "Path to the wix manifest file, - to read it from stdin",
+				},
+				profileFlag,
+				cli.BoolFlag{
+					Name:  "strict",
+					Usage: "Fail on unknown fields and type mismatches of the manifest",
+				},
+				cli.BoolFlag{
+					Name:  "choco",
+					Usage: "Also check the fields needed to make a chocolatey package",
+				},
+			},
+		},
+		{
+			Name:   "validate",
+			Usage:  "Validate the manifest and print what would be generated, without running wix nor writing files",
+			Action: validate,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "path, p",
+					Value: "wix.json",
+					Usage: "Path to the wix manifest file, - to read it from stdin",
+				},
+				profileFlag,
+				cli.BoolFlag{
+					Name:  "strict",
+					Usage: "Fail on unknown fields and type mismatches of the manifest",
+				},
+				cli.StringFlag{
+					Name:  "src, s",
+					Value: filepath.Join(TPLPATH, "templates"),
+					Usage: "Directory path to the wix templates files",
+				},
+				cli.StringFlag{
+					Name:  "templates, t",
+					Value: "",
+					Usage: "Directory path to override templates, any file found replaces the built-in template of the same name",
+				},
+				cli.StringFlag{
+					Name:  "out, o",
+					Value: tmpBuildDir,
+					Usage: "Directory path the build files would be generated to",
+				},
+				cli.StringFlag{
+					Name:  "arch, a",
+					Value: "",
+					Usage: "A target architecture, 386, amd64 or arm64, overrides the manifest arch",
+				},
+				cli.StringFlag{
+					Name:  "wix-version",
+					Value: "auto",
+					Usage: "Version of the WiX toolset, 3, 4, 5 or auto to detect the installed one",
+				},
+				cli.StringFlag{
+					Name:  "backend",
+					Value: "wix",
+					Usage: "Builder of the msi package, wix, or wixl of msitools to build on linux or macOS",
+				},
+				cli.StringFlag{
+					Name:  "msi, m",
+					Value: "",
+					Usage: "Path the resulting msi file would be written to",
+				},
+				cli.StringFlag{
+					Name:  "version",
+					Value: "",
+					Usage: "The version of your program, auto derives it from git describe --tags",
+				},
+				cli.StringFlag{
+					Name:  "license, l",
+					Value: "",
+					Usage: "Path to the license file",
+				},
+				cli.StringFlag{
+					Name:  "type",
+					Value: "msi",
+					Usage: "Type of the package, msi, or msm to build a merge module, overrides the manifest module flag",
+				},
+				pathMapFlag,
+			},
+		},
+		{
+			Name:   "schema",
+			Usage:  "Print the JSON Schema of the wix manifest",
+			Action: printSchema,
+		},
+		{
+			Name:   "export-templates",
+			Usage:  "Write the templates embedded in go-msi to a directory, to customize them",
+			Action: exportTemplates,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "out, o",
+					Value: "templates",
+					Usage: "Directory path to write the templates to",
+				},
+				cli.BoolFlag{
+					Name:  "force, f",
+					Usage: "Overwrite the existing templates",
+				},
+			},
+		},
+		{
+			Name:      "info",
+			Usage:     "Print the product code, the upgrade code, the version, the properties and the files of a msi file as JSON",
+			ArgsUsage: "<msi file>",
+			Action:    msiInfo,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "property",
+					Value: "",
+					Usage: "Print only the value of this property, such as ProductCode",
 				},
 			},
 		},
@@ -72,12 +400,16 @@ func main() {
 				cli.StringFlag{
 					Name:  "path, p",
 					Value: "wix.json",
-					Usage: "Path to the wix manifest file",
+					Usage: "Path to the wix manifest file, - to read it from stdin",
 				},
 				cli.BoolFlag{
 					Name:  "force, f",
 					Usage: "Force update the guids",
 				},
+				cli.BoolFlag{
+					Name:  "deterministic, d",
+					Usage: "Derive the guids from the product, company and install locations instead of generating random guids",
+				},
 			},
 		},
 		{
@@ -88,13 +420,20 @@ func main() {
 				cli.StringFlag{
 					Name:  "path, p",
 					Value: "wix.json",
-					Usage: "Path to the wix manifest file",
+					Usage: "Path to the wix manifest file, - to read it from stdin",
 				},
+				profileFlag,
+				pathMapFlag,
 				cli.StringFlag{
 					Name:  "src, s",
 					Value: filepath.Join(TPLPATH, "templates"),
 					Usage: "Directory path to the wix templates files",
 				},
+				cli.StringFlag{
+					Name:  "templates, t",
+					Value: "",
+					Usage: "Directory path to override templates, any file found replaces the built-in template of the same name",
+				},
 				cli.StringFlag{
 					Name:  "out, o",
 					Value: tmpBuildDir,
@@ -103,13 +442,71 @@ func main() {
 				cli.StringFlag{
 					Name:  "version",
 					Value: "",
-					Usage: "The version of your program",
+					Usage: "The version of your program, auto derives it from git describe --tags",
+				},
+				cli.StringFlag{
+					Name:  "license, l",
+					Value: "",
+					Usage: "Path to the license file",
+				},
+				cli.StringFlag{
+					Name:  "type",
+					Value: "msi",
+					Usage: "Type of the package, msi, or msm to build a merge module, overrides the manifest module flag",
+				},
+			},
+		},
+		{
+			Name:   "templates-diff",
+			Usage:  "Render the wix templates and diff them against their golden copy, the templates are also linted",
+			Action: templatesDiff,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "path, p",
+					Value: "wix.json",
+					Usage: "Path to the wix manifest file, - to read it from stdin",
+				},
+				profileFlag,
+				pathMapFlag,
+				cli.StringFlag{
+					Name:  "src, s",
+					Value: filepath.Join(TPLPATH, "templates"),
+					Usage: "Directory path to the wix templates files",
+				},
+				cli.StringFlag{
+					Name:  "templates, t",
+					Value: "",
+					Usage: "Directory path to override templates, any file found replaces the built-in template of the same name",
+				},
+				cli.StringFlag{
+					Name:  "golden, g",
+					Value: "golden",
+					Usage: "Directory path to the golden copies of the generated wix templates",
+				},
+				cli.StringFlag{
+					Name:  "out, o",
+					Value: "build",
+					Usage: "Directory path to the generated wix templates files, the file paths of the templates are relative to it",
+				},
+				cli.StringFlag{
+					Name:  "version",
+					Value: "",
+					Usage: "The version of your program, auto derives it from git describe --tags",
 				},
 				cli.StringFlag{
 					Name:  "license, l",
 					Value: "",
 					Usage: "Path to the license file",
 				},
+				cli.StringFlag{
+					Name:  "type",
+					Value: "msi",
+					Usage: "Type of the package, msi, or msm to build a merge module, overrides the manifest module flag",
+				},
+				cli.BoolFlag{
+					Name:  "update, u",
+					Usage: "Write the generated templates to the golden directory, instead of diffing them",
+				},
 			},
 		},
 		{
@@ -137,7 +534,7 @@ func main() {
 				cli.StringFlag{
 					Name:  "src, s",
 					Value: "",
-					Usage: "Path to a text file",
+					Usage: "Path to a text, or Markdown, file",
 				},
 				cli.StringFlag{
 					Name:  "out, o",
@@ -158,28 +555,45 @@ func main() {
 				cli.StringFlag{
 					Name:  "path, p",
 					Value: "wix.json",
-					Usage: "Path to the wix manifest file",
+					Usage: "Path to the wix manifest file, - to read it from stdin",
 				},
+				profileFlag,
 				cli.StringFlag{
 					Name:  "src, s",
 					Value: filepath.Join(TPLPATH, "templates"),
 					Usage: "Directory path to the wix templates files",
 				},
+				cli.StringFlag{
+					Name:  "templates, t",
+					Value: "",
+					Usage: "Directory path to override templates, any file found replaces the built-in template of the same name",
+				},
 				cli.StringFlag{
 					Name:  "out, o",
 					Value: tmpBuildDir,
 					Usage: "Directory path to the generated wix cmd file",
 				},
+				cli.StringFlag{
+					Name:  "file, f",
+					Value: "build.bat",
+					Usage: "Path to the generated wix cmd file, relative to --out",
+				},
 				cli.StringFlag{
 					Name:  "arch, a",
 					Value: "",
-					Usage: "A target architecture, amd64 or 386 (ia64 is not handled)",
+					Usage: "A target architecture, 386, amd64 or arm64, overrides the manifest arch",
+				},
+				cli.StringFlag{
+					Name:  "wix-version",
+					Value: "auto",
+					Usage: "Version of the WiX toolset, 3, 4, 5 or auto to detect the installed one",
 				},
 				cli.StringFlag{
 					Name:  "msi, m",
 					Value: "",
 					Usage: "Path to write resulting msi file to",
 				},
+				pathMapFlag,
 			},
 		},
 		{
@@ -192,23 +606,35 @@ func main() {
 					Value: tmpBuildDir,
 					Usage: "Directory path to the generated wix cmd file",
 				},
+				cli.StringFlag{
+					Name:  "file, f",
+					Value: "build.bat",
+					Usage: "Path to the generated wix cmd file, relative to --out",
+				},
 			},
 		},
 		{
 			Name:   "make",
 			Usage:  "All-in-one command to make MSI files",
 			Action: quickMake,
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				cli.StringFlag{
 					Name:  "path, p",
 					Value: "wix.json",
-					Usage: "Path to the wix manifest file",
+					Usage: "Path to the wix manifest file, - to read it from stdin",
 				},
+				profileFlag,
+				pathMapFlag,
 				cli.StringFlag{
 					Name:  "src, s",
 					Value: filepath.Join(TPLPATH, "templates"),
 					Usage: "Directory path to the wix templates files",
 				},
+				cli.StringFlag{
+					Name:  "templates, t",
+					Value: "",
+					Usage: "Directory path to override templates, any file found replaces the built-in template of the same name",
+				},
 				cli.StringFlag{
 					Name:  "out, o",
 					Value: tmpBuildDir,
@@ -217,7 +643,27 @@ func main() {
 				cli.StringFlag{
 					Name:  "arch, a",
 					Value: "",
-					Usage: "A target architecture, amd64 or 386 (ia64 is not handled)",
+					Usage: "A target architecture, 386, amd64 or arm64, overrides the manifest arch",
+				},
+				cli.StringFlag{
+					Name:  "targets",
+					Value: "",
+					Usage: "Comma separated target architectures, such as x86,x64, built at once, each msi file name gets the arch as suffix",
+				},
+				cli.StringFlag{
+					Name:  "profiles",
+					Value: "",
+					Usage: "Comma separated manifest profiles built at once, with each of the targets, each msi file name gets the profile as suffix",
+				},
+				cli.StringFlag{
+					Name:  "wix-version",
+					Value: "auto",
+					Usage: "Version of the WiX toolset, 3, 4, 5 or auto to detect the installed one",
+				},
+				cli.StringFlag{
+					Name:  "backend",
+					Value: "wix",
+					Usage: "Builder of the msi package, wix, or wixl of msitools to build on linux or macOS",
 				},
 				cli.StringFlag{
 					Name:  "msi, m",
@@ -227,70 +673,416 @@ func main() {
 				cli.StringFlag{
 					Name:  "version",
 					Value: "",
-					Usage: "The version of your program",
+					Usage: "The version of your program, auto derives it from git describe --tags",
 				},
 				cli.StringFlag{
 					Name:  "license, l",
 					Value: "",
 					Usage: "Path to the license file",
 				},
+				cli.StringFlag{
+					Name:  "type",
+					Value: "msi",
+					Usage: "Type of the package, msi, or msm to build a merge module, overrides the manifest module flag",
+				},
+				cli.BoolFlag{
+					Name:  "validate",
+					Usage: "Run the ICE validation of the msi packages, with smoke of WiX 3 or wix msi validate",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Value: "msi",
+					Usage: "Format of the package, msi, or msix built with makeappx of the Windows SDK, --msi is then the msix file path",
+				},
+				cli.BoolFlag{
+					Name:  "deterministic, d",
+					Usage: "Derive missing guids from the product, company and install locations instead of generating random guids",
+				},
+				cli.BoolFlag{
+					Name:  "sign",
+					Usage: "Sign the msi file, fails if no certificate is set by the manifest or the flags",
+				},
 				cli.BoolFlag{
 					Name:  "keep, k",
 					Usage: "Keep output directory containing build files (useful for debug)",
 				},
-			},
+				cli.IntFlag{
+					Name:  "jobs, j",
+					Value: runtime.NumCPU(),
+					Usage: "Maximum number of templates compiled at once",
+				},
+				cli.BoolFlag{
+					Name:  "dry-run, n",
+					Usage: "Print the manifest, the guid changes, the wix templates and the commands, without building",
+				},
+				cli.BoolFlag{
+					Name:  "no-cache",
+					Usage: "Compile all the templates again, the cache of the output directory is removed",
+				},
+				cli.BoolFlag{
+					Name:  "artifacts",
+					Usage: "Write a CycloneDX sbom and the build metadata next to each msi file, and their SHA256SUMS file",
+				},
+			}, append(signingFlags, toolFlags...)...),
 		},
 		{
-			Name:   "choco",
-			Usage:  "Generate a chocolatey package of your msi files",
-			Action: chocoMake,
-			Flags: []cli.Flag{
+			Name:      "sign",
+			Usage:     "Sign msi files, or other files, with signtool",
+			ArgsUsage: "<file>...",
+			Action:    signFiles,
+			Flags: append([]cli.Flag{
 				cli.StringFlag{
 					Name:  "path, p",
 					Value: "wix.json",
-					Usage: "Path to the wix manifest file",
+					Usage: "Path to the wix manifest file, its signing settings are used when it exists",
 				},
+				profileFlag,
+			}, append(signingFlags, toolFlags...)...),
+		},
+		{
+			Name:   "test-install",
+			Usage:  "Install the msi file silently, check its files, shortcuts, env vars and registry values, then uninstall it",
+			Action: testInstall,
+			Flags: []cli.Flag{
 				cli.StringFlag{
-					Name:  "src, s",
-					Value: filepath.Join(TPLPATH, "templates", "choco"),
-					Usage: "Directory path to the wix templates files",
+					Name:  "path, p",
+					Value: "wix.json",
+					Usage: "Path to the wix manifest file, - to read it from stdin",
 				},
+				profileFlag,
 				cli.StringFlag{
 					Name:  "version",
 					Value: "",
-					Usage: "The version of your program",
+					Usage: "The version of your program, auto derives it from git describe --tags",
 				},
 				cli.StringFlag{
-					Name:  "out, o",
-					Value: tmpBuildDir,
-					Usage: "Directory path to the generated chocolatey build file",
+					Name:  "msi, m",
+					Value: "",
+					Usage: "Path to the msi file to install",
 				},
 				cli.StringFlag{
-					Name:  "input, i",
+					Name:  "target, t",
 					Value: "",
-					Usage: "Path to the msi file to package into the chocolatey package",
+					Usage: "Directory to install to, a temporary directory by default",
 				},
 				cli.StringFlag{
-					Name:  "changelog-cmd, c",
+					Name:  "log-dir",
 					Value: "",
-					Usage: "A command to generate the content of the changlog in the package",
+					Usage: "Directory of the msiexec logs, the parent of the install directory by default",
+				},
+				cli.BoolFlag{
+					Name:  "keep, k",
+					Usage: "Leave the package installed, the uninstall is not tested, it requires --target",
+				},
+			},
+		},
+		{
+			Name:   "choco",
+			Usage:  "Generate a chocolatey package of your msi files",
+			Action: chocoMake,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "path, p",
+					Value: "wix.json",
+					Usage: "Path to the wix manifest file, - to read it from stdin",
+				},
+				profileFlag,
+				cli.StringFlag{
+					Name:  "src, s",
+					Value: filepath.Join(TPLPATH, "templates", "choco"),
+					Usage: "Directory path to the wix templates files",
+				},
+				cli.StringFlag{
+					Name:  "version",
+					Value: "",
+					Usage: "The version of your program, auto derives it from git describe --tags",
+				},
+				cli.StringFlag{
+					Name:  "out, o",
+					Value: tmpBuildDir,
+					Usage: "Directory path to the generated chocolatey build file",
+				},
+				cli.StringFlag{
+					Name:  "input, i",
+					Value: "",
+					Usage: "Path to the msi file to package into the chocolatey package",
+				},
+				cli.StringFlag{
+					Name:  "changelog-cmd, c",
+					Value: "",
+					Usage: "A command to generate the content of the changlog in the package",
 				},
 				cli.BoolFlag{
 					Name:  "keep, k",
 					Usage: "Keep output directory containing build files (useful for debug)",
 				},
+			}, toolFlags...),
+		},
+		{
+			Name:   "choco-push",
+			Usage:  "Push a chocolatey package to a feed",
+			Action: chocoPush,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "input, i",
+					Value: "",
+					Usage: "Path to the nupkg file to push",
+				},
+				cli.StringFlag{
+					Name:  "source, s",
+					Value: "https://push.chocolatey.org/",
+					Usage: "URL of the feed to push the package to",
+				},
+				cli.StringFlag{
+					Name:   "api-key, k",
+					Value:  "",
+					Usage:  "API key of the feed",
+					EnvVar: "CHOCO_API_KEY",
+				},
+			}, toolFlags...),
+		},
+		{
+			Name:   "winget",
+			Usage:  "Generate the winget manifests of your msi file",
+			Action: wingetMake,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "path, p",
+					Value: "wix.json",
+					Usage: "Path to the wix manifest file, - to read it from stdin",
+				},
+				profileFlag,
+				cli.StringFlag{
+					Name:  "version",
+					Value: "",
+					Usage: "The version of your program, auto derives it from git describe --tags",
+				},
+				cli.StringFlag{
+					Name:  "input, i",
+					Value: "",
+					Usage: "Path to the msi file",
+				},
+				cli.StringFlag{
+					Name:  "url, u",
+					Value: "",
+					Usage: "Download url of the msi file, defaults to winget.installer-url of the manifest",
+				},
+				cli.StringFlag{
+					Name:  "out, o",
+					Value: "winget",
+					Usage: "Directory path to write the manifests to",
+				},
+			},
+		},
+		{
+			Name:   "scoop",
+			Usage:  "Generate the scoop manifest of your msi file",
+			Action: scoopMake,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "path, p",
+					Value: "wix.json",
+					Usage: "Path to the wix manifest file, - to read it from stdin",
+				},
+				profileFlag,
+				cli.StringFlag{
+					Name:  "version",
+					Value: "",
+					Usage: "The version of your program, auto derives it from git describe --tags",
+				},
+				cli.StringFlag{
+					Name:  "input, i",
+					Value: "",
+					Usage: "Path to the msi file",
+				},
+				cli.StringFlag{
+					Name:  "url-template, u",
+					Value: "",
+					Usage: "Download url of the msi file, $version is replaced by the version, defaults to scoop.url-template of the manifest",
+				},
+				cli.StringFlag{
+					Name:  "out, o",
+					Value: "",
+					Usage: "Path to the manifest file to write, defaults to the scoop name with a json extension",
+				},
+			},
+		},
+		{
+			Name:   "scripts",
+			Usage:  "Generate the uninstall.ps1 and repair.ps1 scripts, which find the installed product by its upgrade code",
+			Action: scriptsMake,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "path, p",
+					Value: "wix.json",
+					Usage: "Path to the wix manifest file, - to read it from stdin",
+				},
+				profileFlag,
+				cli.StringFlag{
+					Name:  "src, s",
+					Value: filepath.Join(TPLPATH, "templates", "scripts"),
+					Usage: "Directory path to the script templates",
+				},
+				cli.StringFlag{
+					Name:  "out, o",
+					Value: ".",
+					Usage: "Directory path to write the scripts to",
+				},
 			},
 		},
+		{
+			Name:   "patch",
+			Usage:  "Make a msp patch updating the installs of an old release to a new release",
+			Action: patchMake,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "path, p",
+					Value: "wix.json",
+					Usage: "Path to the wix manifest file, - to read it from stdin",
+				},
+				profileFlag,
+				cli.StringFlag{
+					Name:  "src, s",
+					Value: filepath.Join(TPLPATH, "templates", "patch"),
+					Usage: "Directory path to the patch templates files",
+				},
+				cli.StringFlag{
+					Name:  "out, o",
+					Value: tmpBuildDir,
+					Usage: "Directory path to the generated patch build files",
+				},
+				cli.StringFlag{
+					Name:  "old",
+					Value: "",
+					Usage: "Path to the msi, or wixout, file of the old release",
+				},
+				cli.StringFlag{
+					Name:  "new",
+					Value: "",
+					Usage: "Path to the msi, or wixout, file of the new release",
+				},
+				cli.StringFlag{
+					Name:  "msp",
+					Value: "",
+					Usage: "Path to write resulting msp file to, defaults to the new release path with an msp extension",
+				},
+				cli.StringFlag{
+					Name:  "version",
+					Value: "",
+					Usage: "The version of the new release",
+				},
+				cli.BoolFlag{
+					Name:  "keep, k",
+					Usage: "Keep output directory containing build files (useful for debug)",
+				},
+			}, toolFlags...),
+		},
+		{
+			Name:   "bundle",
+			Usage:  "Make a bootstrapper exe installing the prerequisites of the manifest, then your msi file",
+			Action: bundleMake,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "path, p",
+					Value: "wix.json",
+					Usage: "Path to the wix manifest file, - to read it from stdin",
+				},
+				profileFlag,
+				cli.StringFlag{
+					Name:  "src, s",
+					Value: filepath.Join(TPLPATH, "templates", "bundle"),
+					Usage: "Directory path to the bundle templates files",
+				},
+				cli.StringFlag{
+					Name:  "out, o",
+					Value: tmpBuildDir,
+					Usage: "Directory path to the generated bundle build files",
+				},
+				cli.StringFlag{
+					Name:  "msi, m",
+					Value: "",
+					Usage: "Path to the msi file to bundle",
+				},
+				cli.StringFlag{
+					Name:  "exe, e",
+					Value: "",
+					Usage: "Path to write resulting exe file to, defaults to the msi path with an exe extension",
+				},
+				cli.StringFlag{
+					Name:  "version",
+					Value: "",
+					Usage: "The version of your program, auto derives it from git describe --tags",
+				},
+				cli.StringFlag{
+					Name:  "license, l",
+					Value: "",
+					Usage: "Path to the license file",
+				},
+				cli.BoolFlag{
+					Name:  "keep, k",
+					Usage: "Keep output directory containing build files (useful for debug)",
+				},
+			}, toolFlags...),
+		},
 	}
 
+	// a failed command exits within app.Run
+	exit := cli.OsExiter
+	cli.OsExiter = func(code int) {
+		removeExtractedTemplates()
+		exit(code)
+	}
 	app.Run(os.Args)
+	removeExtractedTemplates()
+}
+
+// removeExtractedTemplates removes the directory the embedded templates are extracted to, if any.
+func removeExtractedTemplates() {
+	if extractedTemplates != "" {
+		os.RemoveAll(extractedTemplates)
+	}
 }
 
 var verReg = regexp.MustCompile(`\s[0-9]+[.][0-9]+[.][0-9]+`)
 
+func msiInfo(c *cli.Context) error {
+	msi := c.Args().First()
+
+	if msi == "" {
+		return cli.NewExitError("The msi file must be given, go-msi info <msi file>", 1)
+	}
+	if _, err := os.Stat(msi); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if property := c.String("property"); property != "" {
+		props, err := wix.ReadProperties(context.Background(), msi)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		value, ok := props[property]
+		if !ok {
+			return cli.NewExitError(fmt.Sprintf("The msi file has no %v property", property), 1)
+		}
+		fmt.Println(value)
+		return nil
+	}
+
+	info, err := wix.ReadInfo(context.Background(), msi)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	byt, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Println(string(byt))
+	return nil
+}
+
 func checkEnv(c *cli.Context) error {
 
-	for _, b := range []string{"heat", "light", "candle"} {
+	for _, b := range []string{"light", "candle"} {
 		if out, err := util.Exec(b, "-h"); out == "" {
 			fmt.Printf("!!	%v not found: %q\n", b, err)
 		} else {
@@ -313,6 +1105,17 @@ func checkEnv(c *cli.Context) error {
 			}
 		}
 	}
+	if out, err := util.Exec("wix", "--version"); out == "" {
+		fmt.Printf("!!	%v not found: %q\n", "wix", err)
+	} else {
+		match := verReg.FindAllString(" "+out, -1)
+		if len(match) < 1 {
+			fmt.Printf("??	%v probably not found\n", "wix")
+		} else {
+			version := strings.TrimSpace(match[0])
+			fmt.Printf("ok	%v found %v, use --wix-version 4 if candle and light are also found\n", "wix", version)
+		}
+	}
 	if out, err := util.Exec("choco", "-v"); out == "" {
 		fmt.Printf("!!	%v not found: %q\n", "chocolatey", err)
 	} else {
@@ -337,80 +1140,370 @@ func checkEnv(c *cli.Context) error {
 	return nil
 }
 
-func checkJSON(c *cli.Context) error {
+func initManifest(c *cli.Context) error {
 	path := c.String("path")
 
-	wixFile := manifest.WixManifest{}
-	err := wixFile.Load(path)
+	if _, err := os.Stat(path); err == nil && !c.Bool("force") {
+		return cli.NewExitError(fmt.Sprintf("The manifest %q exists, use --force to overwrite it", path), 1)
+	}
+
+	wd, err := os.Getwd()
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
+	license := licenseFile()
 
-	for _, hook := range wixFile.Hooks {
-		if _, ok := manifest.HookPhases[hook.When]; !ok {
-			return cli.NewExitError(`Invalid "when" value in hook: `+hook.When, 1)
+	in := bufio.NewReader(os.Stdin)
+	// ask returns the flag value if it is set, the default value with --defaults,
+	// otherwise the answer of the user, or the default value if it is empty.
+	ask := func(flag, question, value string) (string, error) {
+		if c.IsSet(flag) {
+			return c.String(flag), nil
 		}
+		if c.Bool("defaults") {
+			return value, nil
+		}
+		fmt.Printf("%v [%v]: ", question, value)
+		answer, err := in.ReadString('\n')
+		if err != nil && answer == "" {
+			return "", fmt.Errorf("Failed to read the %v: %v", flag, err)
+		}
+		if answer = strings.TrimSpace(answer); answer != "" {
+			return answer, nil
+		}
+		return value, nil
 	}
 
-	fmt.Println("The manifest is syntaxically correct !")
-
-	if wixFile.NeedGUID() {
-		fmt.Println("The manifest needs Guid")
-		fmt.Println("To update your file automatically run:")
-		fmt.Println("     go-msi set-guid")
-		return cli.NewExitError("Incomplete manifest file detected", 1)
-	}
-	return nil
-}
-
-func setGUID(c *cli.Context) error {
-	path := c.String("path")
-	force := c.Bool("force")
-
 	wixFile := manifest.WixManifest{}
-	err := wixFile.Load(path)
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
+	exe := ""
+	for _, q := range []struct {
+		flag, question string
+		value          func() string
+		ret            *string
+	}{
+		{"product", "Product name", func() string { return filepath.Base(wd) }, &wixFile.Product},
+		{"company", "Company name", func() string { return wixFile.Product }, &wixFile.Company},
+		{"version", "Version", func() string { return "0.0.1" }, &wixFile.Version},
+		{"license", "License file, - for none", func() string { return license }, &wixFile.License},
+		{"exe", "Main executable, - for none", func() string { return wixFile.Product + ".exe" }, &exe},
+	} {
+		answer, err := ask(q.flag, q.question, q.value())
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if answer == "-" {
+			answer = ""
+		}
+		*q.ret = answer
+	}
+	if exe != "" {
+		wixFile.Files.Items = []string{exe}
+		wixFile.Shortcuts.Items = []manifest.WixShortcut{{
+			Name:   wixFile.Product,
+			Target: "[INSTALLDIR]" + filepath.Base(exe),
+			WDir:   "INSTALLDIR",
+		}}
 	}
 
-	updated, err := wixFile.SetGuids(force)
-	if err != nil {
+	if err := createManifest(path, &wixFile); err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
-	if updated {
-		fmt.Println("The manifest was updated")
-	} else {
-		fmt.Println("The manifest was not updated")
+	if dir := c.String("templates"); dir != "" {
+		src, err := templatesSrc(c)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if err := copyTemplates(src, dir); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Printf("The templates are copied to %q, use them with --src %v\n", dir, dir)
 	}
 
-	err = wixFile.Write(path)
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
+	// the built packages do not belong to the repository
+	if _, err := os.Stat(".git"); err == nil {
+		if err := ignoreFiles(".gitignore", "*.msi", "*.nupkg"); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
 	}
-	fmt.Println("The file is saved on disk")
-
 	return nil
 }
 
-func generateTemplates(c *cli.Context) error {
+func generateManifest(c *cli.Context) error {
 	path := c.String("path")
 	src := c.String("src")
-	out := c.String("out")
-	version := c.String("version")
-	license := c.String("license")
 
-	wixFile := manifest.WixManifest{}
-	err := wixFile.Load(path)
+	if src == "" {
+		return cli.NewExitError("--src parameter must be set", 1)
+	}
+	if _, err := os.Stat(path); err == nil && !c.Bool("force") {
+		return cli.NewExitError(fmt.Sprintf("The manifest %q exists, use --force to overwrite it", path), 1)
+	}
+	entries, err := ioutil.ReadDir(src)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
-	if wixFile.NeedGUID() {
-		fmt.Println("The manifest needs Guid")
-		fmt.Println("To update your file automatically run:")
+	wixFile := manifest.WixManifest{
+		Product: c.String("product"),
+		Company: c.String("company"),
+		Version: c.String("version"),
+		License: c.String("license"),
+	}
+	if wixFile.Product == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		wixFile.Product = filepath.Base(wd)
+	}
+	if wixFile.Company == "" {
+		wixFile.Company = wixFile.Product
+	}
+	if wixFile.Version == "" {
+		wixFile.Version = "0.0.1"
+	}
+	if !c.IsSet("license") {
+		wixFile.License = licenseFile()
+	}
+
+	// the files of src are installed into the install directory,
+	// its sub directories are recreated under it.
+	wixFile.Files.PerFile = true
+	for _, entry := range entries {
+		p := filepath.Join(src, entry.Name())
+		if entry.IsDir() {
+			wixFile.Directories = append(wixFile.Directories, p)
+			continue
+		}
+		wixFile.Files.Items = append(wixFile.Files.Items, p)
+		if strings.EqualFold(filepath.Ext(p), ".exe") {
+			wixFile.Shortcuts.Items = append(wixFile.Shortcuts.Items, manifest.WixShortcut{
+				Name:   strings.TrimSuffix(entry.Name(), filepath.Ext(p)),
+				Target: "[INSTALLDIR]" + entry.Name(),
+				WDir:   "INSTALLDIR",
+			})
+		}
+	}
+	if len(wixFile.Files.Items)+len(wixFile.Directories) == 0 {
+		return cli.NewExitError(fmt.Sprintf("The directory %q is empty", src), 1)
+	}
+
+	if err := createManifest(path, &wixFile); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("%v files, %v directories, %v shortcuts\n", len(wixFile.Files.Items), len(wixFile.Directories), len(wixFile.Shortcuts.Items))
+	for _, s := range wixFile.Shortcuts.Items {
+		fmt.Printf("- shortcut %q to %v, remove it from the manifest if it is not an application\n", s.Name, s.Target)
+	}
+	return nil
+}
+
+// licenseFile returns the license file of the current directory, if any.
+func licenseFile() string {
+	for _, f := range []string{"LICENSE", "LICENSE.txt", "LICENSE.md", "LICENSE.rtf"} {
+		if _, err := os.Stat(f); err == nil {
+			return f
+		}
+	}
+	return ""
+}
+
+// createManifest sets the guids of the new manifest wixFile,
+// validates it, then writes it to path.
+func createManifest(path string, wixFile *manifest.WixManifest) error {
+	if _, err := wixFile.SetGuids(false); err != nil {
+		return err
+	}
+	if err := wixFile.Validate(); err != nil {
+		return err
+	}
+	if err := wixFile.Write(path); err != nil {
+		return err
+	}
+	fmt.Printf("The manifest %q is saved on disk\n", path)
+	return nil
+}
+
+// copyTemplates copies the files of the src directory,
+// and of its sub directories, to dst.
+func copyTemplates(src, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		return util.CopyFile(filepath.Join(dst, rel), p)
+	})
+}
+
+func exportTemplates(c *cli.Context) error {
+	out := c.String("out")
+
+	if out == "" {
+		return cli.NewExitError("--out parameter must be set", 1)
+	}
+	if entries, err := ioutil.ReadDir(out); err == nil && len(entries) > 0 && !c.Bool("force") {
+		return cli.NewExitError(fmt.Sprintf("The directory %q is not empty, use --force to overwrite its templates", out), 1)
+	}
+	if err := writeEmbeddedTemplates(out); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Printf("The templates are written to %q, use them with --src %v\n", out, out)
+	return nil
+}
+
+// templatesSrc returns the templates directory of the --src flag,
+// the embedded templates are extracted to a temporary directory
+// when the flag is not set and the default directory does not exist.
+func templatesSrc(c *cli.Context) (string, error) {
+	src := c.String("src")
+	if c.IsSet("src") {
+		return src, nil
+	}
+	if _, err := os.Stat(src); err == nil {
+		return src, nil
+	}
+	rel, err := filepath.Rel(filepath.Join(TPLPATH, "templates"), src)
+	if err != nil {
+		return "", err
+	}
+	if extractedTemplates == "" {
+		dir, err := ioutil.TempDir("", "go-msi-templates")
+		if err != nil {
+			return "", err
+		}
+		extractedTemplates = dir
+		if err := writeEmbeddedTemplates(dir); err != nil {
+			return "", err
+		}
+	}
+	return filepath.Join(extractedTemplates, rel), nil
+}
+
+// writeEmbeddedTemplates writes the embedded templates into dst.
+func writeEmbeddedTemplates(dst string) error {
+	return fs.WalkDir(embeddedTemplates, "templates", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, filepath.FromSlash(strings.TrimPrefix(p, "templates")))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		dat, err := embeddedTemplates.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, dat, 0644)
+	})
+}
+
+// ignoreFiles appends the patterns missing from the ignore file p.
+func ignoreFiles(p string, patterns ...string) error {
+	dat, err := ioutil.ReadFile(p)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	lines := strings.Split(strings.Replace(string(dat), "\r\n", "\n", -1), "\n")
+	add := ""
+	for _, pattern := range patterns {
+		found := false
+		for _, line := range lines {
+			found = found || strings.TrimSpace(line) == pattern
+		}
+		if !found {
+			add += pattern + "\n"
+		}
+	}
+	if add == "" {
+		return nil
+	}
+	if len(dat) > 0 && dat[len(dat)-1] != '\n' {
+		add = "\n" + add
+	}
+	f, err := os.OpenFile(p, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.WriteString(add); err != nil {
+		f.Close()
+		return err
+	}
+	fmt.Printf("Added %v to %v\n", strings.Join(strings.Fields(add), ", "), p)
+	return f.Close()
+}
+
+func checkJSON(c *cli.Context) error {
+	path := c.String("path")
+
+	wixFile := manifest.WixManifest{Profile: c.String("profile")}
+	load := wixFile.Load
+	if c.Bool("strict") {
+		load = wixFile.LoadStrict
+	}
+	err := load(path)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if err := wixFile.Check(c.Bool("choco")); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	fmt.Println("The manifest is syntaxically correct !")
+
+	if wixFile.NeedGUID() && !wixFile.StableGuids {
+		fmt.Println("The manifest needs Guid")
+		fmt.Println("To update your file automatically run:")
 		fmt.Println("     go-msi set-guid")
-		return cli.NewExitError("Cannot proceed, manifest file is incomplete", 1)
+		return cli.NewExitError("Incomplete manifest file detected", 1)
+	}
+	return nil
+}
+
+func validate(c *cli.Context) error {
+	path := c.String("path")
+	src, err := templatesSrc(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	out := c.String("out")
+	version := c.String("version")
+	license := c.String("license")
+	msi := c.String("msi")
+	arch := c.String("arch")
+
+	toolchain, err := findToolchain(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	wixFile := manifest.WixManifest{Profile: c.String("profile")}
+	load := wixFile.Load
+	if c.Bool("strict") {
+		load = wixFile.LoadStrict
+	}
+	if err := load(path); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if wixFile.NeedGUID() {
+		// guids are set in memory only, the manifest is not written.
+		setGuids := wixFile.SetGuids
+		if wixFile.StableGuids {
+			setGuids = wixFile.SetStableGuids
+		}
+		if _, err := setGuids(false); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Println("The manifest needs Guid, they would be generated by go-msi make")
 	}
 
 	if c.IsSet("version") {
@@ -421,208 +1514,1204 @@ func generateTemplates(c *cli.Context) error {
 		wixFile.License = license
 	}
 
-	err = wixFile.Normalize()
-	if err != nil {
+	if err := applyPackageType(c, &wixFile); err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
-	err = wixFile.RewriteFilePaths(out)
-	if err != nil {
+	if err := applyPathMap(c, &wixFile); err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
-	templates, err := tpls.Find(src, "*.wxs")
+	if c.IsSet("arch") {
+		wixFile.Arch = arch
+	}
+
+	if msi == "" {
+		msi = wixFile.Product + ".msi"
+	}
+
+	if err := wixFile.Normalize(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if err := wixFile.Validate(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if err := wixFile.RewriteFilePaths(out); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	templates, err := tpls.FindWithOverrides(src, c.String("templates"), "*.wxs")
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 	if len(templates) == 0 {
 		return cli.NewExitError("No templates *.wxs found in this directory", 1)
 	}
+	partials, err := tpls.FindPartials(c.String("templates"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
 
-	err = os.MkdirAll(out, 0744)
+	builtTemplates := make([]string, len(templates))
+	for i, tpl := range templates {
+		err = tpls.ExecuteTemplate(&wixFile, tpl, ioutil.Discard, partials...)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		builtTemplates[i] = filepath.Join(out, filepath.Base(tpl))
+	}
+	fragments, err := tpls.GenerateFragments(&wixFile, templates, "", partials...)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	for _, f := range fragments {
+		builtTemplates = append(builtTemplates, filepath.Join(out, f))
+	}
+
+	msi, err = filepath.Abs(msi)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	// the msi path is relative to the build directory, as make writes it
+	absOut, err := filepath.Abs(out)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	msi, err = filepath.Rel(absOut, msi)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	msi = wixFile.BuildPath(msi)
+
+	fmt.Printf("Would generate %d templates\n", len(builtTemplates))
+	for i, tpl := range templates {
+		fmt.Printf("- %s (from %s)\n", builtTemplates[i], tpl)
+	}
+	for _, f := range builtTemplates[len(templates):] {
+		fmt.Printf("- %s (from the %s block)\n", f, tpls.FragmentBlock)
+	}
+	fmt.Println("Would run")
+	fmt.Print(toolchain.Cmd(&wixFile, builtTemplates, msi, wixFile.Arch))
+
+	fmt.Println("The manifest is valid !")
+
+	return nil
+}
+
+func printSchema(c *cli.Context) error {
+	byt, err := json.MarshalIndent(manifest.Schema(), "", "  ")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Println(string(byt))
+	return nil
+}
+
+func setGUID(c *cli.Context) error {
+	path := c.String("path")
+	force := c.Bool("force")
+
+	wixFile := manifest.WixManifest{}
+	err := wixFile.LoadRaw(path)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	// guids are needed for the sections inherited from the extended manifests too
+	extended := wixFile
+	if wixFile.Extends != "" {
+		if path == "-" {
+			return cli.NewExitError("Cannot set the guids of a manifest read from stdin which extends another manifest", 1)
+		}
+		extended = manifest.WixManifest{}
+		err = extended.LoadExtended(path)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
+	var updated bool
+	if c.Bool("deterministic") {
+		updated, err = extended.SetStableGuids(force)
+	} else {
+		updated, err = extended.SetGuids(force)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if wixFile.Extends != "" {
+		err = wixFile.AdoptGuids(&extended)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	} else {
+		wixFile = extended
+	}
+
+	if updated {
+		fmt.Println("The manifest was updated")
+	} else {
+		fmt.Println("The manifest was not updated")
+	}
+
+	if manifest.Format(path) == "toml" {
+		guids, err := wixFile.Guids()
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		fmt.Println("The TOML manifest is not rewritten, to keep its comments, set its guids:")
+		for _, g := range guids {
+			fmt.Println("  " + g)
+		}
+		return nil
+	}
+
+	err = wixFile.WriteGuids(path)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fmt.Println("The file is saved on disk")
+
+	return nil
+}
+
+func generateTemplates(c *cli.Context) error {
+	templates, err := renderTemplates(c, c.String("out"))
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
+	fmt.Printf("Generated %d templates\n", len(templates))
 	for _, tpl := range templates {
-		dst := filepath.Join(out, filepath.Base(tpl))
-		err = tpls.GenerateTemplate(&wixFile, tpl, dst)
+		fmt.Printf("- %s\n", tpl)
+	}
+
+	return nil
+}
+
+// renderTemplates renders the wix templates of the manifest of c into out,
+// with the flags of c applied, it returns the generated files.
+func renderTemplates(c *cli.Context, out string) ([]string, error) {
+	path := c.String("path")
+	src, err := templatesSrc(c)
+	if err != nil {
+		return nil, err
+	}
+	version := c.String("version")
+	license := c.String("license")
+
+	wixFile := manifest.WixManifest{Profile: c.String("profile")}
+	err = wixFile.Load(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if wixFile.NeedGUID() && wixFile.StableGuids {
+		if _, err := wixFile.SetStableGuids(false); err != nil {
+			return nil, err
+		}
+	} else if wixFile.NeedGUID() {
+		fmt.Println("The manifest needs Guid")
+		fmt.Println("To update your file automatically run:")
+		fmt.Println("     go-msi set-guid")
+		return nil, fmt.Errorf("Cannot proceed, manifest file is incomplete")
+	}
+
+	if c.IsSet("version") {
+		wixFile.Version = version
+	}
+
+	if c.IsSet("license") {
+		wixFile.License = license
+	}
+
+	if err := applyPackageType(c, &wixFile); err != nil {
+		return nil, err
+	}
+
+	if err := applyPathMap(c, &wixFile); err != nil {
+		return nil, err
+	}
+
+	err = wixFile.Normalize()
+	if err != nil {
+		return nil, err
+	}
+
+	err = wixFile.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	err = wixFile.RewriteFilePaths(out)
+	if err != nil {
+		return nil, err
+	}
+
+	templates, err := tpls.FindWithOverrides(src, c.String("templates"), "*.wxs")
+	if err != nil {
+		return nil, err
+	}
+	if len(templates) == 0 {
+		return nil, fmt.Errorf("No templates *.wxs found in this directory")
+	}
+	partials, err := tpls.FindPartials(c.String("templates"))
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(out, 0744)
+	if err != nil {
+		return nil, err
+	}
+
+	err = builder.PrepareLicense(&wixFile, out)
+	if err != nil {
+		return nil, err
+	}
+
+	err = builder.PrepareIcons(&wixFile, out)
+	if err != nil {
+		return nil, err
+	}
+
+	err = builder.PrepareLocalizations(&wixFile, out)
+	if err != nil {
+		return nil, err
+	}
+
+	err = builder.PrepareConfigs(&wixFile, out)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := []string{}
+	for _, tpl := range templates {
+		dst := filepath.Join(out, filepath.Base(tpl))
+		err = tpls.GenerateTemplate(&wixFile, tpl, dst, partials...)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, dst)
+	}
+	fragments, err := tpls.GenerateFragments(&wixFile, templates, out, partials...)
+	if err != nil {
+		return nil, err
+	}
+	return append(ret, fragments...), nil
+}
+
+func templatesDiff(c *cli.Context) error {
+	golden := c.String("golden")
+	update := c.Bool("update")
+
+	templates, err := renderTemplates(c, c.String("out"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if update {
+		if err := os.MkdirAll(golden, 0744); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
+	failed := 0
+	rendered := map[string]bool{}
+	for _, tpl := range templates {
+		name := filepath.Base(tpl)
+		rendered[name] = true
+		dat, err := ioutil.ReadFile(tpl)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		for _, p := range wix.Lint(dat) {
+			fmt.Printf("%v: %v\n", tpl, p)
+			failed++
+		}
+
+		g := filepath.Join(golden, name)
+		if update {
+			if err := ioutil.WriteFile(g, dat, 0644); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			logger.Default.Info("Wrote %s", g)
+			continue
+		}
+		want, err := ioutil.ReadFile(g)
+		if os.IsNotExist(err) {
+			fmt.Printf("%v: the golden copy %v does not exist, write it with --update\n", tpl, g)
+			failed++
+			continue
+		} else if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		if d := tpls.Diff(g, want, tpl, dat); d != "" {
+			fmt.Print(d)
+			failed++
+		}
+	}
+
+	if _, err := os.Stat(golden); err == nil && !update {
+		stale, err := tpls.Find(golden, "*.wxs")
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		for _, g := range stale {
+			if !rendered[filepath.Base(g)] {
+				fmt.Printf("%v: the template is not generated anymore\n", g)
+				failed++
+			}
+		}
+	}
+
+	if failed > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d differences, or problems, found", failed), 1)
+	}
+	if !update {
+		fmt.Printf("The %d templates match their golden copy\n", len(templates))
+	}
+	return nil
+}
+
+func toWindows1252(c *cli.Context) error {
+	src := c.String("src")
+	out := c.String("out")
+
+	if src == "" {
+		return cli.NewExitError("--src argument is required", 1)
+	}
+	if out == "" {
+		return cli.NewExitError("--out argument is required", 1)
+	}
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	os.MkdirAll(filepath.Dir(out), 0744)
+	err := rtf.WriteAsWindows1252(src, out)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+func toRtf(c *cli.Context) error {
+	src := c.String("src")
+	out := c.String("out")
+	reencode := c.Bool("reencode")
+
+	if src == "" {
+		return cli.NewExitError("--src argument is required", 1)
+	}
+	if out == "" {
+		return cli.NewExitError("--out argument is required", 1)
+	}
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	os.MkdirAll(filepath.Dir(out), 0744)
+
+	var err error
+	if rtf.IsMarkdown(src) {
+		err = rtf.WriteMarkdownAsRtf(src, out)
+	} else {
+		err = rtf.WriteAsRtf(src, out, reencode)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	return nil
+}
+
+func generateWixCommands(c *cli.Context) error {
+	path := c.String("path")
+	src, err := templatesSrc(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	out := c.String("out")
+	msi := c.String("msi")
+	arch := c.String("arch")
+
+	if msi == "" {
+		return cli.NewExitError("--msi parameter must be set", 1)
+	}
+
+	toolchain, err := findToolchain(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	templates, err := tpls.FindWithOverrides(src, c.String("templates"), "*.wxs")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if len(templates) == 0 {
+		return cli.NewExitError("No templates *.wxs found in this directory", 1)
+	}
+
+	builtTemplates := make([]string, len(templates))
+	for i, tpl := range templates {
+		builtTemplates[i] = filepath.Join(out, filepath.Base(tpl))
+	}
+
+	wixFile := manifest.WixManifest{Profile: c.String("profile")}
+	err = wixFile.Load(path)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if wixFile.NeedGUID() && wixFile.StableGuids {
+		if _, err := wixFile.SetStableGuids(false); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	} else if wixFile.NeedGUID() {
+		fmt.Println("The manifest needs Guid")
+		fmt.Println("To update your file automatically run:")
+		fmt.Println("     go-msi set-guid")
+		return cli.NewExitError("Cannot proceed, manifest file is incomplete", 1)
+	}
+
+	if c.IsSet("arch") {
+		wixFile.Arch = arch
+	}
+
+	if err := applyPathMap(c, &wixFile); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	err = wixFile.Normalize()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	err = wixFile.Validate()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	err = wixFile.RewriteFilePaths(out)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	partials, err := tpls.FindPartials(c.String("templates"))
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	fragments, err := tpls.GenerateFragments(&wixFile, templates, "", partials...)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	for _, f := range fragments {
+		builtTemplates = append(builtTemplates, filepath.Join(out, f))
+	}
+
+	msi, err = filepath.Abs(msi)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	// the msi path is relative to the build directory, as make writes it
+	absOut, err := filepath.Abs(out)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	msi, err = filepath.Rel(absOut, msi)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	msi = wixFile.BuildPath(msi)
+
+	cmdStr := toolchain.Cmd(&wixFile, builtTemplates, msi, wixFile.Arch)
+
+	targetFile := c.String("file")
+	if !filepath.IsAbs(targetFile) {
+		targetFile = filepath.Join(out, targetFile)
+	}
+	err = ioutil.WriteFile(targetFile, []byte(cmdStr), 0644)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	return nil
+}
+
+func runWixCommands(c *cli.Context) error {
+	out := c.String("out")
+
+	bin, err := exec.LookPath("cmd.exe")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	args := []string{"/C", c.String("file")}
+	oCmd := exec.Command(bin, args...)
+	oCmd.Dir = out
+	oCmd.Stdout = os.Stdout
+	oCmd.Stderr = os.Stderr
+	err = oCmd.Run()
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	return nil
+}
+
+func quickMake(c *cli.Context) error {
+	path := c.String("path")
+	version := c.String("version")
+	license := c.String("license")
+	msi := c.String("msi")
+	arch := c.String("arch")
+	dryRun := c.Bool("dry-run")
+	src, err := templatesSrc(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	opts := builder.Options{
+		Src:           src,
+		Templates:     c.String("templates"),
+		Out:           c.String("out"),
+		Msi:           msi,
+		Format:        c.String("format"),
+		Validate:      c.Bool("validate"),
+		Jobs:          c.Int("jobs"),
+		Keep:          c.Bool("keep"),
+		Deterministic: c.Bool("deterministic"),
+		DryRun:        dryRun,
+		Artifacts:     c.Bool("artifacts"),
+		GoMsiVersion:  VERSION,
+	}
+
+	if msi == "" {
+		return cli.NewExitError("--msi parameter must be set", 1)
+	}
+
+	toolchain, err := findToolchain(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	opts.Toolchain = toolchain
+
+	// load returns the manifest of the profile, with the flags applied.
+	load := func(profile, arch string) (*manifest.WixManifest, error) {
+		wixFile := &manifest.WixManifest{Profile: profile}
+		if err := wixFile.Load(path); err != nil {
+			return nil, err
+		}
+		if c.IsSet("version") {
+			wixFile.Version = version
+		}
+		if c.IsSet("license") {
+			wixFile.License = license
+		}
+		if err := applyPackageType(c, wixFile); err != nil {
+			return nil, err
+		}
+		if err := applyPathMap(c, wixFile); err != nil {
+			return nil, err
+		}
+		if arch != "" {
+			wixFile.Arch = arch
+		}
+		applySigningFlags(c, &wixFile.Signing)
+		if c.Bool("sign") && !wixFile.Signing.Enabled() {
+			return nil, fmt.Errorf("--sign requires a signing certificate or thumbprint")
+		}
+		return wixFile, nil
+	}
+
+	targets := []builder.Target{}
+	archs := splitList(c.String("targets"))
+	profiles := splitList(c.String("profiles"))
+	if len(archs) == 0 && len(profiles) == 0 {
+		wixFile, err := load(c.String("profile"), arch)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		targets = append(targets, builder.Target{Manifest: wixFile, Options: opts})
+	} else {
+		if path == "-" {
+			return cli.NewExitError("--targets and --profiles cannot read the manifest from stdin", 1)
+		}
+		if len(archs) == 0 {
+			archs = []string{arch}
+		}
+		if len(profiles) == 0 {
+			profiles = []string{c.String("profile")}
+		}
+		// each target is built with its own manifest,
+		// in its own sub directory of out.
+		ext := filepath.Ext(msi)
+		for _, profile := range profiles {
+			for _, a := range archs {
+				name := strings.Trim(profile+"-"+a, "-")
+				wixFile, err := load(profile, a)
+				if err != nil {
+					return cli.NewExitError(fmt.Sprintf("%v: %v", name, err), 1)
+				}
+				o := opts
+				o.Out = filepath.Join(opts.Out, name)
+				o.Msi = strings.TrimSuffix(msi, ext) + "-" + name + ext
+				targets = append(targets, builder.Target{Name: name, Manifest: wixFile, Options: o})
+			}
+		}
+	}
+	// the compiled templates are cached in the output directory,
+	// unless it is the default one, a new temporary directory each run
+	if c.IsSet("out") && !c.Bool("no-cache") {
+		for i := range targets {
+			targets[i].Options.CacheDir = filepath.Join(targets[i].Options.Out, ".candle-cache")
+		}
+	}
+
+	ctx, err := toolContext(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	rets, err := builder.BuildTargets(ctx, targets)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	for _, r := range rets {
+		if dryRun {
+			if r.Name != "" {
+				fmt.Printf("## Target %v\n\n", r.Name)
+			}
+			if err := printDryRun(r.Result, opts.Keep); err != nil {
+				return cli.NewExitError(err.Error(), 1)
+			}
+			continue
+		}
+		if r.Name != "" {
+			logger.Default.Info("%v: built %v", r.Name, strings.Join(r.Result.Msi, ", "))
+		}
+		if opts.Keep {
+			logger.Default.Info("Build files are available in %s", r.Result.Dir)
+		}
+	}
+	if dryRun {
+		return nil
+	}
+
+	// the targets may write their msi files to the same directory,
+	// their checksums are written once they are all built.
+	if opts.Artifacts {
+		files := []string{}
+		for _, r := range rets {
+			files = append(append(files, r.Result.Msi...), r.Result.Artifacts...)
+		}
+		sums, err := artifacts.WriteSums(files)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		for _, f := range sums {
+			logger.Default.Info("Wrote %s", f)
+		}
+	}
+
+	logger.Default.Info("All Done!!")
+
+	return nil
+}
+
+// printDryRun prints the manifest, the guid changes, the wix templates
+// and the commands of the build ret, its build directory is removed unless keep.
+func printDryRun(ret *builder.Result, keep bool) error {
+	if len(ret.GuidChanges) > 0 {
+		fmt.Printf("# Generated guids\n%v\n\n", strings.Join(ret.GuidChanges, "\n"))
+	}
+	fmt.Printf("# Manifest\n%s\n\n", ret.Manifest)
+	for _, tpl := range ret.Templates {
+		byt, err := ioutil.ReadFile(tpl)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("# %s\n%s\n\n", filepath.Base(tpl), byt)
+	}
+	fmt.Printf("# Commands, run in %s\n%s\n", ret.Dir, ret.Cmd)
+	for _, f := range ret.Signed {
+		fmt.Printf("\n# Would sign %s\n", f)
+	}
+	if !keep {
+		return os.RemoveAll(ret.Dir)
+	}
+	fmt.Printf("\nBuild files are available in %s\n", ret.Dir)
+	return nil
+}
+
+// splitList returns the non empty values of the comma separated list s.
+func splitList(s string) []string {
+	ret := []string{}
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+// findToolchain returns the toolchain selected by the backend and wix-version flags of c.
+func findToolchain(c *cli.Context) (wix.Toolchain, error) {
+	switch c.String("backend") {
+	case "", "wix":
+		return wix.FindToolchain(c.String("wix-version"))
+	case "wixl":
+		return wix.Wixl{}, nil
+	}
+	return nil, fmt.Errorf("Unknown backend %q, expected wix or wixl", c.String("backend"))
+}
+
+// applyPackageType overrides the module flag of the manifest with the type flag of c.
+func applyPackageType(c *cli.Context, wixFile *manifest.WixManifest) error {
+	if !c.IsSet("type") {
+		return nil
+	}
+	switch c.String("type") {
+	case "msi":
+		wixFile.Module = false
+	case "msm":
+		wixFile.Module = true
+	default:
+		return fmt.Errorf("Unknown package type %q, expected msi or msm", c.String("type"))
+	}
+	return nil
+}
+
+// applyPathMap adds the host=build path prefixes of the path-map flag of c to the path-map of the manifest.
+func applyPathMap(c *cli.Context, wixFile *manifest.WixManifest) error {
+	for _, entry := range splitList(c.String("path-map")) {
+		kv := strings.SplitN(entry, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return fmt.Errorf("Invalid --path-map entry %q, expected host=build, such as /=Z:\\", entry)
+		}
+		if wixFile.PathMap == nil {
+			wixFile.PathMap = map[string]string{}
+		}
+		wixFile.PathMap[kv[0]] = kv[1]
+	}
+	return nil
+}
+
+// applySigningFlags overrides the signing settings with the flags of c.
+func applySigningFlags(c *cli.Context, spec *manifest.SigningSpec) {
+	if c.IsSet("sign-certificate") {
+		spec.Certificate = c.String("sign-certificate")
+		spec.Thumbprint = ""
+	}
+	if c.IsSet("sign-thumbprint") {
+		spec.Thumbprint = c.String("sign-thumbprint")
+		spec.Certificate = ""
+	}
+	if c.IsSet("sign-password") {
+		spec.Password = c.String("sign-password")
+	}
+	if c.IsSet("sign-timestamp-url") {
+		spec.TimestampURL = c.String("sign-timestamp-url")
+	}
+	if c.IsSet("sign-digest") {
+		spec.Digest = c.String("sign-digest")
+	}
+}
+
+func signFiles(c *cli.Context) error {
+	path := c.String("path")
+
+	if c.NArg() == 0 {
+		return cli.NewExitError("No files to sign", 1)
+	}
+
+	wixFile := manifest.WixManifest{Profile: c.String("profile")}
+	if _, err := os.Stat(path); err == nil || c.IsSet("path") {
+		if err := wixFile.LoadExtended(path); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
+	applySigningFlags(c, &wixFile.Signing)
+	wixFile.Signing.SetDefaults()
+	if err := wixFile.Signing.Check(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	ctx, err := toolContext(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	for _, f := range c.Args() {
+		if err := sign.Sign(ctx, wixFile.Signing, f); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		logger.Default.Info("Signed %s", f)
+	}
+
+	return nil
+}
+
+func testInstall(c *cli.Context) error {
+	path := c.String("path")
+	msi := c.String("msi")
+
+	if msi == "" {
+		return cli.NewExitError("--msi parameter must be set", 1)
+	}
+
+	wixFile := manifest.WixManifest{Profile: c.String("profile")}
+	if err := wixFile.Load(path); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if c.IsSet("version") {
+		wixFile.Version = c.String("version")
+	}
+	if err := wixFile.Normalize(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	checks, err := installtest.Run(context.Background(), &wixFile, installtest.Options{
+		Msi:    msi,
+		Target: c.String("target"),
+		LogDir: c.String("log-dir"),
+		Keep:   c.Bool("keep"),
+	})
+	for _, check := range checks {
+		logger.Default.Info("%v", check)
+	}
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if failed := installtest.Failed(checks); len(failed) > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d of %d checks failed", len(failed), len(checks)), 1)
+	}
+	logger.Default.Info("All %d checks passed", len(checks))
+	return nil
+}
+
+func chocoMake(c *cli.Context) error {
+	path := c.String("path")
+	src, err := templatesSrc(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	out := c.String("out")
+	input := c.String("input")
+	version := c.String("version")
+	changelogCmd := c.String("changelog-cmd")
+	keep := c.Bool("keep")
+
+	wixFile := manifest.WixManifest{Profile: c.String("profile")}
+	if err := wixFile.Load(path); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if err := os.RemoveAll(out); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if err := os.MkdirAll(out, 0744); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if c.IsSet("version") {
+		wixFile.Version = version
+	}
+
+	if err := wixFile.Normalize(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	templates, err := tpls.Find(src, "*")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if len(templates) == 0 {
+		return cli.NewExitError("No templates found in this directory", 1)
+	}
+
+	out, err = filepath.Abs(out)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	wixFile.Choco.BuildDir = out
+	wixFile.Choco.MsiFile = filepath.Base(input)
+	wixFile.Choco.MsiSum, err = util.ComputeSha256(input)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if changelogCmd != "" {
+		windows, err2 := stringexec.Command(changelogCmd)
+		if err2 != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		windows.Stderr = os.Stderr
+		out, err3 := windows.Output()
+		if err3 != nil {
+			return cli.NewExitError(fmt.Sprintf("Failed to execute command to generate the changelog:%q\n%v", changelogCmd, err.Error()), 1)
+		}
+		sout := string(out)
+		souts := strings.Split(sout, "\n")
+		if len(souts) > 2 {
+			souts = souts[2:] // why ? command line artifacts ? todo: put an explanation here.
+		}
+		sout = strings.Join(souts, "\n")
+
+		wixFile.Choco.ChangeLog = sout
+	}
+
+	if err = util.CopyFile(filepath.Join(wixFile.Choco.BuildDir, wixFile.Choco.MsiFile), input); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	for _, tpl := range templates {
+		dst := filepath.Join(out, filepath.Base(tpl))
+		err = tpls.GenerateTemplate(&wixFile, tpl, dst)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+
+	bin, err := exec.LookPath("choco")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	ctx, err := toolContext(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	oCmd := exec.Command(bin, "pack")
+	oCmd.Dir = out
+	oCmd.Stdout = logger.Default.Output("choco")
+	oCmd.Stderr = oCmd.Stdout
+	done := logger.Default.Stage("pack")
+	err = wix.Run(ctx, oCmd)
+	done(err)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	SrcNupkg := fmt.Sprintf("%s\\%s.%s.nupkg", out, wixFile.Choco.ID, wixFile.VersionOk)
+	DstNupkg := fmt.Sprintf("%s.%s.nupkg", wixFile.Choco.ID, wixFile.Version)
+
+	if err = util.CopyFile(DstNupkg, SrcNupkg); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if keep == false {
+		err = os.RemoveAll(out)
 		if err != nil {
 			return cli.NewExitError(err.Error(), 1)
 		}
+	} else {
+		logger.Default.Info("Build files are available in %s", out)
 	}
 
-	fmt.Printf("Generated %d templates\n", len(templates))
-	for _, tpl := range templates {
-		dst := filepath.Join(out, filepath.Base(tpl))
-		fmt.Printf("- %s\n", dst)
-	}
+	logger.Default.Info("Package copied to %s", DstNupkg)
+	logger.Default.Info("All Done!!")
 
 	return nil
 }
 
-func toWindows1252(c *cli.Context) error {
-	src := c.String("src")
-	out := c.String("out")
+func chocoPush(c *cli.Context) error {
+	input := c.String("input")
+	source := c.String("source")
+	apiKey := c.String("api-key")
 
-	if src == "" {
-		return cli.NewExitError("--src argument is required", 1)
+	if input == "" {
+		return cli.NewExitError("--input parameter must be set", 1)
 	}
-	if out == "" {
-		return cli.NewExitError("--out argument is required", 1)
+	if source == "" {
+		return cli.NewExitError("--source parameter must be set", 1)
 	}
-	if _, err := os.Stat(src); os.IsNotExist(err) {
-		return cli.NewExitError(err.Error(), 1)
+	if apiKey == "" {
+		return cli.NewExitError("--api-key parameter, or CHOCO_API_KEY, must be set", 1)
 	}
-	os.MkdirAll(filepath.Dir(out), 0744)
-	err := rtf.WriteAsWindows1252(src, out)
-	if err != nil {
+	if _, err := os.Stat(input); err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
-	return nil
-}
 
-func toRtf(c *cli.Context) error {
-	src := c.String("src")
-	out := c.String("out")
-	reencode := c.Bool("reencode")
-
-	if src == "" {
-		return cli.NewExitError("--src argument is required", 1)
-	}
-	if out == "" {
-		return cli.NewExitError("--out argument is required", 1)
+	bin, err := exec.LookPath("choco")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
 	}
-	if _, err := os.Stat(src); os.IsNotExist(err) {
+	ctx, err := toolContext(c)
+	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
-
-	os.MkdirAll(filepath.Dir(out), 0744)
-
-	err := rtf.WriteAsRtf(src, out, reencode)
+	oCmd := exec.Command(bin, "push", input, "--source", source, "--api-key", apiKey)
+	oCmd.Stdout = logger.Default.Output("choco")
+	oCmd.Stderr = oCmd.Stdout
+	logger.Default.Debug("choco push %v --source %v --api-key ***", input, source)
+	done := logger.Default.Stage("push")
+	err = wix.Run(ctx, oCmd)
+	done(err)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
+	logger.Default.Info("Package pushed to %s", source)
 	return nil
 }
 
-func generateWixCommands(c *cli.Context) error {
+func wingetMake(c *cli.Context) error {
 	path := c.String("path")
-	src := c.String("src")
+	input := c.String("input")
 	out := c.String("out")
-	msi := c.String("msi")
-	arch := c.String("arch")
 
-	if msi == "" {
-		return cli.NewExitError("--msi parameter must be set", 1)
+	if input == "" {
+		return cli.NewExitError("--input parameter must be set", 1)
 	}
 
-	templates, err := tpls.Find(src, "*.wxs")
-	if err != nil {
+	wixFile := manifest.WixManifest{Profile: c.String("profile")}
+	if err := wixFile.Load(path); err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
-	if len(templates) == 0 {
-		return cli.NewExitError("No templates *.wxs found in this directory", 1)
+	if c.IsSet("version") {
+		wixFile.Version = c.String("version")
 	}
-
-	builtTemplates := make([]string, len(templates))
-	for i, tpl := range templates {
-		builtTemplates[i] = filepath.Join(out, filepath.Base(tpl))
+	if err := wixFile.Normalize(); err != nil {
+		return cli.NewExitError(err.Error(), 1)
 	}
 
-	wixFile := manifest.WixManifest{}
-	err = wixFile.Load(path)
+	sum, err := util.ComputeSha256(input)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
-
-	if wixFile.NeedGUID() {
-		fmt.Println("The manifest needs Guid")
-		fmt.Println("To update your file automatically run:")
-		fmt.Println("     go-msi set-guid")
-		return cli.NewExitError("Cannot proceed, manifest file is incomplete", 1)
+	productCode := wixFile.ProductCode
+	if productCode == "" {
+		productCode, err = wix.ProductCode(context.Background(), input)
+		if err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	installer := winget.Installer{
+		InstallerURL:    wixFile.Winget.InstallerURL,
+		InstallerSha256: strings.ToUpper(sum),
+		ProductCode:     "{" + strings.ToUpper(strings.Trim(productCode, "{}")) + "}",
+	}
+	if c.IsSet("url") {
+		installer.InstallerURL = c.String("url")
 	}
 
-	err = wixFile.Normalize()
+	files, err := winget.Write(&wixFile, installer, out)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
+	for _, f := range files {
+		logger.Default.Info("Wrote %s", f)
+	}
+	return nil
+}
 
-	err = wixFile.RewriteFilePaths(out)
-	if err != nil {
+func scoopMake(c *cli.Context) error {
+	path := c.String("path")
+	input := c.String("input")
+	out := c.String("out")
+
+	if input == "" {
+		return cli.NewExitError("--input parameter must be set", 1)
+	}
+
+	wixFile := manifest.WixManifest{Profile: c.String("profile")}
+	if err := wixFile.Load(path); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if c.IsSet("version") {
+		wixFile.Version = c.String("version")
+	}
+	if err := wixFile.Normalize(); err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
+	if c.IsSet("url-template") {
+		wixFile.Scoop.URLTemplate = c.String("url-template")
+	}
 
-	msi, err = filepath.Abs(msi)
+	sum, err := util.ComputeSha256(input)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
-	msi, err = filepath.Rel(out, msi)
+	app, err := scoop.New(&wixFile, sum)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
-
-	cmdStr := wix.GenerateCmd(&wixFile, builtTemplates, msi, arch)
-
-	targetFile := filepath.Join(out, "build.bat")
-	err = ioutil.WriteFile(targetFile, []byte(cmdStr), 0644)
-	if err != nil {
+	if out == "" {
+		out = wixFile.Scoop.Name + ".json"
+	}
+	if err := app.Write(out); err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
-
+	logger.Default.Info("Wrote %s", out)
 	return nil
 }
 
-func runWixCommands(c *cli.Context) error {
+func scriptsMake(c *cli.Context) error {
+	path := c.String("path")
 	out := c.String("out")
-
-	bin, err := exec.LookPath("cmd.exe")
+	src, err := templatesSrc(c)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
-	args := []string{"/C", "build.bat"}
-	oCmd := exec.Command(bin, args...)
-	oCmd.Dir = out
-	oCmd.Stdout = os.Stdout
-	oCmd.Stderr = os.Stderr
-	err = oCmd.Run()
-	if err != nil {
+
+	wixFile := manifest.WixManifest{Profile: c.String("profile")}
+	if err := wixFile.Load(path); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if err := wixFile.Normalize(); err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
+	if wixFile.Module {
+		return cli.NewExitError("A merge module is not installed on its own, it has no uninstall or repair scripts", 1)
+	}
 
+	templates, err := tpls.Find(src, "*.ps1")
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if len(templates) == 0 {
+		return cli.NewExitError("No templates found in this directory", 1)
+	}
+	if err := os.MkdirAll(out, 0744); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	for _, tpl := range templates {
+		dst := filepath.Join(out, filepath.Base(tpl))
+		if err := tpls.GenerateTemplate(&wixFile, tpl, dst); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+		logger.Default.Info("Wrote %s", dst)
+	}
 	return nil
 }
 
-func quickMake(c *cli.Context) error {
+func bundleMake(c *cli.Context) error {
 	path := c.String("path")
-	src := c.String("src")
+	src, err := templatesSrc(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
 	out := c.String("out")
+	msi := c.String("msi")
+	exe := c.String("exe")
 	version := c.String("version")
 	license := c.String("license")
-	msi := c.String("msi")
-	arch := c.String("arch")
 	keep := c.Bool("keep")
 
 	if msi == "" {
 		return cli.NewExitError("--msi parameter must be set", 1)
 	}
+	if exe == "" {
+		exe = strings.TrimSuffix(msi, filepath.Ext(msi)) + ".exe"
+	}
 
-	wixFile := manifest.WixManifest{}
+	wixFile := manifest.WixManifest{Profile: c.String("profile")}
 	if err := wixFile.Load(path); err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
-	if wixFile.NeedGUID() {
-		if _, err := wixFile.SetGuids(false); err != nil {
-			return cli.NewExitError(err.Error(), 1)
-		}
-	}
-
-	if err := os.RemoveAll(out); err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-	if err := os.MkdirAll(out, 0744); err != nil {
+	if err := builder.CleanDir(out, ""); err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
@@ -638,22 +2727,16 @@ func quickMake(c *cli.Context) error {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
-	if err := wixFile.RewriteFilePaths(out); err != nil {
+	if err := wixFile.Validate(); err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
-	if wixFile.License != "" {
-		if !rtf.IsRtf(wixFile.License) {
-			target := filepath.Join(out, filepath.Base(wixFile.License)+".rtf")
-			err := rtf.WriteAsRtf(wixFile.License, target, true)
-			if err != nil {
-				return cli.NewExitError(err.Error(), 1)
-			}
-			wixFile.License, err = filepath.Rel(out, target)
-			if err != nil {
-				return cli.NewExitError(err.Error(), 1)
-			}
-		}
+	if err := wixFile.RewriteBundlePaths(out, msi); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+
+	if err := builder.PrepareLicense(&wixFile, out); err != nil {
+		return cli.NewExitError(err.Error(), 1)
 	}
 
 	templates, err := tpls.Find(src, "*.wxs")
@@ -664,43 +2747,24 @@ func quickMake(c *cli.Context) error {
 		return cli.NewExitError("No templates *.wxs found in this directory", 1)
 	}
 
-	builtTemplates := make([]string, len(templates))
-	for i, tpl := range templates {
+	for _, tpl := range templates {
 		dst := filepath.Join(out, filepath.Base(tpl))
-		err = tpls.GenerateTemplate(&wixFile, tpl, dst)
-		builtTemplates[i] = dst
-		if err != nil {
+		if err = tpls.GenerateTemplate(&wixFile, tpl, dst); err != nil {
 			return cli.NewExitError(err.Error(), 1)
 		}
 	}
 
-	msi, err = filepath.Abs(msi)
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-	msi, err = filepath.Rel(out, msi)
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-
-	cmdStr := wix.GenerateCmd(&wixFile, builtTemplates, msi, arch)
-
-	targetFile := filepath.Join(out, "build.bat")
-	err = ioutil.WriteFile(targetFile, []byte(cmdStr), 0644)
+	exe, err = filepath.Abs(exe)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
-
-	bin, err := exec.LookPath("cmd.exe")
+	done := logger.Default.Stage("bundle")
+	ctx, err := toolContext(c)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
-	args := []string{"/C", "build.bat"}
-	oCmd := exec.Command(bin, args...)
-	oCmd.Dir = out
-	oCmd.Stdout = os.Stdout
-	oCmd.Stderr = os.Stderr
-	err = oCmd.Run()
+	err = wix.Bundle(ctx, out, templates, exe)
+	done(err, exe)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
@@ -711,32 +2775,40 @@ func quickMake(c *cli.Context) error {
 			return cli.NewExitError(err.Error(), 1)
 		}
 	} else {
-		fmt.Printf("Build files are available in %s\n", out)
+		logger.Default.Info("Build files are available in %s", out)
 	}
 
-	fmt.Println("All Done!!")
+	logger.Default.Info("Bundle written to %s", exe)
+	logger.Default.Info("All Done!!")
 
 	return nil
 }
 
-func chocoMake(c *cli.Context) error {
+func patchMake(c *cli.Context) error {
 	path := c.String("path")
-	src := c.String("src")
+	src, err := templatesSrc(c)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
 	out := c.String("out")
-	input := c.String("input")
+	oldFile := c.String("old")
+	newFile := c.String("new")
+	msp := c.String("msp")
 	version := c.String("version")
-	changelogCmd := c.String("changelog-cmd")
 	keep := c.Bool("keep")
 
-	wixFile := manifest.WixManifest{}
-	if err := wixFile.Load(path); err != nil {
-		return cli.NewExitError(err.Error(), 1)
+	if oldFile == "" || newFile == "" {
+		return cli.NewExitError("--old and --new parameters must be set", 1)
 	}
-
-	if err := os.RemoveAll(out); err != nil {
-		return cli.NewExitError(err.Error(), 1)
+	if !strings.EqualFold(filepath.Ext(oldFile), filepath.Ext(newFile)) {
+		return cli.NewExitError("--old and --new must both be msi files, or both wixout files", 1)
 	}
-	if err := os.MkdirAll(out, 0744); err != nil {
+	if msp == "" {
+		msp = strings.TrimSuffix(newFile, filepath.Ext(newFile)) + ".msp"
+	}
+
+	wixFile := manifest.WixManifest{Profile: c.String("profile")}
+	if err := wixFile.Load(path); err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
@@ -748,88 +2820,64 @@ func chocoMake(c *cli.Context) error {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
-	templates, err := tpls.Find(src, "*")
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-	if len(templates) == 0 {
-		return cli.NewExitError("No templates found in this directory", 1)
-	}
-
-	out, err = filepath.Abs(out)
-	if err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-	wixFile.Choco.BuildDir = out
-	wixFile.Choco.MsiFile = filepath.Base(input)
-	wixFile.Choco.MsiSum, err = util.ComputeSha256(input)
-	if err != nil {
+	if err := wixFile.Validate(); err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
-	if changelogCmd != "" {
-		windows, err2 := stringexec.Command(changelogCmd)
-		if err2 != nil {
+	files := []*string{&oldFile, &newFile, &msp}
+	for _, f := range files {
+		var err error
+		if *f, err = filepath.Abs(*f); err != nil {
 			return cli.NewExitError(err.Error(), 1)
 		}
-		windows.Stderr = os.Stderr
-		out, err3 := windows.Output()
-		if err3 != nil {
-			return cli.NewExitError(fmt.Sprintf("Failed to execute command to generate the changelog:%q\n%v", changelogCmd, err.Error()), 1)
-		}
-		sout := string(out)
-		souts := strings.Split(sout, "\n")
-		if len(souts) > 2 {
-			souts = souts[2:] // why ? command line artifacts ? todo: put an explanation here.
+	}
+	for _, f := range files[:2] {
+		if _, err := os.Stat(*f); err != nil {
+			return cli.NewExitError(err.Error(), 1)
 		}
-		sout = strings.Join(souts, "\n")
+	}
 
-		wixFile.Choco.ChangeLog = sout
+	if err := builder.CleanDir(out, ""); err != nil {
+		return cli.NewExitError(err.Error(), 1)
 	}
 
-	if err = util.CopyFile(filepath.Join(wixFile.Choco.BuildDir, wixFile.Choco.MsiFile), input); err != nil {
+	templates, err := tpls.Find(src, "*.wxs")
+	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
+	if len(templates) == 0 {
+		return cli.NewExitError("No templates *.wxs found in this directory", 1)
+	}
 
 	for _, tpl := range templates {
 		dst := filepath.Join(out, filepath.Base(tpl))
-		err = tpls.GenerateTemplate(&wixFile, tpl, dst)
-		if err != nil {
+		if err = tpls.GenerateTemplate(&wixFile, tpl, dst); err != nil {
 			return cli.NewExitError(err.Error(), 1)
 		}
 	}
 
-	bin, err := exec.LookPath("choco")
+	done := logger.Default.Stage("patch")
+	ctx, err := toolContext(c)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
-	oCmd := exec.Command(bin, "pack")
-	oCmd.Dir = out
-	oCmd.Stdout = os.Stdout
-	oCmd.Stderr = os.Stderr
-	err = oCmd.Run()
+	err = wix.Patch(ctx, out, templates, oldFile, newFile, msp)
+	done(err, msp)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 1)
 	}
 
-	SrcNupkg := fmt.Sprintf("%s\\%s.%s.nupkg", out, wixFile.Choco.ID, wixFile.VersionOk)
-	DstNupkg := fmt.Sprintf("%s.%s.nupkg", wixFile.Choco.ID, wixFile.Version)
-
-	if err = util.CopyFile(DstNupkg, SrcNupkg); err != nil {
-		return cli.NewExitError(err.Error(), 1)
-	}
-
 	if keep == false {
 		err = os.RemoveAll(out)
 		if err != nil {
 			return cli.NewExitError(err.Error(), 1)
 		}
 	} else {
-		fmt.Printf("Build files are available in %s\n", out)
+		logger.Default.Info("Build files are available in %s", out)
 	}
 
-	fmt.Printf("Package copied to %s\n", DstNupkg)
-	fmt.Println("All Done!!")
+	logger.Default.Info("Patch written to %s", msp)
+	logger.Default.Info("All Done!!")
 
 	return nil
 }
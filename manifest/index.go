@@ -7,49 +7,298 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
+	"github.com/BurntSushi/toml"
 	"github.com/Masterminds/semver"
+	"github.com/mh-cbon/go-msi/ico"
+	"github.com/mh-cbon/go-msi/logger"
+	"github.com/mh-cbon/go-msi/schema"
 	"github.com/satori/go.uuid"
+	"gopkg.in/yaml.v3"
 )
 
 // WixManifest is the struct to decode a wix.json file.
 type WixManifest struct {
-	Product        string       `json:"product"`
-	Company        string       `json:"company"`
-	Version        string       `json:"version,omitempty"`
-	VersionOk      string       `json:"-"`
-	License        string       `json:"license,omitempty"`
-	UpgradeCode    string       `json:"upgrade-code"`
-	Files          WixFiles     `json:"files,omitempty"`
-	Directories    []string     `json:"directories,omitempty"`
-	RelDirs        []string     `json:"-"`
-	Env            WixEnvList   `json:"env,omitempty"`
-	Shortcuts      WixShortcuts `json:"shortcuts,omitempty"`
-	Choco          ChocoSpec    `json:"choco,omitempty"`
-	Hooks          []Hook       `json:"hooks,omitempty"`
-	InstallHooks   []Hook       `json:"-"`
-	UninstallHooks []Hook       `json:"-"`
+	Schema            string                       `json:"$schema,omitempty"`
+	Extends           string                       `json:"extends,omitempty"`     // path to a parent manifest, relative to this one
+	MergeLists        map[string]string            `json:"merge-lists,omitempty"` // append or replace, the lists of the extended manifest, by path
+	Profiles          map[string]WixProfile        `json:"profiles,omitempty"`    // overrides of the manifest, by profile name
+	Profile           string                       `json:"-"`                     // the profile applied when the manifest is loaded
+	Product           string                       `json:"product"`
+	Company           string                       `json:"company"`
+	Version           string                       `json:"version,omitempty"`
+	VersionOk         string                       `json:"-"`
+	VersionPolicy     string                       `json:"version-policy,omitempty"` // build, prerelease or drop, the fourth field of the msi version, drop by default unless the version comes from git
+	License           string                       `json:"license,omitempty"`
+	UpgradeCode       string                       `json:"upgrade-code"`
+	ProductCode       string                       `json:"product-code,omitempty"`  // fixed across the releases a patch updates, generated per build when empty
+	StableGuids       bool                         `json:"stable-guids,omitempty"`  // derive the missing guids when the package is built
+	InstallScope      string                       `json:"install-scope,omitempty"` // perMachine (default), perUser or dual
+	InstallDir        WixInstallDir                `json:"install-dir,omitempty"`
+	Arch              string                       `json:"arch,omitempty"`   // 386, amd64 or arm64, x86 when empty
+	Module            bool                         `json:"module,omitempty"` // build a merge module, msm, instead of a product
+	ModuleID          string                       `json:"-"`
+	ModuleGUID        string                       `json:"-"`
+	Files             WixFiles                     `json:"files,omitempty"`
+	FileGroups        []WixFiles                   `json:"file-groups,omitempty"`
+	Directories       []string                     `json:"directories,omitempty"`
+	Harvest           WixHarvest                   `json:"harvest,omitempty"`
+	RelDirs           []string                     `json:"-"`
+	DirTrees          []WixDir                     `json:"-"`
+	Env               WixEnvList                   `json:"env,omitempty"`
+	Shortcuts         WixShortcuts                 `json:"shortcuts,omitempty"`
+	Firewall          WixFirewall                  `json:"firewall,omitempty"`
+	Cleanup           WixCleanup                   `json:"cleanup,omitempty"`
+	Permissions       []WixPermission              `json:"permissions,omitempty"`
+	PermissionDirs    []WixSubDir                  `json:"-"`
+	Configs           []WixConfig                  `json:"configs,omitempty"`
+	ConfigDirs        []WixSubDir                  `json:"-"`
+	Choco             ChocoSpec                    `json:"choco,omitempty"`
+	Winget            WingetSpec                   `json:"winget,omitempty"`
+	Scoop             ScoopSpec                    `json:"scoop,omitempty"`
+	Signing           SigningSpec                  `json:"signing,omitempty"`
+	Upgrade           WixUpgrade                   `json:"upgrade,omitempty"`
+	ARP               WixARP                       `json:"arp,omitempty"`
+	Conditions        []WixCondition               `json:"launch-conditions,omitempty"`
+	Registry          []WixRegistryValue           `json:"registry,omitempty"`
+	Services          []WixService                 `json:"services,omitempty"`
+	ScheduledTasks    []WixScheduledTask           `json:"scheduled-tasks,omitempty"`
+	Drivers           []WixDriver                  `json:"drivers,omitempty"`
+	EventSources      []WixEventSource             `json:"event-sources,omitempty"`
+	Com               []WixCom                     `json:"com,omitempty"`
+	PerfCategories    []WixPerfCategory            `json:"performance-counters,omitempty"`
+	DriverDirs        []WixSubDir                  `json:"-"`
+	FileAssociations  []WixFileAssociation         `json:"file-associations,omitempty"`
+	Features          []WixFeature                 `json:"features,omitempty"`
+	UI                WixUI                        `json:"ui,omitempty"`
+	Languages         []string                     `json:"languages,omitempty"`          // culture names or LCIDs, the first one is the default
+	Localization      map[string]map[string]string `json:"localization,omitempty"`       // strings by id, by language
+	LocalizationFiles map[string]string            `json:"localization-files,omitempty"` // wxl file paths, by language
+	Bundle            WixBundle                    `json:"bundle,omitempty"`
+	Patch             WixPatch                     `json:"patch,omitempty"`
+	Msix              WixMsix                      `json:"msix,omitempty"`
+	Validation        WixValidation                `json:"validation,omitempty"`
+	Media             WixMedia                     `json:"media,omitempty"`
+	PathMap           map[string]string            `json:"path-map,omitempty"` // host path prefixes, by their path in the build environment, such as / to Z:\ for wine
+	BuildDir          string                       `json:"-"`                  // the build directory, the file paths are relative to, see RewriteFilePaths
+	Cultures          []WixCulture                 `json:"-"`
+	Hooks             []Hook                       `json:"hooks,omitempty"`
+	CustomActions     []WixCustomAction            `json:"custom-actions,omitempty"`
+	InstallHooks      []Hook                       `json:"-"`
+	UninstallHooks    []Hook                       `json:"-"`
+}
+
+// WixValidation is the struct to decode the validation key of the wix.json file.
+type WixValidation struct {
+	Suppress []string `json:"suppress,omitempty"` // the ICEs not to run, such as ICE61
+}
+
+// WixInstallDir is the struct to decode the install-dir key of the wix.json file,
+// the default value of INSTALLDIR, the user can change it with the UI or the command line.
+type WixInstallDir struct {
+	Name       string   `json:"name,omitempty"`     // name of the install directory, defaults to the product, such as Company\Product for nested directories
+	Root       string   `json:"root,omitempty"`     // absolute path of its parent directory, such as C:\Tools, the program files directory by default
+	Remember   bool     `json:"remember,omitempty"` // save the chosen directory into the registry, the upgrades and repairs install into it
+	Drive      string   `json:"-"`                  // drive of Root, such as C:\
+	RootDirs   []string `json:"-"`                  // directories of Root under Drive
+	ParentDirs []string `json:"-"`                  // directories of Name above the install directory
+	DirName    string   `json:"-"`                  // last directory of Name
+}
+
+// installRootRe matches an absolute install-dir root, and captures its drive.
+var installRootRe = regexp.MustCompile(`^([A-Za-z]:)(?:[\\/]|$)`)
+
+// splitWinPath returns the non empty segments of the windows path p.
+func splitWinPath(p string) []string {
+	return strings.FieldsFunc(p, func(r rune) bool { return r == '\\' || r == '/' })
+}
+
+// WixMedia is the struct to decode the media key of the wix.json file,
+// the cab files holding the installed files.
+type WixMedia struct {
+	Cabinet             string `json:"cabinet,omitempty"`               // name of the cab file, product.cab by default, or product{0}.cab when split
+	EmbedCab            *bool  `json:"embed-cab,omitempty"`             // embed the cab files into the msi file, default true
+	CompressionLevel    string `json:"compression-level,omitempty"`     // none, low, medium, high or mszip (default)
+	MaxUncompressedSize int    `json:"max-uncompressed-size,omitempty"` // MB of installed files per cab, they are split across several cabs when set
+	MaxCabSize          int    `json:"max-cab-size,omitempty"`          // MB per cab, a larger file is split across several cabs when set
+	DiskPrompt          string `json:"disk-prompt,omitempty"`           // the name of the disks, asked for a missing external cab
+	Embed               bool   `json:"-"`
+	Split               bool   `json:"-"` // the cabs are described by a MediaTemplate
+}
+
+// CompressionLevels describes known cab compression levels.
+var CompressionLevels = map[string]bool{
+	"none":   true,
+	"low":    true,
+	"medium": true,
+	"high":   true,
+	"mszip":  true,
+}
+
+// iceRe matches the name of an ICE.
+var iceRe = regexp.MustCompile(`^ICE[0-9]+$`)
+
+// WixMsix is the struct to decode the msix key of the wix.json file,
+// the msix package is built by go-msi make --format msix.
+type WixMsix struct {
+	Name                 string            `json:"name,omitempty"`                   // identity name, defaults to the company and the product
+	Publisher            string            `json:"publisher,omitempty"`              // subject of the signing certificate, defaults to CN=company
+	DisplayName          string            `json:"display-name,omitempty"`           // defaults to the product
+	PublisherDisplayName string            `json:"publisher-display-name,omitempty"` // defaults to the company
+	Description          string            `json:"description,omitempty"`            // defaults to the product
+	Logo                 string            `json:"logo,omitempty"`                   // a png file, required
+	MinVersion           string            `json:"min-version,omitempty"`            // minimum Windows version, 10.0.17763.0 by default
+	Version              string            `json:"-"`                                // the version, with four parts
+	Arch                 string            `json:"-"`                                // x86, x64 or arm64
+	Applications         []MsixApplication `json:"-"`
+}
+
+// MsixApplication is an application of the msix package,
+// one per shortcut to an installed exe file, or the first exe file of files.
+type MsixApplication struct {
+	ID          string
+	Executable  string // path relative to the package root
+	DisplayName string
 }
 
 // ChocoSpec is the struct to decode the choco key of a wix.json file.
 type ChocoSpec struct {
-	ID             string `json:"id,omitempty"`
-	Title          string `json:"title,omitempty"`
-	Authors        string `json:"authors,omitempty"`
-	Owners         string `json:"owners,omitempty"`
-	Description    string `json:"description,omitempty"`
-	ProjectURL     string `json:"project-url,omitempty"`
-	Tags           string `json:"tags,omitempty"`
-	LicenseURL     string `json:"license-url,omitempty"`
-	IconURL        string `json:"icon-url,omitempty"`
-	RequireLicense bool   `json:"require-license,omitempty"`
-	MsiFile        string `json:"-"`
-	MsiSum         string `json:"-"`
-	BuildDir       string `json:"-"`
-	ChangeLog      string `json:"-"`
+	ID               string            `json:"id,omitempty"`
+	Title            string            `json:"title,omitempty"`
+	Authors          string            `json:"authors,omitempty"`
+	Owners           string            `json:"owners,omitempty"`
+	Description      string            `json:"description,omitempty"`
+	ProjectURL       string            `json:"project-url,omitempty"`
+	Tags             string            `json:"tags,omitempty"`
+	LicenseURL       string            `json:"license-url,omitempty"`
+	IconURL          string            `json:"icon-url,omitempty"`
+	RequireLicense   bool              `json:"require-license,omitempty"`
+	ReleaseNotes     string            `json:"release-notes,omitempty"` // the output of --changelog-cmd replaces it
+	Summary          string            `json:"summary,omitempty"`
+	DocsURL          string            `json:"docs-url,omitempty"`
+	BugTrackerURL    string            `json:"bug-tracker-url,omitempty"`
+	PackageSourceURL string            `json:"package-source-url,omitempty"`
+	Copyright        string            `json:"copyright,omitempty"`
+	Dependencies     []ChocoDependency `json:"dependencies,omitempty"`
+	MsiFile          string            `json:"-"`
+	MsiSum           string            `json:"-"`
+	BuildDir         string            `json:"-"`
+	ChangeLog        string            `json:"-"`
+}
+
+// ChocoDependency is a chocolatey package the package depends on.
+type ChocoDependency struct {
+	ID      string `json:"id"`
+	Version string `json:"version,omitempty"` // a version range, such as [1.2,2.0)
+}
+
+// WingetSpec is the struct to decode the winget key of a wix.json file.
+type WingetSpec struct {
+	ID               string   `json:"id,omitempty"`            // the package identifier, Publisher.Package, defaults to the company and the product
+	InstallerURL     string   `json:"installer-url,omitempty"` // the download url of the msi file
+	License          string   `json:"license,omitempty"`       // such as MIT
+	LicenseURL       string   `json:"license-url,omitempty"`   // defaults to choco.license-url
+	ShortDescription string   `json:"short-description,omitempty"`
+	Description      string   `json:"description,omitempty"`
+	PublisherURL     string   `json:"publisher-url,omitempty"`
+	PackageURL       string   `json:"package-url,omitempty"` // defaults to choco.project-url
+	Moniker          string   `json:"moniker,omitempty"`
+	Tags             []string `json:"tags,omitempty"`
+}
+
+// ScoopSpec is the struct to decode the scoop key of a wix.json file.
+type ScoopSpec struct {
+	Name        string   `json:"name,omitempty"`         // the app name, defaults to the product
+	URLTemplate string   `json:"url-template,omitempty"` // the download url of the msi file, $$version in the manifest is replaced by the version
+	Description string   `json:"description,omitempty"`  // defaults to choco.description
+	Homepage    string   `json:"homepage,omitempty"`     // defaults to choco.project-url
+	License     string   `json:"license,omitempty"`      // defaults to winget.license
+	Bin         []string `json:"bin,omitempty"`          // the shimmed executables, defaults to the exe files of files.items
+	ExtractDir  string   `json:"extract-dir,omitempty"`  // the install directory in the msi file, defaults to PFiles\product
+	Checkver    string   `json:"checkver,omitempty"`     // defaults to github when the homepage is a github repository
+}
+
+// SigningSpec is the struct to decode the signing key of a wix.json file.
+type SigningSpec struct {
+	Certificate      string `json:"certificate,omitempty"` // a path to a pfx file
+	Thumbprint       string `json:"thumbprint,omitempty"`  // sha1 of a certificate in the store
+	Password         string `json:"password,omitempty"`
+	TimestampURL     string `json:"timestamp-url,omitempty"`
+	TimestampRetries int    `json:"timestamp-retries,omitempty"`
+	Digest           string `json:"digest,omitempty"`
+	Executables      bool   `json:"executables,omitempty"` // sign the exe and dll files before packaging them
+}
+
+// Enabled tells if the msi file should be signed.
+func (s SigningSpec) Enabled() bool {
+	return s.Certificate != "" || s.Thumbprint != ""
+}
+
+// SetDefaults applies the default digest and timestamp retries.
+func (s *SigningSpec) SetDefaults() {
+	if s.Digest == "" {
+		s.Digest = "sha256"
+	}
+	if s.TimestampRetries == 0 {
+		s.TimestampRetries = 3 // timestamp servers are flaky
+	}
+}
+
+// Check ensures the signing settings are complete and valid.
+func (s SigningSpec) Check() error {
+	problems := s.problems()
+	if !s.Enabled() {
+		problems = append(problems, `A "signing.certificate" or a "signing.thumbprint" is required`)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid signing settings:\n- %v", strings.Join(problems, "\n- "))
+	}
+	return nil
+}
+
+func (s SigningSpec) problems() []string {
+	problems := []string{}
+	if s.Certificate != "" && s.Thumbprint != "" {
+		problems = append(problems, `"signing.certificate" and "signing.thumbprint" are mutually exclusive`)
+	}
+	if _, ok := SigningDigests[s.Digest]; s.Digest != "" && !ok {
+		problems = append(problems, fmt.Sprintf(`Invalid "signing.digest" value: %q`, s.Digest))
+	}
+	if s.TimestampRetries < 0 {
+		problems = append(problems, `"signing.timestamp-retries" must not be negative`)
+	}
+	return problems
+}
+
+// Executables returns the exe and dll files of Files.Items and FileGroups items.
+func (wixFile *WixManifest) Executables() []string {
+	ret := []string{}
+	items := append([]string{}, wixFile.Files.Items...)
+	for _, g := range wixFile.FileGroups {
+		items = append(items, g.Items...)
+	}
+	for _, f := range items {
+		switch strings.ToLower(filepath.Ext(f)) {
+		case ".exe", ".dll":
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+// SigningDigests describes known signing digest algorithms.
+var SigningDigests = map[string]bool{
+	"sha1":   true,
+	"sha256": true,
+	"sha384": true,
+	"sha512": true,
 }
 
 const (
@@ -70,32 +319,390 @@ type Hook struct {
 	When          string `json:"when,omitempty"`
 }
 
-// WixFiles is the struct to decode files key of the wix.json file.
+const (
+	whenAfterInstall    = "afterInstall"
+	whenBeforeUninstall = "beforeUninstall"
+)
+
+// CustomActionPhases describes known custom action phases.
+var CustomActionPhases = map[string]bool{
+	whenAfterInstall:    true,
+	whenBeforeUninstall: true,
+}
+
+// WixCustomAction describes an installed executable, or PowerShell script, to run on install / uninstall.
+type WixCustomAction struct {
+	File          string `json:"file"` // a files.items entry, a .ps1 file is run with PowerShell
+	FileKey       string `json:"-"`
+	Script        bool   `json:"-"`
+	Arguments     string `json:"arguments,omitempty"`
+	When          string `json:"when"`
+	Condition     string `json:"condition,omitempty"`      // a WiX condition expression, the action runs only when it is true
+	Impersonate   bool   `json:"impersonate,omitempty"`    // run as the user instead of the local system
+	IgnoreFailure bool   `json:"ignore-failure,omitempty"` // by default a failure rolls back the install
+}
+
+// WixScheduledTask is the struct to decode scheduled-tasks values of the wix.json file,
+// a task of the Windows task scheduler, created by schtasks.exe on install, and deleted on uninstall.
+type WixScheduledTask struct {
+	Name          string `json:"name"`    // name of the task, a folder can prefix it, such as Hello\Update
+	Command       string `json:"command"` // an installed file, relative to the install directory, or a program path
+	Arguments     string `json:"arguments,omitempty"`
+	Trigger       string `json:"trigger"`             // logon, daily or boot
+	Time          string `json:"time,omitempty"`      // start time of a daily task, HH:MM, default 00:00
+	RunLevel      string `json:"run-level,omitempty"` // limited (default) or highest
+	User          string `json:"user,omitempty"`      // account running the task, default SYSTEM, Users runs a logon task in the session of the user
+	CreateCommand string `json:"-"`
+	DeleteCommand string `json:"-"`
+}
+
+// WixDriver is the struct to decode drivers values of the wix.json file,
+// a driver package installed by the DIFxApp extension of WiX 3,
+// its files are installed into their own directory, and the driver is removed on uninstall.
+type WixDriver struct {
+	Inf               string   `json:"inf"`                            // the inf file of the driver package
+	Files             []string `json:"files,omitempty"`                // the other files of the package, such as the sys and cat files
+	Dir               string   `json:"dir,omitempty"`                  // relative to the install directory, default drivers/ followed by the inf name
+	Legacy            bool     `json:"legacy,omitempty"`               // install an unsigned driver, or a driver which is not plug and play
+	PlugAndPlayPrompt bool     `json:"plug-and-play-prompt,omitempty"` // prompt the user to plug the device in when it is missing
+	ForceInstall      bool     `json:"force-install,omitempty"`        // replace the driver of the device, even when it is a better match
+	DirID             string   `json:"-"`
+	GUID              string   `json:"-"`
+}
+
+// WixEventSource is the struct to decode event-sources values of the wix.json file,
+// an event log source registered on install, and removed on uninstall.
+type WixEventSource struct {
+	Name        string `json:"name"`
+	Log         string `json:"log,omitempty"`          // Application (default), System, or a custom log
+	MessageFile string `json:"message-file,omitempty"` // a files.items, or file-groups items, entry, or a path, default EventCreate.exe
+	GUID        string `json:"-"`
+}
+
+// WixCom is the struct to decode com values of the wix.json file,
+// the COM classes, and the type library, an installed file registers.
+type WixCom struct {
+	File              string        `json:"file"` // a files.items, or file-groups items, entry
+	Classes           []WixComClass `json:"classes,omitempty"`
+	TypeLib           *WixTypeLib   `json:"typelib,omitempty"`
+	Harvest           bool          `json:"harvest,omitempty"` // extract the registration of the file with heat, at build time
+	FileKey           string        `json:"-"`
+	GUID              string        `json:"-"` // guid of the component of the harvested registry values
+	HarvestedFile     string        `json:"-"` // the Class and TypeLib elements harvested by heat
+	HarvestedRegistry string        `json:"-"` // the registry elements harvested by heat
+}
+
+// WixComClass describes a COM class of a WixCom file.
+type WixComClass struct {
+	CLSID          string `json:"clsid"`
+	ProgID         string `json:"progid,omitempty"`
+	Description    string `json:"description,omitempty"`
+	Server         string `json:"server,omitempty"`          // inproc (default) for a dll, or local for an exe
+	ThreadingModel string `json:"threading-model,omitempty"` // apartment (default), free, both or neutral, of an inproc server
+}
+
+// Context returns the Context of the Class element of the class.
+func (c WixComClass) Context() string {
+	return ComServers[c.Server]
+}
+
+// WixTypeLib describes the type library of a WixCom file.
+type WixTypeLib struct {
+	ID          string `json:"id"`
+	Version     string `json:"version,omitempty"` // major.minor, default 1.0
+	Language    int    `json:"language,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// VersionFields returns the major and the minor version of the type library.
+func (t WixTypeLib) VersionFields() []string {
+	if t.Version == "" {
+		return []string{"1", "0"}
+	}
+	return strings.SplitN(t.Version+".0", ".", 3)[:2]
+}
+
+// ComServers maps the servers of the COM classes to the Context of their Class element.
+var ComServers = map[string]string{
+	"inproc": "InprocServer32",
+	"local":  "LocalServer32",
+}
+
+// ComThreadingModels describes known threading models of the inproc COM classes.
+var ComThreadingModels = map[string]bool{
+	"apartment": true,
+	"free":      true,
+	"both":      true,
+	"neutral":   true,
+}
+
+var typeLibVersionRe = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+// FileCom returns the COM registration of the files or file-groups item of File Id key.
+func (wixFile *WixManifest) FileCom(key string) []WixCom {
+	ret := []WixCom{}
+	for _, c := range wixFile.Com {
+		if c.FileKey == key {
+			ret = append(ret, c)
+		}
+	}
+	return ret
+}
+
+// DefaultEventMessageFile is the message file of the event sources,
+// the one of golang.org/x/sys/windows/svc/eventlog.InstallAsEventCreate.
+const DefaultEventMessageFile = `%SystemRoot%\System32\EventCreate.exe`
+
+// WixPerfCategory is the struct to decode performance-counters values of the wix.json file,
+// a category of performance counters registered on install.
+type WixPerfCategory struct {
+	Name          string           `json:"name"`
+	Help          string           `json:"help,omitempty"`
+	MultiInstance bool             `json:"multi-instance,omitempty"` // the counters have an instance per process
+	Counters      []WixPerfCounter `json:"counters"`
+	GUID          string           `json:"-"`
+}
+
+// WixPerfCounter is a counter of a WixPerfCategory.
+type WixPerfCounter struct {
+	Name string `json:"name"`
+	Help string `json:"help,omitempty"`
+	Type string `json:"type,omitempty"` // see PerfCounterTypes, numberOfItems32 by default
+}
+
+// PerfCounterTypes describes known types of performance counters.
+var PerfCounterTypes = map[string]bool{
+	"numberOfItems32":         true,
+	"numberOfItems64":         true,
+	"numberOfItemsHEX32":      true,
+	"numberOfItemsHEX64":      true,
+	"rateOfCountsPerSecond32": true,
+	"rateOfCountsPerSecond64": true,
+	"counterDelta32":          true,
+	"counterDelta64":          true,
+	"rawFraction":             true,
+	"rawBase":                 true,
+	"averageTimer32":          true,
+	"averageCount64":          true,
+	"averageBase":             true,
+	"elapsedTime":             true,
+	"timer100Ns":              true,
+}
+
+// TaskTriggers maps the triggers of the scheduled tasks to the schedules of schtasks.
+var TaskTriggers = map[string]string{
+	"logon": "ONLOGON",
+	"daily": "DAILY",
+	"boot":  "ONSTART",
+}
+
+// TaskRunLevels maps the run levels of the scheduled tasks to those of schtasks.
+var TaskRunLevels = map[string]string{
+	"limited": "LIMITED",
+	"highest": "HIGHEST",
+}
+
+var taskTimeRe = regexp.MustCompile(`^([01][0-9]|2[0-3]):[0-5][0-9]$`)
+
+// WixFiles is the struct to decode files key of the wix.json file,
+// and the values of its file-groups key.
 type WixFiles struct {
-	GUID  string   `json:"guid"`
-	Items []string `json:"items"`
+	GUID        string   `json:"guid"`
+	Dir         string   `json:"dir,omitempty"` // target sub directory of a file group, relative to the install directory
+	DirSegments []string `json:"-"`
+	Items       []string `json:"items"`              // file paths or glob patterns, ** matches any number of directories
+	Exclude     []string `json:"exclude,omitempty"`  // glob patterns of the items to skip, matching the path or the file name
+	Feature     string   `json:"feature,omitempty"`  // feature of a file group
+	PerFile     bool     `json:"per-file,omitempty"` // one component, with a derived guid, per file, instead of a single component
+}
+
+// WixHarvest is the struct to decode harvest key of the wix.json file,
+// it filters the trees of the Directories.
+type WixHarvest struct {
+	Include   []string `json:"include,omitempty"`    // glob patterns of the files to install, all the files when empty
+	Exclude   []string `json:"exclude,omitempty"`    // glob patterns of the files and directories to skip
+	EmptyDirs *bool    `json:"empty-dirs,omitempty"` // install the empty sub directories, default true
+}
+
+// KeepEmptyDirs tells if the empty sub directories are installed.
+func (h WixHarvest) KeepEmptyDirs() bool {
+	return h.EmptyDirs == nil || *h.EmptyDirs
+}
+
+// WixDir describes a directory tree harvested from the Directories of the wix.json file.
+type WixDir struct {
+	ID         string       // wix Directory Id
+	Name       string       // name of the directory on the target system
+	Files      []WixDirFile // files of the directory
+	Dirs       []WixDir     // sub directories
+	Empty      bool         // the directory has no files, nor sub directories
+	GUID       string       // guid of the CreateFolder component of an empty directory
+	Components []string     // ids of all the components of the tree, set on the root only
+}
+
+// WixDirFile describes a file harvested from the Directories of the wix.json file.
+type WixDirFile struct {
+	ID          string          // wix File Id, the component Id is prefixed with Comp
+	Source      string          // path of the file relative to the wix templates files
+	Permissions []WixPermission // the permissions of the file
 }
 
 // WixEnvList is the struct to decode env key of the wix.json file.
 type WixEnvList struct {
-	GUID string   `json:"guid"`
-	Vars []WixEnv `json:"vars"`
+	GUID string         `json:"guid"`
+	Vars []WixEnv       `json:"vars"`
+	Path []WixPathEntry `json:"path,omitempty"`
+	// Components group the variables by feature, ENVS holds the variables of the main feature.
+	Components []WixEnvComponent `json:"-"`
+}
+
+// WixEnvComponent is a component of the variables of a feature.
+type WixEnvComponent struct {
+	ID      string
+	GUID    string // * for the components of the features
+	Feature string
+	Vars    []int // indexes of Vars
+	Path    []int // indexes of Path
+}
+
+// Empty tells if there is no variable to set.
+func (e WixEnvList) Empty() bool {
+	return len(e.Vars) == 0 && len(e.Path) == 0
+}
+
+// WixPathEntry is the struct to decode env.path items of the wix.json file,
+// it adds Dir to the PATH variable, only this segment is removed on uninstall.
+type WixPathEntry struct {
+	Dir      string `json:"dir"`      // defaults to [INSTALLDIR]
+	Position string `json:"position"` // last (default) or first
+	System   string `json:"system"`   // yes (default) or no, no for a perUser install
+	Feature  string `json:"feature,omitempty"`
 }
 
 // WixEnv is the struct to decode env value of the wix.json file.
+// Part first / last prepends / appends Value to the variable,
+// the segment is removed on uninstall, so a reinstall does not duplicate it.
 type WixEnv struct {
 	Name      string `json:"name"`
 	Value     string `json:"value"`
-	Permanent string `json:"permanent"`
-	System    string `json:"system"`
-	Action    string `json:"action"`
-	Part      string `json:"part"`
+	Permanent string `json:"permanent"` // yes or no (default)
+	System    string `json:"system"`    // yes or no (default)
+	Action    string `json:"action"`    // set (default), create or remove
+	Part      string `json:"part"`      // all (default), first or last
+	Feature   string `json:"feature,omitempty"`
+}
+
+// EnvActions describes known env actions.
+var EnvActions = map[string]bool{
+	"set":    true,
+	"create": true,
+	"remove": true,
+}
+
+// EnvParts describes known env parts.
+var EnvParts = map[string]bool{
+	"all":   true,
+	"first": true,
+	"last":  true,
+}
+
+// yesNo describes known yes / no values.
+var yesNo = map[string]bool{
+	"yes": true,
+	"no":  true,
 }
 
 // WixShortcuts is the struct to decode shortcuts key of the wix.json file.
 type WixShortcuts struct {
-	GUID  string        `json:"guid,omitempty"`
-	Items []WixShortcut `json:"items,omitempty"`
+	GUID              string        `json:"guid,omitempty"`
+	DesktopGUID       string        `json:"desktop-guid,omitempty"`
+	StartupGUID       string        `json:"startup-guid,omitempty"`
+	ProgramFolderGUID string        `json:"program-folder-guid,omitempty"`
+	StartMenuFolder   string        `json:"start-menu-folder,omitempty"` // the product name by default
+	Items             []WixShortcut `json:"items,omitempty"`
+	// Components group the shortcuts by location and feature.
+	Components []WixShortcutComponent `json:"-"`
+}
+
+// WixShortcutComponent is a component of the shortcuts of a location and a feature.
+type WixShortcutComponent struct {
+	ID       string
+	GUID     string // * for the components of the features
+	Location string
+	Dir      string // wix Directory Id of the location
+	Key      string // prefix of the registry value names of the shortcuts
+	Feature  string
+	Items    []int // indexes of Items
+}
+
+// shortcutComponents describes the component of the main feature of each location.
+var shortcutComponents = []struct {
+	location, id, dir, key string
+	guid                   func(s WixShortcuts) string
+}{
+	{locationStartMenu, "ApplicationShortcuts", "ProgramMenuSubfolder", "installed", func(s WixShortcuts) string { return s.GUID }},
+	{locationDesktop, "ApplicationDesktopShortcuts", "DesktopFolder", "desktop", func(s WixShortcuts) string { return s.DesktopGUID }},
+	{locationStartup, "ApplicationStartupShortcuts", "StartupFolder", "startup", func(s WixShortcuts) string { return s.StartupGUID }},
+	{locationProgramFolder, "ApplicationFolderShortcuts", "INSTALLDIR", "folder", func(s WixShortcuts) string { return s.ProgramFolderGUID }},
+}
+
+const (
+	locationStartMenu     = "startMenu"
+	locationDesktop       = "desktop"
+	locationStartup       = "startup"
+	locationProgramFolder = "programFolder"
+)
+
+// ShortcutLocations describes known shortcut locations.
+var ShortcutLocations = map[string]bool{
+	locationStartMenu:     true,
+	locationDesktop:       true,
+	locationStartup:       true,
+	locationProgramFolder: true,
+}
+
+// shortcutLocation returns the known location matching l regardless of its case,
+// startMenu when l is empty.
+func shortcutLocation(l string) (string, bool) {
+	if l == "" {
+		return locationStartMenu, true
+	}
+	for k := range ShortcutLocations {
+		if strings.EqualFold(k, l) {
+			return k, true
+		}
+	}
+	return l, false
+}
+
+func (s WixShortcuts) has(location string) bool {
+	for _, item := range s.Items {
+		if l, _ := shortcutLocation(item.Location); l == location {
+			return true
+		}
+	}
+	return false
+}
+
+// HasStartMenu tells if some shortcuts are installed in the start menu.
+func (s WixShortcuts) HasStartMenu() bool {
+	return s.has(locationStartMenu)
+}
+
+// HasDesktop tells if some shortcuts are installed on the desktop.
+func (s WixShortcuts) HasDesktop() bool {
+	return s.has(locationDesktop)
+}
+
+// HasStartup tells if some shortcuts are installed in the startup folder,
+// they are started when the user logs in.
+func (s WixShortcuts) HasStartup() bool {
+	return s.has(locationStartup)
+}
+
+// HasProgramFolder tells if some shortcuts are installed in the install directory.
+func (s WixShortcuts) HasProgramFolder() bool {
+	return s.has(locationProgramFolder)
 }
 
 // WixShortcut is the struct to decode shortcut value of the wix.json file.
@@ -105,129 +712,3182 @@ type WixShortcut struct {
 	Target      string `json:"target"`
 	WDir        string `json:"wdir"`
 	Arguments   string `json:"arguments"`
-	Icon        string `json:"icon"` // a path to the ico file, no space in it.
+	Icon        string `json:"icon"`               // a path to an ico, or png, file, no space in it.
+	Location    string `json:"location,omitempty"` // startMenu (default), desktop, startup or programFolder
+	Feature     string `json:"feature,omitempty"`
 }
 
-// Write the manifest to the given file,
-// if file is empty, writes to wix.json
-func (wixFile *WixManifest) Write(p string) error {
-	if p == "" {
-		p = "wix.json"
+// WixCleanup is the struct to decode cleanup key of the wix.json file.
+// Items are patterns, relative to the install directory, of files created at runtime
+// to remove on uninstall, such as logs/*.log, or cache/ to remove all the files of a directory.
+// Only the last path segment can contain wildcards (* and ?).
+type WixCleanup struct {
+	GUID  string           `json:"guid,omitempty"`
+	Items []string         `json:"items,omitempty"`
+	Dirs  []WixCleanupDir  `json:"-"` // deepest first
+	Files []WixCleanupFile `json:"-"`
+}
+
+// WixCleanupDir is a directory of the cleanup patterns,
+// it is removed on uninstall when it is empty.
+type WixCleanupDir struct {
+	ID       string
+	ParentID string
+	Name     string
+	depth    int
+}
+
+// WixCleanupFile is a file name pattern to remove from a cleanup directory.
+type WixCleanupFile struct {
+	DirID string
+	Name  string
+}
+
+// WixPermission is the struct to decode permissions values of the wix.json file,
+// the rights a user gets on an installed file or directory, added to its ACL.
+// A directory which is not installed is created.
+type WixPermission struct {
+	Path     string   `json:"path,omitempty"`    // file or directory, relative to the install directory, empty for the install directory
+	User     string   `json:"user"`              // user or group, such as Users or NT SERVICE\hello
+	Domain   string   `json:"domain,omitempty"`  // domain of the user, empty for a local user or a well known group
+	Rights   []string `json:"rights"`            // read, write, execute, delete or all
+	Inherit  *bool    `json:"inherit,omitempty"` // the files and sub directories of a directory get the rights too, default true
+	FileKey  string   `json:"-"`                 // File Id of a files or file-groups item
+	TreeFile bool     `json:"-"`                 // a file of the harvested directories, see WixDirFile.Permissions
+	DirID    string   `json:"-"`                 // Directory Id of a directory
+	GUID     string   `json:"-"`                 // guid of the component of a directory
+}
+
+// PermissionRights maps the rights of a permission to the attributes of util:PermissionEx.
+var PermissionRights = map[string]string{
+	"read":    "GenericRead",
+	"write":   "GenericWrite",
+	"execute": "GenericExecute",
+	"delete":  "Delete",
+	"all":     "GenericAll",
+}
+
+// Attributes returns the util:PermissionEx attributes of the rights.
+func (p WixPermission) Attributes() []string {
+	ret := []string{}
+	for _, r := range p.Rights {
+		if a, ok := PermissionRights[r]; ok {
+			ret = append(ret, a)
+		}
 	}
-	byt, err := json.MarshalIndent(wixFile, "", "  ")
-	if err != nil {
-		return err
+	return ret
+}
+
+// Inheritable tells if the files and sub directories get the rights.
+func (p WixPermission) Inheritable() bool {
+	return p.Inherit == nil || *p.Inherit
+}
+
+// permissionPath returns the path p of a permission, relative to the install directory,
+// with slashes, empty for the install directory.
+func permissionPath(p string) string {
+	rel := strings.Trim(filepath.ToSlash(filepath.Clean(strings.Replace(p, "\\", "/", -1))), "/")
+	if rel == "." {
+		return ""
 	}
-	err = ioutil.WriteFile(p, byt, 0644)
-	if err != nil {
-		return err
+	return rel
+}
+
+// WixSubDir is a sub directory of the install directory,
+// declared for the paths of the permissions, or of the configs.
+type WixSubDir struct {
+	ID       string
+	ParentID string
+	Name     string
+}
+
+// subDirID returns the Directory Id of rel, a directory relative to the install directory,
+// the missing directories are appended to dirs, their Id is prefix followed by their index.
+func subDirID(ids map[string]string, dirs *[]WixSubDir, prefix, rel string) string {
+	segs := strings.Split(rel, "/")
+	for k, seg := range segs {
+		dir := strings.Join(segs[:k+1], "/")
+		if _, ok := ids[dir]; !ok && seg != "" {
+			ids[dir] = prefix + strconv.Itoa(len(*dirs))
+			*dirs = append(*dirs, WixSubDir{
+				ID:       ids[dir],
+				ParentID: ids[strings.Join(segs[:k], "/")],
+				Name:     seg,
+			})
+		}
 	}
-	return nil
+	return ids[rel]
 }
 
-// Load the manifest from given file path,
-// if the file path is empty, reads from wix.json
-func (wixFile *WixManifest) Load(p string) error {
-	if p == "" {
-		p = "wix.json"
+// WixConfig is the struct to decode configs values of the wix.json file,
+// a configuration file rendered with the manifest at build time.
+// It is never overwritten once installed, so the user edits survive the upgrades.
+type WixConfig struct {
+	Source            string `json:"source"`                        // the file, or the template, such as app.ini.tmpl
+	Name              string `json:"name,omitempty"`                // installed file name, defaults to the source name without its .tmpl extension
+	Dir               string `json:"dir,omitempty"`                 // target sub directory, relative to the install directory
+	Template          *bool  `json:"template,omitempty"`            // render the source as a go template, default true
+	RemoveOnUninstall *bool  `json:"remove-on-uninstall,omitempty"` // remove the file when the product is uninstalled, default true
+	File              string `json:"-"`                             // the rendered file, relative to the build directory
+	DirID             string `json:"-"`
+	GUID              string `json:"-"`
+}
+
+// IsTemplate tells if the source is rendered as a go template.
+func (c WixConfig) IsTemplate() bool {
+	return c.Template == nil || *c.Template
+}
+
+// Permanent tells if the file is left on the system on uninstall.
+func (c WixConfig) Permanent() bool {
+	return c.RemoveOnUninstall != nil && !*c.RemoveOnUninstall
+}
+
+// driverDir returns the directory of the driver package d, relative to the install directory.
+func (wixFile *WixManifest) driverDir(d WixDriver) string {
+	if d.Dir != "" {
+		return permissionPath(d.Dir)
 	}
-	if _, err := os.Stat(p); os.IsNotExist(err) {
-		return err
+	name := filepath.Base(filepath.ToSlash(d.Inf))
+	return "drivers/" + strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// removesConfigs tells if a config is removed on uninstall.
+func (wixFile *WixManifest) removesConfigs() bool {
+	for _, c := range wixFile.Configs {
+		if !c.Permanent() {
+			return true
+		}
 	}
-	dat, err := ioutil.ReadFile(p)
-	if err != nil {
-		return fmt.Errorf("JSON ReadFile failed with %v", err)
+	return false
+}
+
+// FilePermissions returns the permissions of the files or file-groups item of File Id key.
+func (wixFile *WixManifest) FilePermissions(key string) []WixPermission {
+	ret := []WixPermission{}
+	for _, p := range wixFile.Permissions {
+		if p.FileKey == key {
+			ret = append(ret, p)
+		}
 	}
-	err = json.Unmarshal(dat, &wixFile)
+	return ret
+}
+
+// installedFileID returns the File Id of the files, or file-groups, item
+// installed at rel, relative to the install directory.
+func (wixFile *WixManifest) installedFileID(rel string) (string, bool) {
+	for i, f := range wixFile.Files.Items {
+		if strings.EqualFold(filepath.Base(f), rel) {
+			return "ApplicationFile" + strconv.Itoa(i), true
+		}
+	}
+	for g, group := range wixFile.FileGroups {
+		dir := strings.Trim(filepath.ToSlash(group.Dir), "/")
+		for i, f := range group.Items {
+			if strings.EqualFold(strings.TrimPrefix(dir+"/"+filepath.Base(f), "/"), rel) {
+				return "GroupFile" + strconv.Itoa(g) + "_" + strconv.Itoa(i), true
+			}
+		}
+	}
+	return "", false
+}
+
+// Culture describes the LCID and the codepage of a culture.
+type Culture struct {
+	LCID     int
+	Codepage int
+}
+
+// KnownCultures describes the supported cultures, by name.
+var KnownCultures = map[string]Culture{
+	"cs-cz": {1029, 1250},
+	"da-dk": {1030, 1252},
+	"de-de": {1031, 1252},
+	"el-gr": {1032, 1253},
+	"en-us": {1033, 1252},
+	"es-es": {3082, 1252},
+	"fi-fi": {1035, 1252},
+	"fr-fr": {1036, 1252},
+	"hu-hu": {1038, 1250},
+	"it-it": {1040, 1252},
+	"ja-jp": {1041, 932},
+	"ko-kr": {1042, 949},
+	"nb-no": {1044, 1252},
+	"nl-nl": {1043, 1252},
+	"pl-pl": {1045, 1250},
+	"pt-br": {1046, 1252},
+	"pt-pt": {2070, 1252},
+	"ru-ru": {1049, 1251},
+	"sv-se": {1053, 1252},
+	"tr-tr": {1055, 1254},
+	"uk-ua": {1058, 1251},
+	"zh-cn": {2052, 936},
+	"zh-tw": {1028, 950},
+}
+
+// readWxl returns the strings, by id, of the WiX localization file p.
+func readWxl(p string) (map[string]string, error) {
+	byt, err := ioutil.ReadFile(p)
 	if err != nil {
-		return fmt.Errorf("JSON Unmarshal failed with %v", err)
+		return nil, err
 	}
-	return nil
+	var l struct {
+		Strings []struct {
+			ID    string `xml:"Id,attr"`
+			Value string `xml:"Value,attr"`
+			Text  string `xml:",chardata"`
+		} `xml:"String"`
+	}
+	if err := xml.Unmarshal(byt, &l); err != nil {
+		return nil, err
+	}
+	ret := map[string]string{}
+	for _, s := range l.Strings {
+		ret[s.ID] = s.Text
+		if s.Value != "" {
+			ret[s.ID] = s.Value
+		}
+	}
+	return ret, nil
 }
 
-//SetGuids generates and apply guid values appropriately
-func (wixFile *WixManifest) SetGuids(force bool) (bool, error) {
-	updated := false
-	if wixFile.UpgradeCode == "" || force {
-		wixFile.UpgradeCode = uuid.NewV4().String()
-		updated = true
+// cultureName returns the name of the known culture l, a name or an LCID,
+// it returns an empty string if l is unknown.
+func cultureName(l string) string {
+	l = strings.ToLower(strings.TrimSpace(l))
+	if _, ok := KnownCultures[l]; ok {
+		return l
+	}
+	for name, c := range KnownCultures {
+		if strconv.Itoa(c.LCID) == l {
+			return name
+		}
+	}
+	return ""
+}
+
+// WixCulture is one of the languages of the wix.json file,
+// a localization file and an msi package are built for each of them.
+type WixCulture struct {
+	Culture
+	Name    string
+	Default bool              // the first language, its msi package keeps the requested name
+	Strings map[string]string // the localized strings, by id
+}
+
+// WxlFile returns the name of the localization file of the culture.
+func (c WixCulture) WxlFile() string {
+	return c.Name + ".wxl"
+}
+
+// MsiFile returns the path of the msi package of the culture,
+// given the requested msi path.
+func (c WixCulture) MsiFile(msi string) string {
+	if c.Default {
+		return msi
+	}
+	ext := filepath.Ext(msi)
+	return strings.TrimSuffix(msi, ext) + "-" + c.Name + ext
+}
+
+// Archs maps known architectures, Go or WiX names, to WiX names.
+var Archs = map[string]string{
+	"386":   "x86",
+	"amd64": "x64",
+	"arm64": "arm64",
+	"x86":   "x86",
+	"x64":   "x64",
+}
+
+// InstallScopes describes known install scopes.
+var InstallScopes = map[string]bool{
+	"perMachine": true,
+	"perUser":    true,
+	"dual":       true,
+}
+
+// VersionPolicies describes how the prerelease and build metadata
+// of the version map to the fourth field of the msi version.
+var VersionPolicies = map[string]bool{
+	"build":      true, // the first field of the build metadata, when it is a number
+	"prerelease": true, // the last field of the prerelease, when it is a number
+	"drop":       true, // no fourth field
+}
+
+// WixUI is the struct to decode ui key of the wix.json file.
+type WixUI struct {
+	Dialogs          string     `json:"dialogs,omitempty"`            // installDir (default), minimal, featureTree or none
+	InstallDirDialog *bool      `json:"install-dir-dialog,omitempty"` // let the user choose INSTALLDIR, default true
+	LicenseDialog    *bool      `json:"license-dialog,omitempty"`     // show the license, default true when there is a license
+	Banner           string     `json:"banner,omitempty"`             // a 493x58 bmp file, the top banner of the dialogs
+	Background       string     `json:"background,omitempty"`         // a 493x312 bmp file, the background of the first and last dialogs
+	Launch           *WixLaunch `json:"launch,omitempty"`             // a checkbox of the exit dialog to launch the program
+	ShowInstallDir   bool       `json:"-"`
+	ShowLicense      bool       `json:"-"`
+}
+
+// WixLaunch is the struct to decode ui.launch key of the wix.json file,
+// a checkbox of the exit dialog which opens a file, or a url, once the install completes.
+type WixLaunch struct {
+	File    string `json:"file,omitempty"`    // a files.items, or file-groups items, entry, a program is run, a document is opened
+	URL     string `json:"url,omitempty"`     // opened by the default browser
+	Text    string `json:"text,omitempty"`    // label of the checkbox, default Launch followed by the product name
+	Checked *bool  `json:"checked,omitempty"` // the checkbox is checked, default true
+	Target  string `json:"-"`                 // the file reference, or the url, opened by WixShellExec
+}
+
+// IsChecked tells if the checkbox is checked by default.
+func (l WixLaunch) IsChecked() bool {
+	return l.Checked == nil || *l.Checked
+}
+
+// UIDialogs describes known dialog sets.
+var UIDialogs = map[string]bool{
+	"installDir":  true,
+	"minimal":     true,
+	"featureTree": true,
+	"none":        true,
+}
+
+// WixUpgrade is the struct to decode upgrade key of the wix.json file.
+type WixUpgrade struct {
+	AllowDowngrades          bool   `json:"allow-downgrades,omitempty"`
+	AllowSameVersionUpgrades bool   `json:"allow-same-version-upgrades,omitempty"`
+	DowngradeErrorMessage    string `json:"downgrade-error-message,omitempty"`
+	Schedule                 string `json:"schedule,omitempty"`
+	Disallow                 bool   `json:"disallow,omitempty"` // the previous version must be uninstalled first
+	DisallowErrorMessage     string `json:"disallow-error-message,omitempty"`
+	IgnoreRemoveFailure      bool   `json:"ignore-remove-failure,omitempty"` // install even when the previous version fails to uninstall
+}
+
+// WixARP is the struct to decode arp key of the wix.json file,
+// it describes the entry of the product in Programs and Features.
+type WixARP struct {
+	Icon          string `json:"icon,omitempty"` // a path to an ico, or png, file, no space in it.
+	HelpLink      string `json:"help-link,omitempty"`
+	AboutURL      string `json:"about-url,omitempty"`
+	UpdateURL     string `json:"update-url,omitempty"`
+	Contact       string `json:"contact,omitempty"`
+	Comments      string `json:"comments,omitempty"`
+	NoModify      bool   `json:"no-modify,omitempty"`
+	NoRepair      bool   `json:"no-repair,omitempty"`
+	NoRemove      bool   `json:"no-remove,omitempty"`
+	EstimatedSize int    `json:"estimated-size,omitempty"` // in KB, computed by windows by default
+}
+
+// WixFeature is the struct to decode features values of the wix.json file,
+// a part of the product the user can choose to install, or not.
+// Items are assigned to a feature with their feature key, the other items belong to the main feature.
+type WixFeature struct {
+	ID          string       `json:"id"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	Default     string       `json:"default,omitempty"` // install (default) or absent
+	Level       int          `json:"level,omitempty"`   // 1 when installed by default, 1000 otherwise
+	Required    bool         `json:"required,omitempty"`
+	Display     string       `json:"display,omitempty"`  // collapse (default), expand or hidden
+	Features    []WixFeature `json:"features,omitempty"` // sub features
+	Components  []string     `json:"-"`
+	Groups      []string     `json:"-"` // component groups
+}
+
+// FeatureDisplays describes known feature displays.
+var FeatureDisplays = map[string]bool{
+	"collapse": true,
+	"expand":   true,
+	"hidden":   true,
+}
+
+// featureIDRe matches valid feature ids.
+var featureIDRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]{0,37}$`)
+
+// walkFeatures calls fn with each feature of fs and of their sub features.
+func walkFeatures(fs []WixFeature, fn func(f *WixFeature)) {
+	for i := range fs {
+		fn(&fs[i])
+		walkFeatures(fs[i].Features, fn)
+	}
+}
+
+// featureItem is an item of the manifest assigned to a feature.
+type featureItem struct {
+	field     string
+	feature   string
+	component string // empty when the component is shared with other items
+	group     bool   // component is a component group
+}
+
+// featureItems returns the items assigned to a feature.
+func (wixFile *WixManifest) featureItems() []featureItem {
+	ret := []featureItem{}
+	add := func(field, feature, component string) {
+		if feature != "" {
+			ret = append(ret, featureItem{field: field, feature: feature, component: component})
+		}
+	}
+	add("files", wixFile.Files.Feature, "")
+	for i, g := range wixFile.FileGroups {
+		add(fmt.Sprintf("file-groups[%d]", i), g.Feature, fmt.Sprintf("GroupFiles%d", i))
+		if g.PerFile && g.Feature != "" {
+			ret[len(ret)-1].group = true
+		}
+	}
+	for i, e := range wixFile.Env.Vars {
+		add(fmt.Sprintf("env.vars[%d]", i), e.Feature, "")
+	}
+	for i, p := range wixFile.Env.Path {
+		add(fmt.Sprintf("env.path[%d]", i), p.Feature, "")
+	}
+	for i, s := range wixFile.Shortcuts.Items {
+		add(fmt.Sprintf("shortcuts.items[%d]", i), s.Feature, "")
+	}
+	for i, r := range wixFile.Registry {
+		add(fmt.Sprintf("registry[%d]", i), r.Feature, fmt.Sprintf("Registry%d", i))
+	}
+	for i, svc := range wixFile.Services {
+		add(fmt.Sprintf("services[%d]", i), svc.Feature, fmt.Sprintf("Service%d", i))
+	}
+	for i, a := range wixFile.FileAssociations {
+		add(fmt.Sprintf("file-associations[%d]", i), a.Feature, fmt.Sprintf("FileAssociation%d", i))
+	}
+	return ret
+}
+
+// WixBundle is the struct to decode bundle key of the wix.json file,
+// it describes the bootstrapper built by go-msi bundle,
+// it installs the Prerequisites, then the msi package of the product.
+type WixBundle struct {
+	Name          string             `json:"name,omitempty"`         // name of the bundle, default the product name
+	UpgradeCode   string             `json:"upgrade-code,omitempty"` // derived from the upgrade-code of the product when empty
+	LicenseURL    string             `json:"license-url,omitempty"`  // the license is linked instead of displayed
+	Searches      []WixBundleSearch  `json:"searches,omitempty"`
+	Prerequisites []WixBundlePackage `json:"prerequisites,omitempty"`
+	MsiFile       string             `json:"-"` // the msi package of the product, relative to the build directory
+}
+
+// WixBundleSearch is the struct to decode bundle.searches values of the wix.json file,
+// it reads a registry value into a variable, before the detection of the packages.
+type WixBundleSearch struct {
+	Variable string `json:"variable"`
+	Root     string `json:"root,omitempty"` // HKLM (default), HKCU, HKCR or HKU
+	Key      string `json:"key"`
+	Value    string `json:"value,omitempty"` // the variable tells if the key exists when empty
+	Win64    bool   `json:"win64,omitempty"` // search the 64 bits registry view
+}
+
+// WixBundlePackage is the struct to decode bundle.prerequisites values of the wix.json file.
+type WixBundlePackage struct {
+	ID               string `json:"id"`
+	Type             string `json:"type,omitempty"`              // exe or msi, default from the source extension
+	Source           string `json:"source"`                      // path of the package, read when the bundle is built
+	URL              string `json:"url,omitempty"`               // the package is downloaded at install instead of embedded
+	DetectCondition  string `json:"detect-condition,omitempty"`  // burn condition telling an exe is installed
+	InstallCondition string `json:"install-condition,omitempty"` // burn condition telling the package is needed
+	Arguments        string `json:"arguments,omitempty"`         // command line of an exe
+	Permanent        bool   `json:"permanent,omitempty"`         // kept when the bundle is uninstalled
+}
+
+// WixPatch is the struct to decode patch key of the wix.json file,
+// it describes the msp packages built by go-msi patch.
+type WixPatch struct {
+	Classification string `json:"classification,omitempty"` // Update (default), Hotfix, Security Rollup, Critical Update, Service Pack or Update Rollup
+	Description    string `json:"description,omitempty"`    // default Update of the product
+	NoRemoval      bool   `json:"no-removal,omitempty"`     // the patch can not be uninstalled alone
+}
+
+// PatchClassifications describes the known classifications of a patch.
+var PatchClassifications = map[string]bool{
+	"Update":          true,
+	"Hotfix":          true,
+	"Security Rollup": true,
+	"Critical Update": true,
+	"Service Pack":    true,
+	"Update Rollup":   true,
+}
+
+// BundlePackageTypes describes the known types of the bundle packages.
+var BundlePackageTypes = map[string]bool{
+	"exe": true,
+	"msi": true,
+}
+
+// BundleSearchRoots describes the registry roots of the bundle searches.
+var BundleSearchRoots = map[string]bool{
+	"HKLM": true,
+	"HKCU": true,
+	"HKCR": true,
+	"HKU":  true,
+}
+
+var bundleIDRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// UpgradeSchedules describes known schedules of the removal of the installed product.
+var UpgradeSchedules = map[string]bool{
+	"afterInstallValidate":     true,
+	"afterInstallInitialize":   true,
+	"afterInstallExecute":      true,
+	"afterInstallExecuteAgain": true,
+	"afterInstallFinalize":     true,
+}
+
+// WixCondition is the struct to decode launch-conditions values of the wix.json file,
+// the install is aborted with Message when one of the given requirements is not met.
+type WixCondition struct {
+	Condition       string             `json:"condition,omitempty"`         // a WiX condition expression
+	MinWindowsBuild int                `json:"min-windows-build,omitempty"` // such as 10240 for Windows 10
+	Registry        *WixRegistrySearch `json:"registry,omitempty"`          // a registry value which must exist
+	File            string             `json:"file,omitempty"`              // the full path of a file which must exist, such as [SystemFolder]vcruntime140.dll
+	Runtime         string             `json:"runtime,omitempty"`           // a runtime which must be installed, see Runtimes
+	MinDiskSpace    int                `json:"min-disk-space,omitempty"`    // MB of free space on the drive of the install directory
+	Conflict        string             `json:"conflict,omitempty"`          // upgrade code of a product which must not be installed
+	Message         string             `json:"message"`
+	CookedCondition string             `json:"-"` // empty when the entry only checks the disk space
+	FileDir         string             `json:"-"`
+	FileName        string             `json:"-"`
+	RuntimeSearch   *WixRuntime        `json:"-"`
+}
+
+// DiskSpaceUnits returns MinDiskSpace in units of 512 bytes, those of PrimaryVolumeSpaceAvailable.
+func (c WixCondition) DiskSpaceUnits() int {
+	return c.MinDiskSpace * 2048
+}
+
+// WixRuntime describes the registry value of an installed runtime,
+// the raw value must be at least Min.
+type WixRuntime struct {
+	Key   string
+	Name  string
+	Win64 string // yes to read the 64 bits registry
+	Min   string // such as #1 for a DWORD 1
+}
+
+// Runtimes describes the known runtimes of the launch conditions,
+// the Visual C++ 2015-2022 redistributables and the .NET Framework versions.
+var Runtimes = map[string]WixRuntime{
+	"vcredist-x86":   {`SOFTWARE\Microsoft\VisualStudio\14.0\VC\Runtimes\x86`, "Installed", "no", "#1"},
+	"vcredist-x64":   {`SOFTWARE\Microsoft\VisualStudio\14.0\VC\Runtimes\x64`, "Installed", "yes", "#1"},
+	"vcredist-arm64": {`SOFTWARE\Microsoft\VisualStudio\14.0\VC\Runtimes\arm64`, "Installed", "yes", "#1"},
+	"netfx-4.6.2":    {`SOFTWARE\Microsoft\NET Framework Setup\NDP\v4\Full`, "Release", "no", "#394802"},
+	"netfx-4.7.2":    {`SOFTWARE\Microsoft\NET Framework Setup\NDP\v4\Full`, "Release", "no", "#461808"},
+	"netfx-4.8":      {`SOFTWARE\Microsoft\NET Framework Setup\NDP\v4\Full`, "Release", "no", "#528040"},
+	"netfx-4.8.1":    {`SOFTWARE\Microsoft\NET Framework Setup\NDP\v4\Full`, "Release", "no", "#533320"},
+}
+
+// WixRegistrySearch describes a registry value, an empty Name is the default value of Key.
+type WixRegistrySearch struct {
+	Root string `json:"root"`
+	Key  string `json:"key"`
+	Name string `json:"name,omitempty"`
+}
+
+// RegistryRoots describes known registry roots.
+var RegistryRoots = map[string]bool{
+	"HKLM": true,
+	"HKCU": true,
+	"HKCR": true,
+	"HKU":  true,
+}
+
+// WixService is the struct to decode services values of the wix.json file,
+// the service is started on install, stopped and deleted on uninstall.
+type WixService struct {
+	File        string              `json:"file"` // a files.items entry
+	FileIndex   int                 `json:"-"`
+	Name        string              `json:"name"`
+	DisplayName string              `json:"display-name,omitempty"`
+	Description string              `json:"description,omitempty"`
+	Start       string              `json:"start,omitempty"`   // auto (default), demand or disabled
+	Account     string              `json:"account,omitempty"` // LocalSystem by default
+	Password    string              `json:"password,omitempty"`
+	Arguments   string              `json:"arguments,omitempty"`
+	Recovery    *WixServiceRecovery `json:"recovery,omitempty"`
+	Feature     string              `json:"feature,omitempty"`
+}
+
+// WixServiceRecovery describes the actions taken when a service fails.
+type WixServiceRecovery struct {
+	First        string `json:"first,omitempty"`         // none (default), restart or reboot
+	Second       string `json:"second,omitempty"`        // none (default), restart or reboot
+	Subsequent   string `json:"subsequent,omitempty"`    // none (default), restart or reboot
+	RestartDelay int    `json:"restart-delay,omitempty"` // in seconds
+	ResetPeriod  int    `json:"reset-period,omitempty"`  // in days, the failure count is reset after it
+}
+
+// ServiceStarts describes known service start types.
+var ServiceStarts = map[string]bool{
+	"auto":     true,
+	"demand":   true,
+	"disabled": true,
+}
+
+// ServiceRecoveryActions describes known service recovery actions.
+var ServiceRecoveryActions = map[string]bool{
+	"none":    true,
+	"restart": true,
+	"reboot":  true,
+}
+
+// WixFileAssociation is the struct to decode file-associations values of the wix.json file,
+// it registers File to open the files of Extension, the registration is removed on uninstall.
+type WixFileAssociation struct {
+	Extension   string    `json:"extension"` // such as txt, without the leading dot
+	File        string    `json:"file"`      // a files.items, or file-groups items, entry
+	FileKey     string    `json:"-"`
+	ProgID      string    `json:"prog-id,omitempty"` // Product.Extension by default
+	Description string    `json:"description,omitempty"`
+	ContentType string    `json:"content-type,omitempty"` // a MIME type
+	Icon        string    `json:"icon,omitempty"`         // a files.items, or file-groups items, entry, File by default
+	IconKey     string    `json:"-"`
+	IconIndex   int       `json:"icon-index,omitempty"`
+	Verbs       []WixVerb `json:"verbs,omitempty"` // a single open verb by default
+	Feature     string    `json:"feature,omitempty"`
+}
+
+// WixVerb is a command of the context menu of the associated files.
+type WixVerb struct {
+	ID        string `json:"id"`                  // such as open, edit or print
+	Label     string `json:"label,omitempty"`     // text of the menu item
+	Arguments string `json:"arguments,omitempty"` // "%1" by default, %1 is the path of the file
+}
+
+// IsServiceFile tells if the files.items entry at index i is the executable of a service,
+// it is then installed by the component of the service.
+func (wixFile *WixManifest) IsServiceFile(i int) bool {
+	for _, s := range wixFile.Services {
+		if s.FileIndex == i {
+			return true
+		}
+	}
+	return false
+}
+
+// WixRegistryValue is the struct to decode registry values of the wix.json file.
+type WixRegistryValue struct {
+	Root      string `json:"root"`
+	Key       string `json:"key"`
+	Name      string `json:"name,omitempty"` // empty for the default value of Key
+	Value     string `json:"value"`
+	Type      string `json:"type,omitempty"`      // string (default), integer, expandable, multiString or binary
+	Permanent bool   `json:"permanent,omitempty"` // left in the registry on uninstall
+	Feature   string `json:"feature,omitempty"`
+}
+
+// RegistryTypes describes known registry value types.
+var RegistryTypes = map[string]bool{
+	"string":      true,
+	"integer":     true,
+	"expandable":  true,
+	"multiString": true,
+	"binary":      true,
+}
+
+// NeedWindowsBuild tells if a launch condition requires the build number of Windows.
+func (wixFile *WixManifest) NeedWindowsBuild() bool {
+	for _, c := range wixFile.Conditions {
+		if c.MinWindowsBuild > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// NeedDiskSpace tells if a launch condition requires the free space of the install drive.
+func (wixFile *WixManifest) NeedDiskSpace() bool {
+	for _, c := range wixFile.Conditions {
+		if c.MinDiskSpace > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// WixFirewall is the struct to decode firewall key of the wix.json file.
+type WixFirewall struct {
+	GUID  string            `json:"guid,omitempty"`
+	Rules []WixFirewallRule `json:"rules,omitempty"`
+}
+
+// WixFirewallRule is the struct to decode firewall rule value of the wix.json file.
+type WixFirewallRule struct {
+	Name            string   `json:"name"`
+	Program         string   `json:"program,omitempty"` // a files.items entry, or a wix formatted value
+	CookedProgram   string   `json:"-"`
+	Port            string   `json:"port,omitempty"`
+	Protocol        string   `json:"protocol,omitempty"`         // tcp or udp
+	Scope           string   `json:"scope,omitempty"`            // any or localSubnet
+	RemoteAddresses []string `json:"remote-addresses,omitempty"` // addresses, ranges or subnets, instead of a scope
+	Profile         string   `json:"profile,omitempty"`          // domain, private, public or all
+	IgnoreFailure   bool     `json:"ignore-failure,omitempty"`   // by default a failure to add the rule rolls back the install
+}
+
+// FirewallProtocols describes known firewall rule protocols.
+var FirewallProtocols = map[string]bool{
+	"tcp": true,
+	"udp": true,
+}
+
+// FirewallScopes describes known firewall rule scopes.
+var FirewallScopes = map[string]bool{
+	"any":         true,
+	"localSubnet": true,
+}
+
+// FirewallProfiles describes known firewall rule profiles.
+var FirewallProfiles = map[string]bool{
+	"domain":  true,
+	"private": true,
+	"public":  true,
+	"all":     true,
+}
+
+// Write the manifest to the given file,
+// if file is empty, writes to the manifest file read, see DefaultPaths,
+// if file is -, writes to stdout.
+// A yaml, yml or toml file is written in its format, without its comments, see WriteGuids.
+func (wixFile *WixManifest) Write(p string) error {
+	p = defaultPath(p)
+	byt, err := json.MarshalIndent(wixFile, "", "  ")
+	if err != nil {
+		return err
+	}
+	if byt, err = fromJSON(p, byt); err != nil {
+		return err
+	}
+	if p == "-" {
+		_, err = os.Stdout.Write(append(byt, '\n'))
+		return err
+	}
+	err = ioutil.WriteFile(p, byt, 0644)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// WriteGuids writes the guids of wixFile, loaded with LoadRaw, to the given file, like Write,
+// but the guids are set into a yaml or yml file, so its comments and the order of its keys are kept.
+// A toml file can not be updated, see Guids.
+func (wixFile *WixManifest) WriteGuids(p string) error {
+	p = defaultPath(p)
+	switch Format(p) {
+	case "toml":
+		return fmt.Errorf("The TOML manifest %q can not be updated without losing its comments", p)
+	case "yaml":
+	default:
+		return wixFile.Write(p)
+	}
+	dat, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err = yaml.Unmarshal(dat, &doc); err != nil {
+		return fmt.Errorf("YAML Unmarshal of %q failed with %v", p, err)
+	}
+	var src interface{}
+	byt, err := json.Marshal(wixFile)
+	if err != nil {
+		return err
+	}
+	if err = json.Unmarshal(byt, &src); err != nil {
+		return err
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	patchGuids(src, doc.Content[0])
+
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(yamlIndent(dat))
+	if err = enc.Encode(&doc); err != nil {
+		return err
+	}
+	if err = enc.Close(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, buf.Bytes(), 0644)
+}
+
+// Guids returns the non empty guids of wixFile, such as files.guid = "...",
+// to write them by hand into a manifest which can not be updated.
+func (wixFile *WixManifest) Guids() ([]string, error) {
+	var v interface{}
+	byt, err := json.Marshal(wixFile)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(byt, &v); err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	listGuids(v, "", &ret)
+	return ret, nil
+}
+
+// Format returns the format of the manifest file p, json, yaml or toml,
+// according to its extension.
+func Format(p string) string {
+	switch strings.ToLower(filepath.Ext(defaultPath(p))) {
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".toml":
+		return "toml"
+	}
+	return "json"
+}
+
+// Load the manifest from given file path,
+// if the file path is empty, reads from wix.json,
+// if the file path is -, reads from stdin.
+// The manifests it extends are merged, see LoadExtended,
+// then environment variables references are expanded, see ExpandEnv,
+// and glob patterns of files are expanded, see ExpandGlobs.
+// The unknown fields and type mismatches are logged as warnings, see LoadStrict.
+func (wixFile *WixManifest) Load(p string) error {
+	if err := wixFile.LoadExtended(p); err != nil {
+		return err
+	}
+	if err := wixFile.ExpandEnv(); err != nil {
+		return err
+	}
+	return wixFile.ExpandGlobs()
+}
+
+// LoadExtended loads the manifest from given file path,
+// and merges it over the manifest its extends key points to, recursively.
+// The merge rules are:
+// - objects are merged key by key,
+// - the extending manifest wins, unless its value is null or an empty string,
+// - lists are replaced, except files.items and env.vars, the parent items come first,
+// - guids and upgrade-code are never inherited.
+func (wixFile *WixManifest) LoadExtended(p string) error {
+	warn := func(p string, dat []byte) error {
+		// syntax errors are reported by the decoding of the manifest
+		if problems, err := schema.Check(reflect.TypeOf(*wixFile), dat, nil); err == nil && len(problems) > 0 {
+			logger.Default.Warn("the manifest %q does not match its schema:\n- %v", p, strings.Join(problems, "\n- "))
+		}
+		return nil
+	}
+	dat, err := readExtended(p, warn, map[string]bool{})
+	if err != nil {
+		return err
+	}
+	if dat, err = applyProfile(dat, wixFile.Profile); err != nil {
+		return err
+	}
+	err = json.Unmarshal(dat, &wixFile)
+	if err != nil {
+		return fmt.Errorf("JSON Unmarshal failed with %v", err)
+	}
+	return nil
+}
+
+// LoadRaw loads the manifest from given file path as is,
+// use it to update the manifest file with Write.
+// If the file path is -, reads from stdin.
+func (wixFile *WixManifest) LoadRaw(p string) error {
+	dat, err := read(p)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(dat, &wixFile)
+	if err != nil {
+		return fmt.Errorf("JSON Unmarshal failed with %v", err)
+	}
+	return nil
+}
+
+// LoadStrict loads the manifest like Load,
+// but it fails on unknown fields, type mismatches and unknown values
+// found by checking the manifest against its JSON Schema.
+func (wixFile *WixManifest) LoadStrict(p string) error {
+	check := func(p string, dat []byte) error {
+		problems, err := schema.Check(reflect.TypeOf(*wixFile), dat, schemaEnums())
+		if err != nil {
+			return fmt.Errorf("JSON Unmarshal of %q failed with %v", p, err)
+		}
+		if len(problems) > 0 {
+			return fmt.Errorf("The manifest %q does not match its schema:\n- %v", p, strings.Join(problems, "\n- "))
+		}
+		return nil
+	}
+	dat, err := readExtended(p, check, map[string]bool{})
+	if err != nil {
+		return err
+	}
+	if dat, err = applyProfile(dat, wixFile.Profile); err != nil {
+		return err
+	}
+	err = json.Unmarshal(dat, &wixFile)
+	if err != nil {
+		return fmt.Errorf("JSON Unmarshal failed with %v", err)
+	}
+	if err := wixFile.ExpandEnv(); err != nil {
+		return err
+	}
+	return wixFile.ExpandGlobs()
+}
+
+// Schema returns the JSON Schema describing the wix.json file.
+func Schema() map[string]interface{} {
+	return schema.Generate(reflect.TypeOf(WixManifest{}), "go-msi wix.json manifest", schemaEnums())
+}
+
+// schemaEnums returns the known values of the manifest fields, by path.
+func schemaEnums() schema.Enums {
+	enums := schema.Enums{
+		"env.path[].position": {"first", "last"},
+	}
+	for path, values := range map[string]map[string]bool{
+		"install-scope":                          InstallScopes,
+		"version-policy":                         VersionPolicies,
+		"merge-lists.*":                          ListMerges,
+		"env.vars[].action":                      EnvActions,
+		"env.vars[].part":                        EnvParts,
+		"env.vars[].permanent":                   yesNo,
+		"env.vars[].system":                      yesNo,
+		"env.path[].system":                      yesNo,
+		"firewall.rules[].protocol":              FirewallProtocols,
+		"firewall.rules[].scope":                 FirewallScopes,
+		"firewall.rules[].profile":               FirewallProfiles,
+		"hooks[].when":                           HookPhases,
+		"custom-actions[].when":                  CustomActionPhases,
+		"signing.digest":                         SigningDigests,
+		"upgrade.schedule":                       UpgradeSchedules,
+		"ui.dialogs":                             UIDialogs,
+		"media.compression-level":                CompressionLevels,
+		"launch-conditions[].registry.root":      RegistryRoots,
+		"registry[].root":                        RegistryRoots,
+		"registry[].type":                        RegistryTypes,
+		"services[].start":                       ServiceStarts,
+		"services[].recovery.first":              ServiceRecoveryActions,
+		"services[].recovery.second":             ServiceRecoveryActions,
+		"services[].recovery.subsequent":         ServiceRecoveryActions,
+		"patch.classification":                   PatchClassifications,
+		"bundle.searches[].root":                 BundleSearchRoots,
+		"bundle.prerequisites[].type":            BundlePackageTypes,
+		"performance-counters[].counters[].type": PerfCounterTypes,
+		"com[].classes[].threading-model":        ComThreadingModels,
+	} {
+		for v := range values {
+			enums[path] = append(enums[path], v)
+		}
+		sort.Strings(enums[path])
+	}
+	for a := range Archs {
+		enums["arch"] = append(enums["arch"], a)
+	}
+	sort.Strings(enums["arch"])
+	for path, values := range map[string]map[string]string{
+		"permissions[].rights[]":      PermissionRights,
+		"scheduled-tasks[].trigger":   TaskTriggers,
+		"scheduled-tasks[].run-level": TaskRunLevels,
+		"com[].classes[].server":      ComServers,
+	} {
+		for v := range values {
+			enums[path] = append(enums[path], v)
+		}
+		sort.Strings(enums[path])
+	}
+	for r := range Runtimes {
+		enums["launch-conditions[].runtime"] = append(enums["launch-conditions[].runtime"], r)
+	}
+	sort.Strings(enums["launch-conditions[].runtime"])
+	return enums
+}
+
+// DefaultPaths are the manifest files looked for, in order,
+// when the manifest path is empty, or wix.json which does not exist.
+var DefaultPaths = []string{"wix.json", "wix.yaml", "wix.yml", "wix.toml"}
+
+func defaultPath(p string) string {
+	if p != "" && p != DefaultPaths[0] {
+		return p
+	}
+	for _, d := range DefaultPaths {
+		if _, err := os.Stat(d); err == nil {
+			return d
+		}
+	}
+	return DefaultPaths[0]
+}
+
+// read the manifest file p, as JSON,
+// if p is empty, reads wix.json, or wix.yaml, wix.yml, wix.toml,
+// if p is -, reads stdin.
+// A yaml, yml or toml file is converted to JSON.
+func read(p string) ([]byte, error) {
+	p = defaultPath(p)
+	if p == "-" {
+		dat, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("JSON read from stdin failed with %v", err)
+		}
+		return dat, nil
+	}
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return nil, err
+	}
+	dat, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("JSON ReadFile failed with %v", err)
+	}
+	return toJSON(p, dat)
+}
+
+// toJSON converts dat, the content of the manifest file p, to JSON,
+// according to the extension of p.
+func toJSON(p string, dat []byte) ([]byte, error) {
+	var v interface{}
+	switch strings.ToLower(filepath.Ext(p)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(dat, &v); err != nil {
+			return nil, fmt.Errorf("YAML Unmarshal of %q failed with %v", p, err)
+		}
+	case ".toml":
+		m := map[string]interface{}{}
+		if _, err := toml.Decode(string(dat), &m); err != nil {
+			return nil, fmt.Errorf("TOML Unmarshal of %q failed with %v", p, err)
+		}
+		v = m
+	default:
+		return dat, nil
+	}
+	dat, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("The manifest %q can not be converted to JSON: %v", p, err)
+	}
+	return dat, nil
+}
+
+// fromJSON converts dat, the JSON manifest to write to the file p,
+// to the format of p.
+func fromJSON(p string, dat []byte) ([]byte, error) {
+	ext := strings.ToLower(filepath.Ext(p))
+	if ext != ".yaml" && ext != ".yml" && ext != ".toml" {
+		return dat, nil
+	}
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader(dat))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	v = plainValue(v)
+	if ext == ".toml" {
+		var buf bytes.Buffer
+		err := toml.NewEncoder(&buf).Encode(v)
+		return buf.Bytes(), err
+	}
+	return yaml.Marshal(v)
+}
+
+// plainValue converts the numbers of the decoded JSON v to integers, or floats,
+// and removes its null values and empty objects, TOML has no null.
+func plainValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			return i
+		}
+		f, _ := t.Float64()
+		return f
+	case []interface{}:
+		for i := range t {
+			t[i] = plainValue(t[i])
+		}
+	case map[string]interface{}:
+		for k, e := range t {
+			if e = plainValue(e); e == nil || isEmptyMap(e) {
+				delete(t, k)
+			} else {
+				t[k] = e
+			}
+		}
+	}
+	return v
+}
+
+func isEmptyMap(v interface{}) bool {
+	m, ok := v.(map[string]interface{})
+	return ok && len(m) == 0
+}
+
+// guidKeys are the keys never inherited from an extended manifest.
+var guidKeys = map[string]bool{
+	"upgrade-code":        true,
+	"guid":                true,
+	"desktop-guid":        true,
+	"startup-guid":        true,
+	"program-folder-guid": true,
+}
+
+// concatKeys are the lists concatenated with the lists of an extended manifest,
+// unless merge-lists says otherwise.
+var concatKeys = map[string]bool{
+	"files.items": true,
+	"env.vars":    true,
+	"env.path":    true,
+}
+
+// ListMerges are the values of merge-lists.
+var ListMerges = map[string]bool{
+	"append":  true,
+	"replace": true,
+}
+
+// readExtended reads the manifest file p, passes its data to check,
+// and merges it over the manifest it extends.
+// seen holds the manifests already read, to detect cycles.
+func readExtended(p string, check func(p string, dat []byte) error, seen map[string]bool) ([]byte, error) {
+	p = defaultPath(p)
+	if p != "-" {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		if seen[abs] {
+			return nil, fmt.Errorf("The manifest %q extends itself", p)
+		}
+		seen[abs] = true
+	}
+	dat, err := read(p)
+	if err != nil {
+		return nil, err
+	}
+	if err = check(p, dat); err != nil {
+		return nil, err
+	}
+	var child map[string]interface{}
+	if err = json.Unmarshal(dat, &child); err != nil {
+		return nil, fmt.Errorf("JSON Unmarshal failed with %v", err)
+	}
+	parentPath, _ := child["extends"].(string)
+	if parentPath == "" {
+		return dat, nil
+	}
+	delete(child, "extends")
+	concat := map[string]bool{}
+	for k, v := range concatKeys {
+		concat[k] = v
+	}
+	lists, _ := child["merge-lists"].(map[string]interface{})
+	for k, v := range lists {
+		if s, _ := v.(string); !ListMerges[s] {
+			return nil, fmt.Errorf(`Invalid "merge-lists.%v" value of the manifest %q: %q`, k, p, v)
+		}
+		concat[k] = v == "append"
+	}
+	delete(child, "merge-lists")
+	if !filepath.IsAbs(parentPath) && p != "-" {
+		parentPath = filepath.Join(filepath.Dir(p), parentPath)
+	}
+	parentDat, err := readExtended(parentPath, check, seen)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load %q extended by %q: %v", parentPath, p, err)
+	}
+	var parent map[string]interface{}
+	if err = json.Unmarshal(parentDat, &parent); err != nil {
+		return nil, fmt.Errorf("JSON Unmarshal failed with %v", err)
+	}
+	stripGuids(parent)
+	return json.Marshal(merge(parent, child, "", concat))
+}
+
+// WixProfile holds the manifest fields a profile overrides.
+type WixProfile map[string]interface{}
+
+// applyProfile merges the profiles entry named profile over the manifest dat,
+// lists are replaced, guids and upgrade-code are overridden.
+// The profiles are removed from the returned manifest.
+func applyProfile(dat []byte, profile string) ([]byte, error) {
+	var m map[string]interface{}
+	if err := json.Unmarshal(dat, &m); err != nil {
+		return nil, fmt.Errorf("JSON Unmarshal failed with %v", err)
+	}
+	profiles, _ := m["profiles"].(map[string]interface{})
+	delete(m, "profiles")
+	if profile == "" {
+		return json.Marshal(m)
+	}
+	overrides, ok := profiles[profile].(map[string]interface{})
+	if !ok {
+		names := []string{}
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("Unknown profile %q, the manifest profiles are %v", profile, names)
+	}
+	delete(overrides, "extends")
+	delete(overrides, "profiles")
+	return json.Marshal(merge(m, overrides, "", map[string]bool{}))
+}
+
+// merge returns child merged over parent, path locates them in the manifest,
+// the lists at the concat paths are concatenated.
+func merge(parent, child interface{}, path string, concat map[string]bool) interface{} {
+	switch c := child.(type) {
+	case nil:
+		return parent
+	case string:
+		if c == "" && parent != nil {
+			return parent
+		}
+	case map[string]interface{}:
+		p, ok := parent.(map[string]interface{})
+		if !ok {
+			return child
+		}
+		ret := map[string]interface{}{}
+		for k, v := range p {
+			ret[k] = v
+		}
+		for k, v := range c {
+			ret[k] = merge(p[k], v, strings.TrimPrefix(path+"."+k, "."), concat)
+		}
+		return ret
+	case []interface{}:
+		if p, ok := parent.([]interface{}); ok && concat[path] {
+			return append(append([]interface{}{}, p...), c...)
+		}
+	}
+	return child
+}
+
+// stripGuids removes the guidKeys of v, recursively.
+func stripGuids(v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, e := range t {
+			if guidKeys[k] {
+				delete(t, k)
+			} else {
+				stripGuids(e)
+			}
+		}
+	case []interface{}:
+		for _, e := range t {
+			stripGuids(e)
+		}
+	}
+}
+
+// AdoptGuids copies the guids of from, the manifest loaded with LoadExtended,
+// into wixFile, the same manifest loaded with LoadRaw,
+// so they can be written without the values inherited from the extended manifests.
+func (wixFile *WixManifest) AdoptGuids(from *WixManifest) error {
+	var src, dst interface{}
+	for _, v := range []struct {
+		m   *WixManifest
+		ret *interface{}
+	}{{from, &src}, {wixFile, &dst}} {
+		byt, err := json.Marshal(v.m)
+		if err != nil {
+			return err
+		}
+		if err = json.Unmarshal(byt, v.ret); err != nil {
+			return err
+		}
+	}
+	copyGuids(src, dst)
+	byt, err := json.Marshal(dst)
+	if err != nil {
+		return err
+	}
+	*wixFile = WixManifest{}
+	return json.Unmarshal(byt, wixFile)
+}
+
+// GuidChanges describes the guids of wixFile which differ from the guids of before,
+// the JSON encoding of the manifest before its guids were set,
+// they are located by their path in the manifest, such as "files.guid".
+func (wixFile *WixManifest) GuidChanges(before []byte) ([]string, error) {
+	var src, dst interface{}
+	if err := json.Unmarshal(before, &src); err != nil {
+		return nil, err
+	}
+	byt, err := json.Marshal(wixFile)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(byt, &dst); err != nil {
+		return nil, err
+	}
+	changes := []string{}
+	diffGuids(src, dst, "", &changes)
+	return changes, nil
+}
+
+// diffGuids appends to changes the guidKeys of dst which differ from src, recursively.
+func diffGuids(src, dst interface{}, path string, changes *[]string) {
+	switch d := dst.(type) {
+	case map[string]interface{}:
+		s, _ := src.(map[string]interface{})
+		keys := []string{}
+		for k := range d {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			at := strings.TrimPrefix(path+"."+k, ".")
+			if !guidKeys[k] {
+				diffGuids(s[k], d[k], at, changes)
+				continue
+			}
+			before, _ := s[k].(string)
+			after, _ := d[k].(string)
+			if before != after {
+				*changes = append(*changes, fmt.Sprintf("%v: %q => %q", at, before, after))
+			}
+		}
+	case []interface{}:
+		s, _ := src.([]interface{})
+		for i := range d {
+			var item interface{}
+			if i < len(s) {
+				item = s[i]
+			}
+			diffGuids(item, d[i], fmt.Sprintf("%v[%d]", path, i), changes)
+		}
+	}
+}
+
+// listGuids appends to ret the non empty guidKeys of v, recursively, located by their path.
+func listGuids(v interface{}, path string, ret *[]string) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := []string{}
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			at := strings.TrimPrefix(path+"."+k, ".")
+			if g, _ := t[k].(string); guidKeys[k] && g != "" {
+				*ret = append(*ret, fmt.Sprintf("%v = %q", at, g))
+			} else if !guidKeys[k] {
+				listGuids(t[k], at, ret)
+			}
+		}
+	case []interface{}:
+		for i, e := range t {
+			listGuids(e, fmt.Sprintf("%v[%d]", path, i), ret)
+		}
+	}
+}
+
+// patchGuids sets the non empty guidKeys of src, the decoded JSON manifest, into the YAML node n, recursively,
+// the mappings missing in n are created, the missing sequence items are not, the aliases are left untouched.
+func patchGuids(src interface{}, n *yaml.Node) {
+	switch s := src.(type) {
+	case map[string]interface{}:
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+		keys := []string{}
+		for k := range s {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			var value *yaml.Node
+			for i := 0; i+1 < len(n.Content); i += 2 {
+				if n.Content[i].Value == k {
+					value = n.Content[i+1]
+				}
+			}
+			if guidKeys[k] {
+				g, _ := s[k].(string)
+				if g == "" {
+					continue
+				}
+				if value == nil {
+					value = &yaml.Node{Kind: yaml.ScalarNode}
+					n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, value)
+				}
+				if value.Kind == yaml.ScalarNode {
+					value.Tag = "!!str"
+					value.Value = g
+				}
+				continue
+			}
+			if !hasGuids(s[k]) {
+				continue
+			}
+			if _, ok := s[k].(map[string]interface{}); ok {
+				if value == nil {
+					value = &yaml.Node{}
+					n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, value)
+				}
+				if value.Kind == 0 || value.Tag == "!!null" {
+					*value = yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+				}
+			}
+			if value != nil {
+				patchGuids(s[k], value)
+			}
+		}
+	case []interface{}:
+		if n.Kind != yaml.SequenceNode {
+			return
+		}
+		for i := 0; i < len(s) && i < len(n.Content); i++ {
+			patchGuids(s[i], n.Content[i])
+		}
+	}
+}
+
+// hasGuids tells if v has a non empty guidKeys, recursively.
+func hasGuids(v interface{}) bool {
+	ret := []string{}
+	listGuids(v, "", &ret)
+	return len(ret) > 0
+}
+
+// yamlIndent returns the indentation of the YAML document dat, 2 spaces by default.
+func yamlIndent(dat []byte) int {
+	for _, line := range strings.Split(string(dat), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		if n := len(line) - len(trimmed); n > 0 && trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			return n
+		}
+	}
+	return 2
+}
+
+// copyGuids copies the non empty guidKeys of src into dst, recursively,
+// the objects missing in dst are created, the missing list items are not.
+func copyGuids(src, dst interface{}) {
+	switch s := src.(type) {
+	case map[string]interface{}:
+		d, ok := dst.(map[string]interface{})
+		if !ok {
+			return
+		}
+		for k, v := range s {
+			if guidKeys[k] {
+				if g, _ := v.(string); g != "" {
+					d[k] = g
+				}
+				continue
+			}
+			if _, ok := v.(map[string]interface{}); ok && d[k] == nil {
+				d[k] = map[string]interface{}{}
+			}
+			copyGuids(v, d[k])
+		}
+	case []interface{}:
+		d, _ := dst.([]interface{})
+		for i := 0; i < len(s) && i < len(d); i++ {
+			copyGuids(s[i], d[i])
+		}
+	}
+}
+
+// ManifestVars are the placeholders of the manifest values,
+// ${version}, ${product} and ${company}.
+var ManifestVars = []string{"version", "product", "company"}
+
+func (wixFile *WixManifest) vars() map[string]string {
+	version := wixFile.Version
+	if version == AutoVersion {
+		version = ""
+	}
+	return map[string]string{
+		"version": version,
+		"product": wixFile.Product,
+		"company": wixFile.Company,
+	}
+}
+
+// ExpandEnv expands, in every string value of the manifest,
+// the ${env:VAR}, ${VAR} and $VAR references to environment variables,
+// the ${version}, ${product} and ${company} placeholders are left to ExpandVars,
+// the flags can still change their values.
+// It fails if a referenced variable is not set.
+func (wixFile *WixManifest) ExpandEnv() error {
+	vars := map[string]string{}
+	for _, name := range ManifestVars {
+		vars[name] = ""
+	}
+	var err error
+	if wixFile.Product, err = expandValue(wixFile.Product, vars, false); err != nil {
+		return fmt.Errorf(`Failed to expand "product": %v`, err)
+	}
+	if wixFile.Company, err = expandValue(wixFile.Company, vars, false); err != nil {
+		return fmt.Errorf(`Failed to expand "company": %v`, err)
+	}
+	if wixFile.Version, err = expandValue(wixFile.Version, vars, false); err != nil {
+		return fmt.Errorf(`Failed to expand "version": %v`, err)
+	}
+	return walkStrings(reflect.ValueOf(wixFile).Elem(), "", func(path, s string) (string, error) {
+		ret, err := expandValue(s, vars, false)
+		if err != nil {
+			return "", fmt.Errorf("Failed to expand %q: %v", path, err)
+		}
+		return ret, nil
+	})
+}
+
+// ExpandVars expands the ${version}, ${product} and ${company} placeholders
+// left by ExpandEnv, and the escaped $$, in every string value of the manifest.
+// It is called by Normalize, once the version is known.
+func (wixFile *WixManifest) ExpandVars() error {
+	vars := wixFile.vars()
+	return walkStrings(reflect.ValueOf(wixFile).Elem(), "", func(path, s string) (string, error) {
+		ret, err := expandValue(s, vars, true)
+		if err != nil {
+			return "", fmt.Errorf("Failed to expand %q: %v", path, err)
+		}
+		return ret, nil
+	})
+}
+
+// expandValue expands the references of s to the vars, when they are set,
+// and to environment variables.
+// The unset vars are kept as ${name}, $$ is kept unless unescape is true.
+func expandValue(s string, vars map[string]string, unescape bool) (string, error) {
+	missing := []string{}
+	ret := os.Expand(s, func(name string) string {
+		if name == "$" {
+			if unescape {
+				return "$"
+			}
+			return "$$"
+		}
+		if v, ok := vars[name]; ok {
+			if v == "" {
+				return "${" + name + "}"
+			}
+			return v
+		}
+		name = strings.TrimPrefix(name, "env:")
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %v is not set", strings.Join(missing, ", "))
+	}
+	return ret, nil
+}
+
+// walkStrings replaces the string values of v by the result of fn,
+// they are located by their path in the manifest, such as "files.items[0]".
+// The fields not read from the manifest are skipped.
+func walkStrings(v reflect.Value, path string, fn func(path, s string) (string, error)) error {
+	switch v.Kind() {
+	case reflect.String:
+		s, err := fn(path, v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return walkStrings(v.Elem(), path, fn)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if err := walkStrings(v.Index(i), fmt.Sprintf("%v[%d]", path, i), fn); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		keys := []string{}
+		for _, k := range v.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			key := reflect.ValueOf(k)
+			e := reflect.New(v.Type().Elem()).Elem()
+			e.Set(v.MapIndex(key))
+			if err := walkStrings(e, path+"."+k, fn); err != nil {
+				return err
+			}
+			v.SetMapIndex(key, e)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+			if name == "-" || name == "" {
+				continue
+			}
+			if path != "" {
+				name = path + "." + name
+			}
+			if err := walkStrings(v.Field(i), name, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ExpandGlobs replaces the glob patterns of Files.Items and FileGroups items
+// by the files they match, in lexical order, then removes their Exclude matches.
+// The ${version}, ${product} and ${company} placeholders of the patterns are expanded first,
+// with the values the manifest sets.
+// It fails if a pattern matches no file.
+func (wixFile *WixManifest) ExpandGlobs() error {
+	var err error
+	vars := wixFile.vars()
+	if wixFile.Files.Items, err = expandGlobs(wixFile.Files, "files", vars); err != nil {
+		return err
+	}
+	for g := range wixFile.FileGroups {
+		where := fmt.Sprintf("file-groups[%d]", g)
+		if wixFile.FileGroups[g].Items, err = expandGlobs(wixFile.FileGroups[g], where, vars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func expandGlobs(files WixFiles, where string, vars map[string]string) ([]string, error) {
+	if files.Items == nil {
+		return nil, nil
+	}
+	ret := []string{}
+	seen := map[string]bool{}
+	for i, item := range files.Items {
+		matches := []string{item}
+		if strings.ContainsAny(item, "*?[") {
+			pattern, err := expandValue(item, vars, true)
+			if err != nil {
+				return nil, fmt.Errorf(`Failed to expand "%v.items[%d]": %v`, where, i, err)
+			}
+			if matches, err = glob(pattern); err != nil {
+				return nil, fmt.Errorf(`Invalid pattern in "%v.items[%d]": %v`, where, i, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf(`The pattern of "%v.items[%d]" matches no file: %q`, where, i, pattern)
+			}
+		}
+		for _, m := range matches {
+			if !seen[m] && !excluded(m, files.Exclude) {
+				ret = append(ret, m)
+			}
+			seen[m] = true
+		}
+	}
+	return ret, nil
+}
+
+// excluded tells if the path p, or its file name, matches one of the patterns.
+func excluded(p string, patterns []string) bool {
+	segs := strings.Split(filepath.ToSlash(filepath.Clean(p)), "/")
+	for _, pattern := range patterns {
+		psegs := strings.Split(filepath.ToSlash(filepath.Clean(pattern)), "/")
+		if ok, _ := matchSegments(psegs, segs); ok {
+			return true
+		}
+		if len(psegs) == 1 {
+			if ok, _ := filepath.Match(pattern, segs[len(segs)-1]); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// glob returns the files matching pattern, ** matches any number of directories.
+func glob(pattern string) ([]string, error) {
+	psegs := strings.Split(filepath.ToSlash(filepath.Clean(pattern)), "/")
+	root := []string{}
+	for _, seg := range psegs {
+		if strings.ContainsAny(seg, "*?[") {
+			break
+		}
+		root = append(root, seg)
+	}
+	base := strings.Join(root, "/")
+	if base == "" && strings.HasPrefix(filepath.ToSlash(pattern), "/") {
+		base = "/"
+	} else if base == "" {
+		base = "."
+	}
+	ret := []string{}
+	err := filepath.Walk(filepath.FromSlash(base), func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && p == filepath.FromSlash(base) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		ok, err := matchSegments(psegs, strings.Split(filepath.ToSlash(filepath.Clean(p)), "/"))
+		if ok {
+			ret = append(ret, p)
+		}
+		return err
+	})
+	return ret, err
+}
+
+// matchSegments tells if the path segments match the pattern segments.
+func matchSegments(pattern, segs []string) (bool, error) {
+	if len(pattern) == 0 {
+		return len(segs) == 0, nil
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(segs); i++ {
+			if ok, err := matchSegments(pattern[1:], segs[i:]); ok || err != nil {
+				return ok, err
+			}
+		}
+		return false, nil
+	}
+	if len(segs) == 0 {
+		return false, nil
+	}
+	ok, err := filepath.Match(pattern[0], segs[0])
+	if !ok || err != nil {
+		return false, err
+	}
+	return matchSegments(pattern[1:], segs[1:])
+}
+
+// checkGuids ensures non empty guid values are canonical guids,
+// empty values are allowed, SetGuids fills them in.
+func (wixFile *WixManifest) checkGuids() []string {
+	problems := []string{}
+	guids := []struct {
+		field string
+		value string
+	}{
+		{"upgrade-code", wixFile.UpgradeCode},
+		{"product-code", wixFile.ProductCode},
+		{"files.guid", wixFile.Files.GUID},
+		{"env.guid", wixFile.Env.GUID},
+		{"shortcuts.guid", wixFile.Shortcuts.GUID},
+		{"shortcuts.desktop-guid", wixFile.Shortcuts.DesktopGUID},
+		{"shortcuts.startup-guid", wixFile.Shortcuts.StartupGUID},
+		{"shortcuts.program-folder-guid", wixFile.Shortcuts.ProgramFolderGUID},
+		{"firewall.guid", wixFile.Firewall.GUID},
+		{"cleanup.guid", wixFile.Cleanup.GUID},
+		{"bundle.upgrade-code", wixFile.Bundle.UpgradeCode},
+	}
+	for g, group := range wixFile.FileGroups {
+		guids = append(guids, struct {
+			field string
+			value string
+		}{fmt.Sprintf("file-groups[%d].guid", g), group.GUID})
+	}
+	for _, g := range guids {
+		if g.value == "" {
+			continue
+		}
+		// FromString also accepts braced and urn forms, wix does not.
+		if _, err := uuid.FromString(g.value); err != nil || len(g.value) != 36 {
+			problems = append(problems, fmt.Sprintf(`Invalid guid value for %q: %q, expected the form XXXXXXXX-XXXX-XXXX-XXXX-XXXXXXXXXXXX, empty it and run go-msi set-guid to generate one`, g.field, g.value))
+		}
+	}
+	return problems
+}
+
+//SetGuids generates and apply guid values appropriately
+func (wixFile *WixManifest) SetGuids(force bool) (bool, error) {
+	return wixFile.setGuids(force, func(string) string {
+		return uuid.NewV4().String()
+	})
+}
+
+// goMsiNamespace is the namespace of the guids derived by SetStableGuids.
+var goMsiNamespace = uuid.NewV5(uuid.NamespaceURL, "https://github.com/mh-cbon/go-msi")
+
+// SetStableGuids generates and apply guid values appropriately,
+// like SetGuids, but values are derived (uuid v5) from stable inputs,
+// so the same manifest always yields the same guids.
+// The upgrade code is derived from the company and product names,
+// component guids are derived from the upgrade code and their install location.
+func (wixFile *WixManifest) SetStableGuids(force bool) (bool, error) {
+	if wixFile.Product == "" || wixFile.Company == "" {
+		return false, fmt.Errorf("product and company must be set to derive stable guids")
+	}
+	return wixFile.setGuids(force, func(key string) string {
+		if key == "upgrade-code" {
+			return uuid.NewV5(goMsiNamespace, wixFile.Company+"/"+wixFile.Product).String()
+		}
+		ns, err := uuid.FromString(wixFile.UpgradeCode)
+		if err != nil {
+			ns = goMsiNamespace
+		}
+		return uuid.NewV5(ns, key).String()
+	})
+}
+
+// setGuids applies guid values produced by gen,
+// gen receives a key identifying the component the guid is for.
+func (wixFile *WixManifest) setGuids(force bool, gen func(key string) string) (bool, error) {
+	updated := false
+	if wixFile.UpgradeCode == "" || force {
+		wixFile.UpgradeCode = gen("upgrade-code")
+		updated = true
+	}
+	if (wixFile.Files.GUID == "" || force) && !wixFile.Files.PerFile {
+		wixFile.Files.GUID = gen("[INSTALLDIR]")
+		updated = true
+	}
+	for g, group := range wixFile.FileGroups {
+		if (group.GUID == "" || force) && !group.PerFile {
+			wixFile.FileGroups[g].GUID = gen("[INSTALLDIR]" + filepath.ToSlash(filepath.Clean(group.Dir)))
+			updated = true
+		}
+	}
+	if (wixFile.Env.GUID == "" || force) && !wixFile.Env.Empty() {
+		wixFile.Env.GUID = gen("[ENVS]")
+		updated = true
+	}
+	if (wixFile.Shortcuts.GUID == "" || force) && wixFile.Shortcuts.HasStartMenu() {
+		wixFile.Shortcuts.GUID = gen("[ProgramMenuFolder]" + wixFile.Product)
+		updated = true
+	}
+	if (wixFile.Shortcuts.DesktopGUID == "" || force) && wixFile.Shortcuts.HasDesktop() {
+		wixFile.Shortcuts.DesktopGUID = gen("[DesktopFolder]" + wixFile.Product)
+		updated = true
+	}
+	if (wixFile.Shortcuts.StartupGUID == "" || force) && wixFile.Shortcuts.HasStartup() {
+		wixFile.Shortcuts.StartupGUID = gen("[StartupFolder]" + wixFile.Product)
+		updated = true
+	}
+	if (wixFile.Shortcuts.ProgramFolderGUID == "" || force) && wixFile.Shortcuts.HasProgramFolder() {
+		wixFile.Shortcuts.ProgramFolderGUID = gen("[INSTALLDIR]" + wixFile.Product + ".lnk")
+		updated = true
+	}
+	if (wixFile.Firewall.GUID == "" || force) && len(wixFile.Firewall.Rules) > 0 {
+		wixFile.Firewall.GUID = gen("[FIREWALL]")
+		updated = true
+	}
+	if (wixFile.Cleanup.GUID == "" || force) && len(wixFile.Cleanup.Items) > 0 {
+		wixFile.Cleanup.GUID = gen("[CLEANUP]")
+		updated = true
+	}
+	return updated, nil
+}
+
+// Validate checks the manifest values are consistent,
+// it returns an error describing every problem found.
+func (wixFile *WixManifest) Validate() error {
+	return invalidManifest(wixFile.problems())
+}
+
+// Check validates the manifest like Validate, it also checks the files it references exist,
+// and the shortcuts targeting the install directory target an installed file.
+// choco tells to check the fields go-msi choco needs.
+// The paths are relative to the current directory, as for go-msi make.
+func (wixFile *WixManifest) Check(choco bool) error {
+	return invalidManifest(append(wixFile.problems(), wixFile.referenceProblems(choco)...))
+}
+
+func invalidManifest(problems []string) error {
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid manifest:\n- %v", strings.Join(problems, "\n- "))
+	}
+	return nil
+}
+
+// problems returns the inconsistencies of the manifest values.
+func (wixFile *WixManifest) problems() []string {
+	problems := wixFile.checkGuids()
+	if _, err := semver.NewVersion(wixFile.Version); wixFile.Version != "" && wixFile.Version != AutoVersion && err != nil {
+		problems = append(problems, fmt.Sprintf(`Invalid "version" value: %q, expected a semver version such as 1.2.3`, wixFile.Version))
+	}
+	if strings.TrimSpace(wixFile.Product) == "" {
+		problems = append(problems, `"product" must not be empty`)
+	}
+	if strings.TrimSpace(wixFile.Company) == "" {
+		problems = append(problems, `"company" must not be empty`)
+	}
+	for i, file := range wixFile.Files.Items {
+		if strings.TrimSpace(file) == "" {
+			problems = append(problems, fmt.Sprintf(`"files.items[%d]" must not be empty`, i))
+		}
+	}
+	for g, group := range wixFile.FileGroups {
+		dir := filepath.ToSlash(filepath.Clean(group.Dir))
+		if group.Dir == "" || filepath.IsAbs(group.Dir) || dir == ".." || strings.HasPrefix(dir, "../") {
+			problems = append(problems, fmt.Sprintf(`"file-groups[%d].dir" must be a sub directory of the install directory: %q`, g, group.Dir))
+		}
+		for i, file := range group.Items {
+			if strings.TrimSpace(file) == "" {
+				problems = append(problems, fmt.Sprintf(`"file-groups[%d].items[%d]" must not be empty`, g, i))
+			}
+		}
+	}
+	for i, dir := range wixFile.Directories {
+		if strings.TrimSpace(dir) == "" {
+			problems = append(problems, fmt.Sprintf(`"directories[%d]" must not be empty`, i))
+		}
+	}
+	for i, pattern := range wixFile.Harvest.Include {
+		if _, err := filepath.Match(pattern, ""); err != nil || strings.TrimSpace(pattern) == "" {
+			problems = append(problems, fmt.Sprintf(`Invalid "harvest.include[%d]" pattern: %q`, i, pattern))
+		}
+	}
+	for i, pattern := range wixFile.Harvest.Exclude {
+		if _, err := filepath.Match(pattern, ""); err != nil || strings.TrimSpace(pattern) == "" {
+			problems = append(problems, fmt.Sprintf(`Invalid "harvest.exclude[%d]" pattern: %q`, i, pattern))
+		}
+	}
+	for i, env := range wixFile.Env.Vars {
+		if strings.TrimSpace(env.Name) == "" {
+			problems = append(problems, fmt.Sprintf(`"env.vars[%d].name" must not be empty`, i))
+		}
+		if _, ok := EnvActions[env.Action]; env.Action != "" && !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "action" value in "env.vars[%d]": %q, expected set, create or remove`, i, env.Action))
+		}
+		if _, ok := EnvParts[env.Part]; env.Part != "" && !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "part" value in "env.vars[%d]": %q, expected all, first or last`, i, env.Part))
+		}
+		if _, ok := yesNo[env.Permanent]; env.Permanent != "" && !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "permanent" value in "env.vars[%d]": %q, expected yes or no`, i, env.Permanent))
+		}
+		if _, ok := yesNo[env.System]; env.System != "" && !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "system" value in "env.vars[%d]": %q, expected yes or no`, i, env.System))
+		}
+		if strings.EqualFold(env.Name, "PATH") && (env.Part == "" || env.Part == "all") && env.Action != "remove" {
+			problems = append(problems, fmt.Sprintf(`"env.vars[%d]" must set "part" to first or last, otherwise PATH is replaced, then removed on uninstall`, i))
+		}
+		if env.Permanent == "yes" && (env.Part == "first" || env.Part == "last") {
+			problems = append(problems, fmt.Sprintf(`"env.vars[%d]" must not be permanent when "part" is %q, the segment would be duplicated by each reinstall`, i, env.Part))
+		}
+	}
+	for i, p := range wixFile.Env.Path {
+		if p.Position != "" && p.Position != "first" && p.Position != "last" {
+			problems = append(problems, fmt.Sprintf(`Invalid "position" value in "env.path[%d]": %q, expected first or last`, i, p.Position))
+		}
+		if _, ok := yesNo[p.System]; p.System != "" && !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "system" value in "env.path[%d]": %q, expected yes or no`, i, p.System))
+		}
+		if strings.Contains(p.Dir, ";") {
+			problems = append(problems, fmt.Sprintf(`"env.path[%d].dir" must not contain ;`, i))
+		}
+	}
+	for i, s := range wixFile.Shortcuts.Items {
+		if strings.TrimSpace(s.Name) == "" {
+			problems = append(problems, fmt.Sprintf(`"shortcuts.items[%d].name" must not be empty`, i))
+		}
+		if strings.TrimSpace(s.Target) == "" {
+			problems = append(problems, fmt.Sprintf(`"shortcuts.items[%d].target" must not be empty`, i))
+		}
+		if _, ok := shortcutLocation(s.Location); !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "location" value in "shortcuts.items[%d]": %q, expected startMenu, desktop, startup or programFolder`, i, s.Location))
+		}
+	}
+	if strings.ContainsAny(wixFile.Shortcuts.StartMenuFolder, `/\:*?"<>|`) {
+		problems = append(problems, fmt.Sprintf(`Invalid "shortcuts.start-menu-folder" value: %q`, wixFile.Shortcuts.StartMenuFolder))
+	}
+	for i, r := range wixFile.Firewall.Rules {
+		if strings.TrimSpace(r.Name) == "" {
+			problems = append(problems, fmt.Sprintf(`"firewall.rules[%d].name" must not be empty`, i))
+		}
+		if r.Program == "" && r.Port == "" {
+			problems = append(problems, fmt.Sprintf(`"firewall.rules[%d]" must define a "program" or a "port"`, i))
+		}
+		if _, ok := FirewallProtocols[r.Protocol]; r.Protocol != "" && !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "protocol" value in "firewall.rules[%d]": %q`, i, r.Protocol))
+		}
+		if _, ok := FirewallScopes[r.Scope]; r.Scope != "" && !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "scope" value in "firewall.rules[%d]": %q`, i, r.Scope))
+		}
+		if _, ok := FirewallProfiles[r.Profile]; r.Profile != "" && !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "profile" value in "firewall.rules[%d]": %q`, i, r.Profile))
+		}
+		if r.Scope != "" && len(r.RemoteAddresses) > 0 {
+			problems = append(problems, fmt.Sprintf(`"firewall.rules[%d]" can not define both a "scope" and "remote-addresses"`, i))
+		}
+		for k, a := range r.RemoteAddresses {
+			if strings.TrimSpace(a) == "" {
+				problems = append(problems, fmt.Sprintf(`"firewall.rules[%d].remote-addresses[%d]" must not be empty`, i, k))
+			}
+		}
+	}
+	for i, item := range wixFile.Cleanup.Items {
+		p := filepath.ToSlash(item)
+		dir := p
+		if !strings.HasSuffix(p, "/") {
+			dir = p[:strings.LastIndex("/"+p, "/")]
+		}
+		if strings.Trim(p, "/") == "" || filepath.IsAbs(item) || strings.HasPrefix(p, "/") {
+			problems = append(problems, fmt.Sprintf(`"cleanup.items[%d]" must be a pattern relative to the install directory: %q`, i, item))
+		} else if strings.Contains("/"+dir, "/./") || strings.Contains("/"+dir, "/../") || strings.Contains(dir, "//") {
+			problems = append(problems, fmt.Sprintf(`"cleanup.items[%d]" must not contain empty, "." or ".." directories: %q`, i, item))
+		} else if strings.ContainsAny(dir, "*?") {
+			problems = append(problems, fmt.Sprintf(`"cleanup.items[%d]" can only have wildcards in its last path segment: %q`, i, item))
+		}
+	}
+	for i, a := range wixFile.CustomActions {
+		if strings.TrimSpace(a.File) == "" {
+			problems = append(problems, fmt.Sprintf(`"custom-actions[%d].file" must not be empty`, i))
+		}
+		if _, ok := CustomActionPhases[a.When]; !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "when" value in "custom-actions[%d]": %q`, i, a.When))
+		}
+	}
+	for i, hook := range wixFile.Hooks {
+		if _, ok := HookPhases[hook.When]; !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "when" value in "hooks[%d]": %q`, i, hook.When))
+		}
+		if strings.TrimSpace(hook.Command) == "" {
+			problems = append(problems, fmt.Sprintf(`"hooks[%d].command" must not be empty`, i))
+		}
+	}
+	features := map[string]bool{}
+	walkFeatures(wixFile.Features, func(f *WixFeature) {
+		if !featureIDRe.MatchString(f.ID) || f.ID == "DefaultFeature" {
+			problems = append(problems, fmt.Sprintf(`Invalid feature "id" value: %q, expected at most 38 letters, digits, _ or . starting with a letter or _`, f.ID))
+		} else if features[f.ID] {
+			problems = append(problems, fmt.Sprintf(`Duplicate feature id: %q`, f.ID))
+		}
+		features[f.ID] = true
+		if strings.TrimSpace(f.Title) == "" {
+			problems = append(problems, fmt.Sprintf(`The title of the feature %q must not be empty`, f.ID))
+		}
+		if f.Default != "" && f.Default != "install" && f.Default != "absent" {
+			problems = append(problems, fmt.Sprintf(`Invalid "default" value of the feature %q: %q, expected install or absent`, f.ID, f.Default))
+		}
+		if f.Level < 0 || (f.Default == "absent" && f.Level == 1) {
+			problems = append(problems, fmt.Sprintf(`Invalid "level" value of the feature %q: %d`, f.ID, f.Level))
+		}
+		if _, ok := FeatureDisplays[f.Display]; f.Display != "" && !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "display" value of the feature %q: %q, expected collapse, expand or hidden`, f.ID, f.Display))
+		}
+	})
+	for _, item := range wixFile.featureItems() {
+		if item.field == "files" {
+			problems = append(problems, `"files.feature" can not be set, files.items belong to the main feature, use a file group instead`)
+		} else if !features[item.feature] {
+			problems = append(problems, fmt.Sprintf(`"%v.feature" is not a feature id: %q`, item.field, item.feature))
+		}
+	}
+	if _, ok := UIDialogs[wixFile.UI.Dialogs]; wixFile.UI.Dialogs != "" && !ok {
+		problems = append(problems, fmt.Sprintf(`Invalid "ui.dialogs" value: %q, expected installDir, minimal, featureTree or none`, wixFile.UI.Dialogs))
+	}
+	if wixFile.UI.Dialogs == "minimal" && wixFile.UI.LicenseDialog != nil && !*wixFile.UI.LicenseDialog {
+		problems = append(problems, `"ui.license-dialog" can not be false when "ui.dialogs" is minimal, its first dialog shows the license`)
+	}
+	if l := wixFile.UI.Launch; l != nil {
+		if (l.File == "") == (l.URL == "") {
+			problems = append(problems, `"ui.launch" must set one of "file" or "url"`)
+		}
+		if wixFile.UI.Dialogs == "none" {
+			problems = append(problems, `"ui.launch" needs the exit dialog, "ui.dialogs" can not be none`)
+		}
+		if wixFile.Module {
+			problems = append(problems, `"ui.launch" can not be set for a merge module`)
+		}
+	}
+	for _, b := range []struct{ key, value string }{{"banner", wixFile.UI.Banner}, {"background", wixFile.UI.Background}} {
+		if b.value != "" && strings.ToLower(filepath.Ext(b.value)) != ".bmp" {
+			problems = append(problems, fmt.Sprintf(`"ui.%v" must be a bmp file: %q`, b.key, b.value))
+		}
+	}
+	problems = append(problems, wixFile.Signing.problems()...)
+	if wixFile.ARP.EstimatedSize < 0 {
+		problems = append(problems, `"arp.estimated-size" must not be negative`)
+	}
+	if _, ok := UpgradeSchedules[wixFile.Upgrade.Schedule]; wixFile.Upgrade.Schedule != "" && !ok {
+		problems = append(problems, fmt.Sprintf(`Invalid "upgrade.schedule" value: %q`, wixFile.Upgrade.Schedule))
+	}
+	if wixFile.Upgrade.AllowDowngrades && wixFile.Upgrade.DowngradeErrorMessage != "" {
+		problems = append(problems, `"upgrade.downgrade-error-message" can not be set when "upgrade.allow-downgrades" is true`)
+	}
+	if !wixFile.Upgrade.Disallow && wixFile.Upgrade.DisallowErrorMessage != "" {
+		problems = append(problems, `"upgrade.disallow-error-message" can only be set when "upgrade.disallow" is true`)
+	}
+	for i, c := range wixFile.Conditions {
+		if strings.TrimSpace(c.Message) == "" {
+			problems = append(problems, fmt.Sprintf(`"launch-conditions[%d].message" must not be empty`, i))
+		}
+		if strings.TrimSpace(c.Condition) == "" && c.MinWindowsBuild == 0 && c.Registry == nil &&
+			c.File == "" && c.Runtime == "" && c.MinDiskSpace == 0 && c.Conflict == "" {
+			problems = append(problems, fmt.Sprintf(`"launch-conditions[%d]" must have a "condition", a "min-windows-build", a "registry", a "file", a "runtime", a "min-disk-space" or a "conflict"`, i))
+		}
+		if c.MinWindowsBuild < 0 {
+			problems = append(problems, fmt.Sprintf(`"launch-conditions[%d].min-windows-build" must not be negative`, i))
+		}
+		if c.MinDiskSpace < 0 {
+			problems = append(problems, fmt.Sprintf(`"launch-conditions[%d].min-disk-space" must not be negative`, i))
+		}
+		if k := strings.LastIndexAny(c.File, `\/]`); c.File != "" && (k <= 0 || k == len(c.File)-1) {
+			problems = append(problems, fmt.Sprintf(`Invalid "file" value in "launch-conditions[%d]": %q, expected the full path of a file`, i, c.File))
+		}
+		if _, ok := Runtimes[c.Runtime]; c.Runtime != "" && !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "runtime" value in "launch-conditions[%d]": %q`, i, c.Runtime))
+		}
+		if c.Conflict != "" {
+			if _, err := uuid.FromString(c.Conflict); err != nil {
+				problems = append(problems, fmt.Sprintf(`Invalid "conflict" value in "launch-conditions[%d]": %q, expected an upgrade code`, i, c.Conflict))
+			} else if strings.EqualFold(c.Conflict, wixFile.UpgradeCode) {
+				problems = append(problems, fmt.Sprintf(`"launch-conditions[%d].conflict" can not be the "upgrade-code" of the product`, i))
+			}
+		}
+		if c.Registry != nil {
+			if _, ok := RegistryRoots[c.Registry.Root]; !ok {
+				problems = append(problems, fmt.Sprintf(`Invalid "root" value in "launch-conditions[%d].registry": %q`, i, c.Registry.Root))
+			}
+			if strings.TrimSpace(c.Registry.Key) == "" {
+				problems = append(problems, fmt.Sprintf(`"launch-conditions[%d].registry.key" must not be empty`, i))
+			}
+		}
+	}
+	if _, ok := Archs[wixFile.Arch]; wixFile.Arch != "" && !ok {
+		problems = append(problems, fmt.Sprintf(`Invalid "arch" value: %q`, wixFile.Arch))
+	}
+	if _, ok := InstallScopes[wixFile.InstallScope]; wixFile.InstallScope != "" && !ok {
+		problems = append(problems, fmt.Sprintf(`Invalid "install-scope" value: %q`, wixFile.InstallScope))
+	}
+	if _, ok := VersionPolicies[wixFile.VersionPolicy]; wixFile.VersionPolicy != "" && !ok {
+		problems = append(problems, fmt.Sprintf(`Invalid "version-policy" value: %q`, wixFile.VersionPolicy))
+	}
+	for i, ice := range wixFile.Validation.Suppress {
+		if !iceRe.MatchString(ice) {
+			problems = append(problems, fmt.Sprintf(`Invalid "validation.suppress[%d]" value: %q, expected an ICE such as ICE61`, i, ice))
+		}
+	}
+	if _, ok := CompressionLevels[wixFile.Media.CompressionLevel]; wixFile.Media.CompressionLevel != "" && !ok {
+		problems = append(problems, fmt.Sprintf(`Invalid "media.compression-level" value: %q`, wixFile.Media.CompressionLevel))
+	}
+	if wixFile.Media.MaxUncompressedSize < 0 {
+		problems = append(problems, `"media.max-uncompressed-size" must be positive`)
+	}
+	if wixFile.Media.MaxCabSize != 0 && (wixFile.Media.MaxCabSize < 20 || wixFile.Media.MaxCabSize > 2048) {
+		problems = append(problems, fmt.Sprintf(`Invalid "media.max-cab-size" value: %d, expected a size between 20 and 2048 MB`, wixFile.Media.MaxCabSize))
+	}
+	if wixFile.Media.Split && !strings.Contains(wixFile.Media.Cabinet, "{0}") {
+		problems = append(problems, fmt.Sprintf(`Invalid "media.cabinet" value: %q, the names of split cabs must contain {0}, such as product{0}.cab`, wixFile.Media.Cabinet))
+	}
+	for host, build := range wixFile.PathMap {
+		if host == "" || build == "" {
+			problems = append(problems, fmt.Sprintf(`Invalid "path-map" entry: %q to %q, expected a host path prefix and its path in the build environment`, host, build))
+		}
+	}
+	configs := map[string]int{}
+	for i, c := range wixFile.Configs {
+		if c.Source == "" {
+			problems = append(problems, fmt.Sprintf(`"configs[%d].source" must not be empty`, i))
+		}
+		if strings.ContainsAny(c.Name, `/\`) {
+			problems = append(problems, fmt.Sprintf(`Invalid "configs[%d].name" value: %q, expected a file name, set the directory with "dir"`, i, c.Name))
+		}
+		for _, seg := range strings.Split(filepath.ToSlash(c.Dir), "/") {
+			if seg == ".." || filepath.IsAbs(c.Dir) {
+				problems = append(problems, fmt.Sprintf(`Invalid "configs[%d].dir" value: %q, expected a path relative to the install directory`, i, c.Dir))
+				break
+			}
+		}
+		name := c.Name
+		if name == "" {
+			name = strings.TrimSuffix(filepath.Base(c.Source), ".tmpl")
+		}
+		target := strings.ToLower(strings.TrimPrefix(permissionPath(c.Dir)+"/"+name, "/"))
+		if k, ok := configs[target]; ok {
+			problems = append(problems, fmt.Sprintf(`"configs[%d]" and "configs[%d]" install the same file: %q`, k, i, target))
+		}
+		configs[target] = i
+	}
+	if s := wixFile.Upgrade.Schedule; wixFile.removesConfigs() && (s == "afterInstallValidate" || s == "afterInstallInitialize") {
+		problems = append(problems, fmt.Sprintf(`"upgrade.schedule" %q removes the configs on upgrade, use afterInstallExecute, or set "remove-on-uninstall" of the configs to false`, s))
+	}
+	for i, p := range wixFile.Permissions {
+		if strings.TrimSpace(p.User) == "" {
+			problems = append(problems, fmt.Sprintf(`"permissions[%d].user" must not be empty`, i))
+		}
+		if len(p.Rights) == 0 {
+			problems = append(problems, fmt.Sprintf(`"permissions[%d].rights" must not be empty`, i))
+		}
+		for _, r := range p.Rights {
+			if _, ok := PermissionRights[r]; !ok {
+				problems = append(problems, fmt.Sprintf(`Invalid "permissions[%d].rights" value: %q, expected read, write, execute, delete or all`, i, r))
+			}
+		}
+		for _, seg := range strings.Split(filepath.ToSlash(p.Path), "/") {
+			if seg == ".." || filepath.IsAbs(p.Path) {
+				problems = append(problems, fmt.Sprintf(`Invalid "permissions[%d].path" value: %q, expected a path relative to the install directory`, i, p.Path))
+				break
+			}
+		}
+	}
+	if wixFile.InstallDir.Root != "" && !installRootRe.MatchString(wixFile.InstallDir.Root) {
+		problems = append(problems, fmt.Sprintf(`Invalid "install-dir.root" value: %q, expected an absolute path such as C:\Tools`, wixFile.InstallDir.Root))
+	}
+	if wixFile.Module && (wixFile.InstallDir.Root != "" || wixFile.InstallDir.Remember) {
+		problems = append(problems, `"install-dir.root" and "install-dir.remember" are not supported by merge modules`)
+	}
+	if wixFile.InstallDir.Name != "" && len(splitWinPath(wixFile.InstallDir.Name)) == 0 {
+		problems = append(problems, fmt.Sprintf(`Invalid "install-dir.name" value: %q`, wixFile.InstallDir.Name))
+	}
+	for _, d := range splitWinPath(wixFile.InstallDir.Name) {
+		if d == "." || d == ".." || strings.ContainsAny(d, `:*?"<>|`) {
+			problems = append(problems, fmt.Sprintf(`Invalid "install-dir.name" value: %q`, wixFile.InstallDir.Name))
+			break
+		}
+	}
+	if wixFile.InstallScope == "perUser" {
+		// a per user install runs without elevation
+		for i, env := range wixFile.Env.Vars {
+			if env.System == "yes" {
+				problems = append(problems, fmt.Sprintf(`"env.vars[%d]" can not be a system variable of a perUser install`, i))
+			}
+		}
+		for i, p := range wixFile.Env.Path {
+			if p.System == "yes" {
+				problems = append(problems, fmt.Sprintf(`"env.path[%d]" can not edit the system PATH of a perUser install`, i))
+			}
+		}
+		if len(wixFile.Services) > 0 {
+			problems = append(problems, `"services" can not be installed by a perUser install`)
+		}
+		if len(wixFile.ScheduledTasks) > 0 {
+			problems = append(problems, `"scheduled-tasks" can not be created by a perUser install`)
+		}
+		if len(wixFile.Drivers) > 0 {
+			problems = append(problems, `"drivers" can not be installed by a perUser install`)
+		}
+		if len(wixFile.EventSources) > 0 || len(wixFile.PerfCategories) > 0 {
+			problems = append(problems, `"event-sources" and "performance-counters" can not be registered by a perUser install`)
+		}
+		if len(wixFile.Firewall.Rules) > 0 {
+			problems = append(problems, `"firewall" rules can not be installed by a perUser install`)
+		}
+		for i, r := range wixFile.Registry {
+			if r.Root == "HKLM" || r.Root == "HKU" {
+				problems = append(problems, fmt.Sprintf(`"registry[%d]" can not write to %v in a perUser install`, i, r.Root))
+			}
+		}
+	}
+	services := map[string]bool{}
+	for i, svc := range wixFile.Services {
+		if strings.TrimSpace(svc.Name) == "" {
+			problems = append(problems, fmt.Sprintf(`"services[%d].name" must not be empty`, i))
+		} else if services[strings.ToLower(svc.Name)] {
+			problems = append(problems, fmt.Sprintf(`Duplicate service name in "services[%d]": %q`, i, svc.Name))
+		}
+		services[strings.ToLower(svc.Name)] = true
+		found := false
+		for _, f := range wixFile.Files.Items {
+			found = found || filepath.Clean(f) == filepath.Clean(svc.File)
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf(`"services[%d].file" must be one of the "files.items": %q`, i, svc.File))
+		}
+		if _, ok := ServiceStarts[svc.Start]; svc.Start != "" && !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "start" value in "services[%d]": %q`, i, svc.Start))
+		}
+		if r := svc.Recovery; r != nil {
+			for _, a := range []struct{ key, value string }{{"first", r.First}, {"second", r.Second}, {"subsequent", r.Subsequent}} {
+				if _, ok := ServiceRecoveryActions[a.value]; a.value != "" && !ok {
+					problems = append(problems, fmt.Sprintf(`Invalid "%v" value in "services[%d].recovery": %q`, a.key, i, a.value))
+				}
+			}
+			if r.RestartDelay < 0 || r.ResetPeriod < 0 {
+				problems = append(problems, fmt.Sprintf(`"services[%d].recovery" delays must not be negative`, i))
+			}
+		}
+	}
+	comFiles := map[string]int{}
+	for i, c := range wixFile.Com {
+		if k, ok := comFiles[filepath.Clean(c.File)]; ok {
+			problems = append(problems, fmt.Sprintf(`"com[%d]" and "com[%d]" register the same file: %q`, k, i, c.File))
+		}
+		comFiles[filepath.Clean(c.File)] = i
+		if _, found := wixFile.fileID(c.File); !found {
+			problems = append(problems, fmt.Sprintf(`"com[%d].file" must be one of the "files.items", or "file-groups" items: %q`, i, c.File))
+		}
+		if len(c.Classes) == 0 && c.TypeLib == nil && !c.Harvest {
+			problems = append(problems, fmt.Sprintf(`"com[%d]" must have "classes", a "typelib" or "harvest"`, i))
+		}
+		for k, class := range c.Classes {
+			if _, err := uuid.FromString(class.CLSID); err != nil {
+				problems = append(problems, fmt.Sprintf(`Invalid "clsid" value in "com[%d].classes[%d]": %q, expected a guid`, i, k, class.CLSID))
+			}
+			if _, ok := ComServers[class.Server]; class.Server != "" && !ok {
+				problems = append(problems, fmt.Sprintf(`Invalid "server" value in "com[%d].classes[%d]": %q, expected inproc or local`, i, k, class.Server))
+			}
+			if _, ok := ComThreadingModels[class.ThreadingModel]; class.ThreadingModel != "" && (!ok || class.Server == "local") {
+				problems = append(problems, fmt.Sprintf(`Invalid "threading-model" value in "com[%d].classes[%d]": %q, expected apartment, free, both or neutral for an inproc server`, i, k, class.ThreadingModel))
+			}
+		}
+		if t := c.TypeLib; t != nil {
+			if _, err := uuid.FromString(t.ID); err != nil {
+				problems = append(problems, fmt.Sprintf(`Invalid "com[%d].typelib.id" value: %q, expected a guid`, i, t.ID))
+			}
+			if t.Version != "" && !typeLibVersionRe.MatchString(t.Version) {
+				problems = append(problems, fmt.Sprintf(`Invalid "com[%d].typelib.version" value: %q, expected major.minor`, i, t.Version))
+			}
+		}
+	}
+	sources := map[string]bool{}
+	for i, e := range wixFile.EventSources {
+		if strings.TrimSpace(e.Name) == "" {
+			problems = append(problems, fmt.Sprintf(`"event-sources[%d].name" must not be empty`, i))
+		} else if strings.ContainsAny(e.Name, `\/`) {
+			problems = append(problems, fmt.Sprintf(`Invalid "name" value in "event-sources[%d]": %q`, i, e.Name))
+		} else if sources[strings.ToLower(e.Name)] {
+			problems = append(problems, fmt.Sprintf(`Duplicate event source name in "event-sources[%d]": %q`, i, e.Name))
+		}
+		sources[strings.ToLower(e.Name)] = true
+	}
+	categories := map[string]bool{}
+	for i, c := range wixFile.PerfCategories {
+		if strings.TrimSpace(c.Name) == "" {
+			problems = append(problems, fmt.Sprintf(`"performance-counters[%d].name" must not be empty`, i))
+		} else if categories[strings.ToLower(c.Name)] {
+			problems = append(problems, fmt.Sprintf(`Duplicate category name in "performance-counters[%d]": %q`, i, c.Name))
+		}
+		categories[strings.ToLower(c.Name)] = true
+		if len(c.Counters) == 0 {
+			problems = append(problems, fmt.Sprintf(`"performance-counters[%d].counters" must not be empty`, i))
+		}
+		for k, counter := range c.Counters {
+			if strings.TrimSpace(counter.Name) == "" {
+				problems = append(problems, fmt.Sprintf(`"performance-counters[%d].counters[%d].name" must not be empty`, i, k))
+			}
+			if _, ok := PerfCounterTypes[counter.Type]; counter.Type != "" && !ok {
+				problems = append(problems, fmt.Sprintf(`Invalid "type" value in "performance-counters[%d].counters[%d]": %q`, i, k, counter.Type))
+			}
+		}
+	}
+	drivers := map[string]int{}
+	for i, d := range wixFile.Drivers {
+		if strings.ToLower(filepath.Ext(d.Inf)) != ".inf" {
+			problems = append(problems, fmt.Sprintf(`Invalid "inf" value in "drivers[%d]": %q, expected an inf file`, i, d.Inf))
+		}
+		for _, seg := range strings.Split(filepath.ToSlash(d.Dir), "/") {
+			if seg == ".." || filepath.IsAbs(d.Dir) {
+				problems = append(problems, fmt.Sprintf(`Invalid "drivers[%d].dir" value: %q, expected a path relative to the install directory`, i, d.Dir))
+				break
+			}
+		}
+		dir := strings.ToLower(wixFile.driverDir(d))
+		if k, ok := drivers[dir]; ok {
+			problems = append(problems, fmt.Sprintf(`"drivers[%d]" and "drivers[%d]" are installed into the same directory: %q, each driver package needs its own`, k, i, dir))
+		}
+		drivers[dir] = i
+	}
+	if len(wixFile.Drivers) > 0 && Archs[wixFile.Arch] == "arm64" {
+		problems = append(problems, `"drivers" can not be installed by an arm64 package, DIFxApp supports x86 and x64`)
+	}
+	tasks := map[string]bool{}
+	for i, t := range wixFile.ScheduledTasks {
+		if strings.TrimSpace(t.Name) == "" {
+			problems = append(problems, fmt.Sprintf(`"scheduled-tasks[%d].name" must not be empty`, i))
+		} else if strings.ContainsAny(t.Name, `"/`) {
+			problems = append(problems, fmt.Sprintf(`Invalid "name" value in "scheduled-tasks[%d]": %q`, i, t.Name))
+		} else if tasks[strings.ToLower(t.Name)] {
+			problems = append(problems, fmt.Sprintf(`Duplicate task name in "scheduled-tasks[%d]": %q`, i, t.Name))
+		}
+		tasks[strings.ToLower(t.Name)] = true
+		if strings.TrimSpace(t.Command) == "" {
+			problems = append(problems, fmt.Sprintf(`"scheduled-tasks[%d].command" must not be empty`, i))
+		}
+		if _, ok := TaskTriggers[t.Trigger]; !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "trigger" value in "scheduled-tasks[%d]": %q, expected logon, daily or boot`, i, t.Trigger))
+		}
+		if t.Time != "" && (t.Trigger != "daily" || !taskTimeRe.MatchString(t.Time)) {
+			problems = append(problems, fmt.Sprintf(`Invalid "time" value in "scheduled-tasks[%d]": %q, expected HH:MM for a daily task`, i, t.Time))
+		}
+		if _, ok := TaskRunLevels[t.RunLevel]; t.RunLevel != "" && !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "run-level" value in "scheduled-tasks[%d]": %q, expected limited or highest`, i, t.RunLevel))
+		}
+	}
+	extensions := map[string]bool{}
+	for i, a := range wixFile.FileAssociations {
+		ext := strings.ToLower(strings.TrimPrefix(a.Extension, "."))
+		if ext == "" {
+			problems = append(problems, fmt.Sprintf(`"file-associations[%d].extension" must not be empty`, i))
+		} else if strings.ContainsAny(ext, ` ./\*?`) {
+			problems = append(problems, fmt.Sprintf(`Invalid "extension" value in "file-associations[%d]": %q`, i, a.Extension))
+		} else if extensions[ext] {
+			problems = append(problems, fmt.Sprintf(`Duplicate extension in "file-associations[%d]": %q`, i, a.Extension))
+		}
+		extensions[ext] = true
+		if strings.TrimSpace(a.File) == "" {
+			problems = append(problems, fmt.Sprintf(`"file-associations[%d].file" must not be empty`, i))
+		}
+		if strings.ContainsAny(a.ProgID, ` \`) {
+			problems = append(problems, fmt.Sprintf(`Invalid "prog-id" value in "file-associations[%d]": %q`, i, a.ProgID))
+		}
+		verbs := map[string]bool{}
+		for k, v := range a.Verbs {
+			if strings.TrimSpace(v.ID) == "" {
+				problems = append(problems, fmt.Sprintf(`"file-associations[%d].verbs[%d].id" must not be empty`, i, k))
+			} else if verbs[strings.ToLower(v.ID)] {
+				problems = append(problems, fmt.Sprintf(`Duplicate verb in "file-associations[%d].verbs[%d]": %q`, i, k, v.ID))
+			}
+			verbs[strings.ToLower(v.ID)] = true
+		}
+	}
+	for i, r := range wixFile.Registry {
+		if _, ok := RegistryRoots[r.Root]; !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "root" value in "registry[%d]": %q`, i, r.Root))
+		}
+		if strings.TrimSpace(r.Key) == "" {
+			problems = append(problems, fmt.Sprintf(`"registry[%d].key" must not be empty`, i))
+		}
+		if _, ok := RegistryTypes[r.Type]; r.Type != "" && !ok {
+			problems = append(problems, fmt.Sprintf(`Invalid "type" value in "registry[%d]": %q`, i, r.Type))
+		} else if _, err := strconv.Atoi(r.Value); r.Type == "integer" && err != nil && !strings.HasPrefix(r.Value, "[") {
+			problems = append(problems, fmt.Sprintf(`"registry[%d].value" must be an integer: %q`, i, r.Value))
+		}
+	}
+	languages := map[string]bool{}
+	for i, l := range wixFile.Languages {
+		name := cultureName(l)
+		if name == "" {
+			problems = append(problems, fmt.Sprintf(`Unknown culture in "languages[%d]": %q`, i, l))
+		} else if languages[name] {
+			problems = append(problems, fmt.Sprintf(`Duplicate culture in "languages[%d]": %q`, i, l))
+		}
+		languages[name] = true
+	}
+	for _, l := range sortedKeys(wixFile.Localization) {
+		if !languages[cultureName(l)] {
+			problems = append(problems, fmt.Sprintf(`"localization.%v" must be one of the "languages"`, l))
+		}
+		for id := range wixFile.Localization[l] {
+			if !locIDRe.MatchString(id) || id == "ProductLanguage" {
+				problems = append(problems, fmt.Sprintf(`Invalid string id in "localization.%v": %q`, l, id))
+			}
+		}
+	}
+	for l := range wixFile.LocalizationFiles {
+		if !languages[cultureName(l)] {
+			problems = append(problems, fmt.Sprintf(`"localization-files.%v" must be one of the "languages"`, l))
+		}
+	}
+	if wixFile.Module && len(wixFile.Features) > 0 {
+		problems = append(problems, `A merge module has no "features", its components are added to the features of the product merging it`)
+	}
+	if wixFile.Module && len(wixFile.Conditions) > 0 {
+		problems = append(problems, `A merge module has no "launch-conditions", set them in the product merging it`)
+	}
+	if c := wixFile.Patch.Classification; c != "" && !PatchClassifications[c] {
+		problems = append(problems, fmt.Sprintf(`Invalid "patch.classification" value: %q, expected Update, Hotfix, Security Rollup, Critical Update, Service Pack or Update Rollup`, c))
+	}
+	for i, search := range wixFile.Bundle.Searches {
+		if !bundleIDRe.MatchString(search.Variable) {
+			problems = append(problems, fmt.Sprintf(`Invalid "variable" value in "bundle.searches[%d]": %q`, i, search.Variable))
+		}
+		if search.Root != "" && !BundleSearchRoots[search.Root] {
+			problems = append(problems, fmt.Sprintf(`Invalid "root" value in "bundle.searches[%d]": %q, expected HKLM, HKCU, HKCR or HKU`, i, search.Root))
+		}
+		if strings.TrimSpace(search.Key) == "" {
+			problems = append(problems, fmt.Sprintf(`"bundle.searches[%d].key" must not be empty`, i))
+		}
+	}
+	packages := map[string]bool{}
+	for i, pkg := range wixFile.Bundle.Prerequisites {
+		if !bundleIDRe.MatchString(pkg.ID) || pkg.ID == "MainPackage" {
+			problems = append(problems, fmt.Sprintf(`Invalid "id" value in "bundle.prerequisites[%d]": %q`, i, pkg.ID))
+		} else if packages[pkg.ID] {
+			problems = append(problems, fmt.Sprintf(`Duplicate id in "bundle.prerequisites[%d]": %q`, i, pkg.ID))
+		}
+		packages[pkg.ID] = true
+		if strings.TrimSpace(pkg.Source) == "" {
+			problems = append(problems, fmt.Sprintf(`"bundle.prerequisites[%d].source" must not be empty`, i))
+		}
+		t := pkg.Type
+		if t == "" {
+			t = strings.TrimPrefix(strings.ToLower(filepath.Ext(pkg.Source)), ".")
+		}
+		if !BundlePackageTypes[t] {
+			problems = append(problems, fmt.Sprintf(`Invalid "type" value in "bundle.prerequisites[%d]": %q, expected exe or msi`, i, t))
+		} else if t == "exe" && strings.TrimSpace(pkg.DetectCondition) == "" {
+			problems = append(problems, fmt.Sprintf(`"bundle.prerequisites[%d].detect-condition" must not be empty for an exe package`, i))
+		} else if t == "msi" && (pkg.DetectCondition != "" || pkg.Arguments != "") {
+			problems = append(problems, fmt.Sprintf(`"bundle.prerequisites[%d]" is an msi package, it is detected by its product code and takes no "arguments"`, i))
+		}
+	}
+	return problems
+}
+
+// referenceProblems returns the missing files referenced by the manifest,
+// and the shortcuts targeting a file that is not installed.
+func (wixFile *WixManifest) referenceProblems(choco bool) []string {
+	problems := []string{}
+	exists := func(field, p string) {
+		if p == "" {
+			return
+		}
+		if _, err := os.Stat(p); err != nil {
+			problems = append(problems, fmt.Sprintf(`%q not found: %q`, field, p))
+		}
+	}
+	for i, f := range wixFile.Files.Items {
+		exists(fmt.Sprintf("files.items[%d]", i), f)
+	}
+	for g, group := range wixFile.FileGroups {
+		for i, f := range group.Items {
+			exists(fmt.Sprintf("file-groups[%d].items[%d]", g, i), f)
+		}
+	}
+	for i, d := range wixFile.Directories {
+		if s, err := os.Stat(d); d != "" && (err != nil || !s.IsDir()) {
+			problems = append(problems, fmt.Sprintf(`"directories[%d]" is not a directory: %q`, i, d))
+		}
+	}
+	for i, c := range wixFile.Configs {
+		exists(fmt.Sprintf("configs[%d].source", i), c.Source)
+	}
+	for i, d := range wixFile.Drivers {
+		exists(fmt.Sprintf("drivers[%d].inf", i), d.Inf)
+		for k, f := range d.Files {
+			exists(fmt.Sprintf("drivers[%d].files[%d]", i, k), f)
+		}
+	}
+	exists("license", wixFile.License)
+	exists("arp.icon", wixFile.ARP.Icon)
+	exists("ui.banner", wixFile.UI.Banner)
+	exists("ui.background", wixFile.UI.Background)
+	languages := []string{}
+	for l := range wixFile.LocalizationFiles {
+		languages = append(languages, l)
+	}
+	sort.Strings(languages)
+	for _, l := range languages {
+		exists("localization-files."+l, wixFile.LocalizationFiles[l])
+	}
+	for i, pkg := range wixFile.Bundle.Prerequisites {
+		exists(fmt.Sprintf("bundle.prerequisites[%d].source", i), pkg.Source)
+	}
+	for i, s := range wixFile.Shortcuts.Items {
+		exists(fmt.Sprintf("shortcuts.items[%d].icon", i), s.Icon)
+		rel := strings.TrimPrefix(s.Target, "[INSTALLDIR]")
+		if rel != s.Target && !strings.ContainsAny(rel, "[]") && !wixFile.installsFile(rel) {
+			problems = append(problems, fmt.Sprintf(`"shortcuts.items[%d].target" is not an installed file: %q`, i, s.Target))
+		}
+	}
+	if choco {
+		if wixFile.Choco.ProjectURL == "" {
+			problems = append(problems, `"choco.project-url" must not be empty`)
+		}
+		if wixFile.Choco.RequireLicense && wixFile.Choco.LicenseURL == "" {
+			problems = append(problems, `"choco.license-url" must not be empty when "choco.require-license" is true`)
+		}
+		for i, dep := range wixFile.Choco.Dependencies {
+			if dep.ID == "" {
+				problems = append(problems, fmt.Sprintf(`"choco.dependencies[%d].id" must not be empty`, i))
+			}
+		}
+	}
+	return problems
+}
+
+// installsFile tells if rel, a path relative to the install directory, is an installed file.
+func (wixFile *WixManifest) installsFile(rel string) bool {
+	rel = strings.Trim(strings.Replace(rel, "\\", "/", -1), "/")
+	for _, f := range wixFile.Files.Items {
+		if strings.EqualFold(filepath.Base(f), rel) {
+			return true
+		}
+	}
+	for _, group := range wixFile.FileGroups {
+		dir := strings.Trim(filepath.ToSlash(group.Dir), "/")
+		for _, f := range group.Items {
+			if strings.EqualFold(dir+"/"+filepath.Base(f), rel) {
+				return true
+			}
+		}
+	}
+	for _, d := range wixFile.Directories {
+		prefix := filepath.Base(d) + "/"
+		if len(rel) > len(prefix) && strings.EqualFold(rel[:len(prefix)], prefix) {
+			if s, err := os.Stat(filepath.Join(d, rel[len(prefix):])); err == nil && !s.IsDir() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+var locIDRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+func sortedKeys(m map[string]map[string]string) []string {
+	ret := []string{}
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Loc returns a reference to the localized string id when the manifest has languages,
+// otherwise it returns text.
+func (wixFile *WixManifest) Loc(id, text string) string {
+	if len(wixFile.Cultures) == 0 {
+		return text
+	}
+	return "!(loc." + id + ")"
+}
+
+// MsiFiles returns the paths of the msi packages built for the requested msi path,
+// one per language.
+func (wixFile *WixManifest) MsiFiles(msi string) []string {
+	if len(wixFile.Cultures) == 0 {
+		return []string{msi}
+	}
+	ret := []string{}
+	for _, c := range wixFile.Cultures {
+		ret = append(ret, c.MsiFile(msi))
+	}
+	return ret
+}
+
+// NeedGUID tells if the manifest json file is missing guid values.
+func (wixFile *WixManifest) NeedGUID() bool {
+	need := false
+	if wixFile.UpgradeCode == "" {
+		need = true
+	}
+	if wixFile.Files.GUID == "" && !wixFile.Files.PerFile {
+		need = true
+	}
+	for _, group := range wixFile.FileGroups {
+		if group.GUID == "" && !group.PerFile {
+			need = true
+		}
+	}
+	if wixFile.Env.GUID == "" && !wixFile.Env.Empty() {
+		need = true
+	}
+	if wixFile.Shortcuts.GUID == "" && wixFile.Shortcuts.HasStartMenu() {
+		need = true
+	}
+	if wixFile.Shortcuts.DesktopGUID == "" && wixFile.Shortcuts.HasDesktop() {
+		need = true
+	}
+	if wixFile.Shortcuts.StartupGUID == "" && wixFile.Shortcuts.HasStartup() {
+		need = true
+	}
+	if wixFile.Shortcuts.ProgramFolderGUID == "" && wixFile.Shortcuts.HasProgramFolder() {
+		need = true
+	}
+	if wixFile.Firewall.GUID == "" && len(wixFile.Firewall.Rules) > 0 {
+		need = true
+	}
+	if wixFile.Cleanup.GUID == "" && len(wixFile.Cleanup.Items) > 0 {
+		need = true
+	}
+	return need
+}
+
+// RewriteFilePaths Reads Files and Directories of the wix.json file
+// and turn their values into a relative path to out
+// where out is the path to the wix templates files.
+// The lists it rewrites are copied first, so the copies of wixFile
+// sharing them, such as the manifests of other builds, are left intact.
+func (wixFile *WixManifest) RewriteFilePaths(out string) error {
+	var err error
+	out, err = filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	wixFile.BuildDir = out
+	if wixFile.Files.Items, err = relPaths(wixFile.Files.Items, out); err != nil {
+		return err
+	}
+	groups := make([]WixFiles, len(wixFile.FileGroups))
+	copy(groups, wixFile.FileGroups)
+	for i := range groups {
+		if groups[i].Items, err = relPaths(groups[i].Items, out); err != nil {
+			return err
+		}
+	}
+	wixFile.FileGroups = groups
+	drivers := make([]WixDriver, len(wixFile.Drivers))
+	copy(drivers, wixFile.Drivers)
+	for i, d := range drivers {
+		paths, err := relPaths(append([]string{d.Inf}, d.Files...), out)
+		if err != nil {
+			return err
+		}
+		drivers[i].Inf, drivers[i].Files = paths[0], paths[1:]
+	}
+	wixFile.Drivers = drivers
+	if wixFile.RelDirs, err = relPaths(wixFile.Directories, out); err != nil {
+		return err
+	}
+	if err := wixFile.harvestDirectories(out); err != nil {
+		return err
+	}
+	shortcuts := make([]WixShortcut, len(wixFile.Shortcuts.Items))
+	copy(shortcuts, wixFile.Shortcuts.Items)
+	wixFile.Shortcuts.Items = shortcuts
+	for i, s := range wixFile.Shortcuts.Items {
+		if s.Icon != "" {
+			wixFile.Shortcuts.Items[i].Icon, err = relIcon(s.Icon, out, fmt.Sprintf("shortcut %q", s.Name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if wixFile.ARP.Icon != "" {
+		wixFile.ARP.Icon, err = relIcon(wixFile.ARP.Icon, out, "arp")
+		if err != nil {
+			return err
+		}
+	}
+	for _, b := range []*string{&wixFile.UI.Banner, &wixFile.UI.Background} {
+		if *b == "" {
+			continue
+		}
+		if _, err = os.Stat(*b); err != nil {
+			return fmt.Errorf("UI bitmap not found: %v", err)
+		}
+		file, err := filepath.Abs(*b)
+		if err != nil {
+			return err
+		}
+		if *b, err = filepath.Rel(out, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BuildPath returns the path p, relative to the build directory, as the toolchain sees it,
+// p is returned as is when PathMap is empty.
+// Otherwise the longest host prefix of PathMap matching the absolute path of p
+// is replaced by its path in the build environment, and the path is written with backslashes,
+// such as Z:\home\me\dist\hello.exe, p is only written with backslashes when no prefix matches.
+func (wixFile *WixManifest) BuildPath(p string) string {
+	if len(wixFile.PathMap) == 0 || p == "" {
+		return p
+	}
+	abs := p
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(wixFile.BuildDir, p)
+	}
+	abs = filepath.ToSlash(abs)
+	host, build := "", ""
+	for h, b := range wixFile.PathMap {
+		if a, err := filepath.Abs(h); err == nil {
+			h = filepath.ToSlash(a)
+		}
+		if len(h) > len(host) && (abs == h || strings.HasPrefix(abs, strings.TrimSuffix(h, "/")+"/")) {
+			host, build = h, b
+		}
+	}
+	if host != "" {
+		p = strings.TrimRight(build, `\/`)
+		if rest := strings.TrimPrefix(strings.TrimPrefix(abs, host), "/"); rest != "" {
+			p += `\` + rest
+		}
+	}
+	return strings.Replace(p, "/", `\`, -1)
+}
+
+// SourceFiles returns the paths of the files the package installs,
+// of the files, the file groups and the harvested directories,
+// once RewriteFilePaths made them relative to the build directory.
+func (wixFile *WixManifest) SourceFiles() []string {
+	ret := append([]string{}, wixFile.Files.Items...)
+	for _, g := range wixFile.FileGroups {
+		ret = append(ret, g.Items...)
+	}
+	var walk func(d WixDir)
+	walk = func(d WixDir) {
+		for _, f := range d.Files {
+			ret = append(ret, f.Source)
+		}
+		for _, sub := range d.Dirs {
+			walk(sub)
+		}
+	}
+	for _, tree := range wixFile.DirTrees {
+		walk(tree)
+	}
+	for _, c := range wixFile.Configs {
+		ret = append(ret, c.File)
+	}
+	for _, d := range wixFile.Drivers {
+		ret = append(append(ret, d.Inf), d.Files...)
+	}
+	return ret
+}
+
+// relPaths returns the paths relative to out, in a new slice.
+func relPaths(paths []string, out string) ([]string, error) {
+	if paths == nil {
+		return nil, nil
+	}
+	ret := make([]string, len(paths))
+	for i, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		if ret[i], err = filepath.Rel(out, abs); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// relIcon checks the icon file of what, and returns its path relative to out.
+func relIcon(icon, out, what string) (string, error) {
+	if _, err := os.Stat(icon); err != nil {
+		return "", fmt.Errorf("Icon of %v not found: %v", what, err)
+	}
+	if !ico.IsIco(icon) && !ico.IsPng(icon) {
+		return "", fmt.Errorf("Icon of %v is neither an ICO, nor a PNG file: %q", what, icon)
+	}
+	file, err := filepath.Abs(icon)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(out, file)
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(rel, " ") {
+		return "", fmt.Errorf("Icon path of %v must not contain spaces: %q", what, rel)
+	}
+	return rel, nil
+}
+
+// RewriteBundlePaths Reads the packages of the Bundle
+// and rewrites their paths relative to the out directory,
+// msi is the msi package of the product.
+func (wixFile *WixManifest) RewriteBundlePaths(out, msi string) error {
+	var err error
+	out, err = filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	wixFile.BuildDir = out
+	for i, pkg := range wixFile.Bundle.Prerequisites {
+		if _, err = os.Stat(pkg.Source); err != nil {
+			return fmt.Errorf("Package of bundle prerequisite %q not found: %v", pkg.ID, err)
+		}
+		file, err := filepath.Abs(pkg.Source)
+		if err != nil {
+			return err
+		}
+		if wixFile.Bundle.Prerequisites[i].Source, err = filepath.Rel(out, file); err != nil {
+			return err
+		}
+	}
+	if _, err = os.Stat(msi); err != nil {
+		return fmt.Errorf("Msi package not found: %v", err)
+	}
+	file, err := filepath.Abs(msi)
+	if err != nil {
+		return err
+	}
+	wixFile.Bundle.MsiFile, err = filepath.Rel(out, file)
+	return err
+}
+
+// harvestDirectories walks each entry of Directories
+// to collect its files and sub directories into DirTrees,
+// so the generated wix recreates the same tree under the install directory.
+// Symlinks are followed, a directory is visited once.
+// The Harvest patterns are matched against the paths relative to each entry.
+func (wixFile *WixManifest) harvestDirectories(out string) error {
+	ns, err := uuid.FromString(wixFile.UpgradeCode)
+	if err != nil {
+		ns = uuid.NamespaceURL
+	}
+	permissions := map[string][]WixPermission{}
+	for _, p := range wixFile.Permissions {
+		if p.TreeFile {
+			rel := strings.ToLower(permissionPath(p.Path))
+			permissions[rel] = append(permissions[rel], p)
+		}
+	}
+	wixFile.DirTrees = []WixDir{}
+	for i, d := range wixFile.Directories {
+		h := &harvester{
+			out:         out,
+			prefix:      strconv.Itoa(i),
+			ns:          ns,
+			visited:     map[string]bool{},
+			filters:     wixFile.Harvest,
+			permissions: permissions,
+		}
+		d, err = filepath.Abs(d)
+		if err != nil {
+			return err
+		}
+		h.root = d
+		root, err := h.walk(d, "APPDIR"+h.prefix)
+		if err != nil {
+			return err
+		}
+		root.Components = h.components
+		wixFile.DirTrees = append(wixFile.DirTrees, root)
+	}
+	return nil
+}
+
+type harvester struct {
+	out         string
+	prefix      string
+	ns          uuid.UUID
+	n           int
+	visited     map[string]bool
+	components  []string
+	root        string
+	filters     WixHarvest
+	permissions map[string][]WixPermission // by path relative to the install directory, lower cased
+}
+
+// skip tells if the path p, a file or a directory, is filtered out.
+func (h *harvester) skip(p string, isDir bool) bool {
+	rel, err := filepath.Rel(h.root, p)
+	if err != nil {
+		return false
+	}
+	if excluded(rel, h.filters.Exclude) {
+		return true
+	}
+	return !isDir && len(h.filters.Include) > 0 && !excluded(rel, h.filters.Include)
+}
+
+func (h *harvester) nextID() string {
+	h.n++
+	return h.prefix + "_" + strconv.Itoa(h.n)
+}
+
+func (h *harvester) walk(dir string, id string) (WixDir, error) {
+	ret := WixDir{ID: id, Name: filepath.Base(dir)}
+	real, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return ret, err
+	}
+	h.visited[real] = true
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return ret, err
+	}
+	for _, entry := range entries {
+		p := filepath.Join(dir, entry.Name())
+		if entry.Mode()&os.ModeSymlink != 0 {
+			entry, err = os.Stat(p)
+			if err != nil {
+				return ret, err
+			}
+		}
+		if h.skip(p, entry.IsDir()) {
+			continue
+		}
+		if entry.IsDir() {
+			realSub, err := filepath.EvalSymlinks(p)
+			if err != nil {
+				return ret, err
+			}
+			if h.visited[realSub] {
+				continue
+			}
+			n, components := h.n, len(h.components)
+			sub, err := h.walk(p, "APPDIR"+h.nextID())
+			if err != nil {
+				return ret, err
+			}
+			if sub.Empty && !h.filters.KeepEmptyDirs() {
+				h.n, h.components = n, h.components[:components]
+				continue
+			}
+			ret.Dirs = append(ret.Dirs, sub)
+			continue
+		}
+		src, err := filepath.Rel(h.out, p)
+		if err != nil {
+			return ret, err
+		}
+		f := WixDirFile{ID: "AppFile" + h.nextID(), Source: src}
+		if rel, err := filepath.Rel(filepath.Dir(h.root), p); err == nil {
+			f.Permissions = h.permissions[strings.ToLower(filepath.ToSlash(rel))]
+		}
+		ret.Files = append(ret.Files, f)
+		h.components = append(h.components, "Comp"+f.ID)
+	}
+	if len(ret.Files) == 0 && len(ret.Dirs) == 0 {
+		// the guid is derived from the installed path, it is the same on every build machine
+		rel, err := filepath.Rel(filepath.Dir(h.root), dir)
+		if err != nil {
+			return ret, err
+		}
+		ret.Empty = true
+		ret.GUID = strings.ToUpper(uuid.NewV5(h.ns, filepath.ToSlash(rel)).String())
+		h.components = append(h.components, "Comp"+ret.ID)
+	}
+	return ret, nil
+}
+
+// progIDRe matches the characters removed from the product name to make a ProgId.
+var progIDRe = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// msixIDRe matches the characters an msix application id can not contain.
+var msixIDRe = regexp.MustCompile(`[^A-Za-z0-9]`)
+
+// normalizeMsix sets the defaults of the msix spec, and its applications.
+func (wixFile *WixManifest) normalizeMsix() {
+	spec := &wixFile.Msix
+	if spec.Name == "" {
+		spec.Name = wingetIDRe.ReplaceAllString(wixFile.Company, "") + "." + wingetIDRe.ReplaceAllString(wixFile.Product, "")
+	}
+	if spec.Publisher == "" {
+		spec.Publisher = "CN=" + wixFile.Company
+	}
+	if spec.DisplayName == "" {
+		spec.DisplayName = wixFile.Product
+	}
+	if spec.PublisherDisplayName == "" {
+		spec.PublisherDisplayName = wixFile.Company
 	}
-	if wixFile.Files.GUID == "" || force {
-		wixFile.Files.GUID = uuid.NewV4().String()
-		updated = true
+	if spec.Description == "" {
+		spec.Description = wixFile.Product
 	}
-	if (wixFile.Env.GUID == "" || force) && len(wixFile.Env.Vars) > 0 {
-		wixFile.Env.GUID = uuid.NewV4().String()
-		updated = true
+	if spec.MinVersion == "" {
+		spec.MinVersion = "10.0.17763.0"
 	}
-	if (wixFile.Shortcuts.GUID == "" || force) && len(wixFile.Shortcuts.Items) > 0 {
-		wixFile.Shortcuts.GUID = uuid.NewV4().String()
-		updated = true
+	spec.Version = wixFile.VersionOk
+	if strings.Count(spec.Version, ".") == 2 {
+		spec.Version += ".0"
+	}
+	spec.Arch = "x86"
+	if arch, ok := Archs[wixFile.Arch]; ok {
+		spec.Arch = arch
 	}
-	return updated, nil
-}
 
-// NeedGUID tells if the manifest json file is missing guid values.
-func (wixFile *WixManifest) NeedGUID() bool {
-	need := false
-	if wixFile.UpgradeCode == "" {
-		need = true
+	spec.Applications = []MsixApplication{}
+	ids := map[string]bool{}
+	add := func(exe, name string) {
+		id := msixIDRe.ReplaceAllString(name, "")
+		if id == "" || strings.ContainsAny(id[:1], "0123456789") {
+			id = "App" + id
+		}
+		if len(id) > 60 {
+			id = id[:60]
+		}
+		for base, n := id, 2; ids[id]; n++ {
+			id = base + strconv.Itoa(n)
+		}
+		ids[id] = true
+		spec.Applications = append(spec.Applications, MsixApplication{ID: id, Executable: exe, DisplayName: name})
 	}
-	if wixFile.Files.GUID == "" {
-		need = true
+	for _, s := range wixFile.Shortcuts.Items {
+		rel := strings.TrimPrefix(s.Target, "[INSTALLDIR]")
+		if rel == s.Target || !strings.EqualFold(filepath.Ext(rel), ".exe") {
+			continue
+		}
+		add(strings.Replace(strings.Trim(rel, "\\/"), "/", "\\", -1), s.Name)
 	}
-	if wixFile.Env.GUID == "" && len(wixFile.Env.Vars) > 0 {
-		need = true
+	if len(spec.Applications) > 0 {
+		return
 	}
-	if wixFile.Shortcuts.GUID == "" && len(wixFile.Shortcuts.Items) > 0 {
-		need = true
+	for _, f := range wixFile.Files.Items {
+		if strings.EqualFold(filepath.Ext(f), ".exe") {
+			add(filepath.Base(f), wixFile.Product)
+			return
+		}
 	}
-	return need
 }
 
-// RewriteFilePaths Reads Files and Directories of the wix.json file
-// and turn their values into a relative path to out
-// where out is the path to the wix templates files.
-func (wixFile *WixManifest) RewriteFilePaths(out string) error {
-	var err error
-	out, err = filepath.Abs(out)
-	if err != nil {
-		return err
-	}
+// wingetIDRe matches the characters a segment of a winget package identifier can not contain.
+var wingetIDRe = regexp.MustCompile(`[^A-Za-z0-9-]`)
+
+// fileID returns the wix File Id of the files.items,
+// or file-groups items, entry matching p.
+func (wixFile *WixManifest) fileID(p string) (string, bool) {
 	for i, file := range wixFile.Files.Items {
-		file, err = filepath.Abs(file)
-		if err != nil {
-			return err
-		}
-		wixFile.Files.Items[i], err = filepath.Rel(out, file)
-		if err != nil {
-			return err
+		if filepath.Clean(file) == filepath.Clean(p) {
+			return "ApplicationFile" + strconv.Itoa(i), true
 		}
 	}
-	for _, d := range wixFile.Directories {
-		d, err = filepath.Abs(d)
-		if err != nil {
-			return err
+	for g, group := range wixFile.FileGroups {
+		for i, file := range group.Items {
+			if filepath.Clean(file) == filepath.Clean(p) {
+				return "GroupFile" + strconv.Itoa(g) + "_" + strconv.Itoa(i), true
+			}
 		}
-		r, err := filepath.Rel(out, d)
+	}
+	return "", false
+}
+
+// versionRevision returns the fourth field of the msi version of v,
+// according to the version policy, or an empty string.
+func versionRevision(v *semver.Version, policy string) string {
+	field := ""
+	switch policy {
+	case "build":
+		// such as the commit count of GitVersion.
+		field = strings.Split(v.Metadata(), ".")[0]
+	case "prerelease":
+		fields := strings.Split(v.Prerelease(), ".")
+		field = fields[len(fields)-1]
+	}
+	if _, err := strconv.ParseUint(field, 10, 16); err != nil {
+		return ""
+	}
+	return field
+}
+
+// AutoVersion is the version value resolved by GitVersion.
+const AutoVersion = "auto"
+
+var gitDescribeRe = regexp.MustCompile(`^v?(.+)-([0-9]+)-g([0-9a-f]+)$`)
+
+// GitVersion derives a semver version from git describe --tags, in dir.
+// A tagged commit gets the version of its tag, 1.2.3,
+// the others get the commit count since the tag and the commit hash
+// as build metadata, 1.2.3+4.gabc1234.
+// Without tag, the version is 0.0.0 with the commit count of HEAD.
+func GitVersion(dir string) (string, error) {
+	git := func(args ...string) (string, error) {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = dir
+		out, err := cmd.Output()
 		if err != nil {
-			return err
+			if e, ok := err.(*exec.ExitError); ok && len(e.Stderr) > 0 {
+				return "", fmt.Errorf("git %v failed: %v", strings.Join(args, " "), strings.TrimSpace(string(e.Stderr)))
+			}
+			return "", fmt.Errorf("git %v failed: %v", strings.Join(args, " "), err)
 		}
-		wixFile.RelDirs = append(wixFile.RelDirs, r)
+		return strings.TrimSpace(string(out)), nil
 	}
-	for i, s := range wixFile.Shortcuts.Items {
-		if s.Icon != "" {
-			file, err := filepath.Abs(s.Icon)
-			if err != nil {
-				return err
-			}
-			wixFile.Shortcuts.Items[i].Icon, err = filepath.Rel(out, file)
-			if err != nil {
-				return err
-			}
+
+	desc, err := git("describe", "--tags", "--long", "--abbrev=7")
+	if err != nil {
+		count, countErr := git("rev-list", "--count", "HEAD")
+		if countErr != nil {
+			return "", fmt.Errorf("Failed to detect the version: %v", err)
+		}
+		hash, hashErr := git("rev-parse", "--short=7", "HEAD")
+		if hashErr != nil {
+			return "", fmt.Errorf("Failed to detect the version: %v", hashErr)
 		}
+		return "0.0.0+" + count + ".g" + hash, nil
 	}
-	return nil
+	m := gitDescribeRe.FindStringSubmatch(desc)
+	if m == nil {
+		return "", fmt.Errorf("Failed to detect the version: unexpected git describe output %q", desc)
+	}
+	v, err := semver.NewVersion(m[1])
+	if err != nil {
+		return "", fmt.Errorf("Failed to detect the version: tag %q is not a semver version: %v", m[1], err)
+	}
+	if m[2] == "0" {
+		return v.String(), nil
+	}
+	// the build metadata of the tag is replaced.
+	version := fmt.Sprintf("%d.%d.%d", v.Major(), v.Minor(), v.Patch())
+	if v.Prerelease() != "" {
+		version += "-" + v.Prerelease()
+	}
+	return version + "+" + m[2] + ".g" + m[3], nil
 }
 
 // Normalize Appropriately fixes some values within the decoded json
@@ -236,6 +3896,22 @@ func (wixFile *WixManifest) RewriteFilePaths(out string) error {
 // It applies defaults values on the choco property to
 // generate a nuget package
 func (wixFile *WixManifest) Normalize() error {
+	policy := wixFile.VersionPolicy
+	if wixFile.Version == "" || wixFile.Version == AutoVersion {
+		version, err := GitVersion("")
+		if err != nil {
+			return err
+		}
+		wixFile.Version = version
+		if policy == "" {
+			// keep the commit count, each build gets a distinct msi version.
+			policy = "build"
+		}
+	}
+	if err := wixFile.ExpandVars(); err != nil {
+		return err
+	}
+
 	// Wix version Field of Product element
 	// does not support semver strings
 	// it supports only something like x.x.x.x
@@ -251,6 +3927,9 @@ func (wixFile *WixManifest) Normalize() error {
 	okVersion += strconv.FormatInt(v.Major(), 10)
 	okVersion += "." + strconv.FormatInt(v.Minor(), 10)
 	okVersion += "." + strconv.FormatInt(v.Patch(), 10)
+	if revision := versionRevision(v, policy); revision != "" {
+		okVersion += "." + revision
+	}
 	wixFile.VersionOk = okVersion
 
 	// choco fix
@@ -271,6 +3950,252 @@ func (wixFile *WixManifest) Normalize() error {
 	}
 	wixFile.Choco.Tags += " admin" // required to pass chocolatey validation..
 
+	// install dir fix
+	if wixFile.InstallDir.Name == "" {
+		wixFile.InstallDir.Name = wixFile.Product
+	}
+	dirs := splitWinPath(wixFile.InstallDir.Name)
+	if len(dirs) > 0 {
+		wixFile.InstallDir.ParentDirs = dirs[:len(dirs)-1]
+		wixFile.InstallDir.DirName = dirs[len(dirs)-1]
+	}
+	if m := installRootRe.FindStringSubmatch(wixFile.InstallDir.Root); m != nil {
+		wixFile.InstallDir.Drive = strings.ToUpper(m[1]) + "\\"
+		wixFile.InstallDir.RootDirs = splitWinPath(wixFile.InstallDir.Root[len(m[1]):])
+	}
+
+	// media fix
+	wixFile.Media.Embed = wixFile.Media.EmbedCab == nil || *wixFile.Media.EmbedCab
+	wixFile.Media.Split = wixFile.Media.MaxUncompressedSize > 0 || wixFile.Media.MaxCabSize > 0
+	if wixFile.Media.Cabinet == "" {
+		wixFile.Media.Cabinet = "product.cab"
+		if wixFile.Media.Split {
+			wixFile.Media.Cabinet = "product{0}.cab"
+		}
+	}
+
+	// winget fix
+	if wixFile.Winget.ID == "" {
+		wixFile.Winget.ID = wingetIDRe.ReplaceAllString(wixFile.Company, "") + "." + wingetIDRe.ReplaceAllString(wixFile.Product, "")
+	}
+	if wixFile.Winget.ShortDescription == "" {
+		wixFile.Winget.ShortDescription = wixFile.Choco.Description
+	}
+	if wixFile.Winget.LicenseURL == "" {
+		wixFile.Winget.LicenseURL = wixFile.Choco.LicenseURL
+	}
+	if wixFile.Winget.PackageURL == "" {
+		wixFile.Winget.PackageURL = wixFile.Choco.ProjectURL
+	}
+
+	// scoop fix
+	if wixFile.Scoop.Name == "" {
+		wixFile.Scoop.Name = strings.ToLower(strings.Replace(wixFile.Product, " ", "-", -1))
+	}
+	if wixFile.Scoop.Description == "" {
+		wixFile.Scoop.Description = wixFile.Choco.Description
+	}
+	if wixFile.Scoop.Homepage == "" {
+		wixFile.Scoop.Homepage = wixFile.Choco.ProjectURL
+	}
+	if wixFile.Scoop.License == "" {
+		wixFile.Scoop.License = wixFile.Winget.License
+	}
+	if len(wixFile.Scoop.Bin) == 0 {
+		for _, f := range wixFile.Files.Items {
+			if strings.EqualFold(filepath.Ext(f), ".exe") {
+				wixFile.Scoop.Bin = append(wixFile.Scoop.Bin, filepath.Base(f))
+			}
+		}
+	}
+	if wixFile.Scoop.ExtractDir == "" {
+		pfiles := "PFiles"
+		if wixFile.Arch == "amd64" || wixFile.Arch == "x64" || wixFile.Arch == "arm64" {
+			pfiles = "PFiles64"
+		}
+		if wixFile.InstallDir.Drive != "" {
+			pfiles = strings.Join(wixFile.InstallDir.RootDirs, "\\")
+		}
+		wixFile.Scoop.ExtractDir = strings.TrimPrefix(pfiles+"\\"+strings.Join(dirs, "\\"), "\\")
+	}
+	if wixFile.Scoop.Checkver == "" && strings.HasPrefix(wixFile.Scoop.Homepage, "https://github.com/") {
+		wixFile.Scoop.Checkver = "github"
+	}
+
+	// msix fix
+	wixFile.normalizeMsix()
+
+	// module fix
+	wixFile.ModuleID = progIDRe.ReplaceAllString(wixFile.Product, "_")
+	if wixFile.ModuleID == "" || strings.ContainsAny(wixFile.ModuleID[:1], "0123456789") {
+		wixFile.ModuleID = "_" + wixFile.ModuleID
+	}
+	if ns, err := uuid.FromString(wixFile.UpgradeCode); err == nil {
+		wixFile.ModuleGUID = strings.ToUpper(uuid.NewV5(ns, "module").String())
+	}
+
+	// patch fix
+	if wixFile.Patch.Classification == "" {
+		wixFile.Patch.Classification = "Update"
+	}
+	if wixFile.Patch.Description == "" {
+		wixFile.Patch.Description = "Update of " + wixFile.Product
+	}
+
+	// bundle fix
+	if wixFile.Bundle.Name == "" {
+		wixFile.Bundle.Name = wixFile.Product
+	}
+	if ns, err := uuid.FromString(wixFile.UpgradeCode); err == nil && wixFile.Bundle.UpgradeCode == "" {
+		// a bundle must not share the upgrade code of its msi package
+		wixFile.Bundle.UpgradeCode = strings.ToUpper(uuid.NewV5(ns, "bundle").String())
+	}
+	for i, search := range wixFile.Bundle.Searches {
+		if search.Root == "" {
+			wixFile.Bundle.Searches[i].Root = "HKLM"
+		}
+	}
+	for i, pkg := range wixFile.Bundle.Prerequisites {
+		if pkg.Type == "" {
+			wixFile.Bundle.Prerequisites[i].Type = strings.TrimPrefix(strings.ToLower(filepath.Ext(pkg.Source)), ".")
+		}
+	}
+
+	// env fix, apply wix defaults explicitly
+	for i, env := range wixFile.Env.Vars {
+		if env.Permanent == "" {
+			wixFile.Env.Vars[i].Permanent = "no"
+		}
+		if env.System == "" {
+			wixFile.Env.Vars[i].System = "no"
+		}
+		if env.Action == "" {
+			wixFile.Env.Vars[i].Action = "set"
+		}
+		if env.Part == "" {
+			wixFile.Env.Vars[i].Part = "all"
+		}
+	}
+	for i, p := range wixFile.Env.Path {
+		if p.Dir == "" {
+			wixFile.Env.Path[i].Dir = "[INSTALLDIR]"
+		}
+		if p.Position == "" {
+			wixFile.Env.Path[i].Position = "last"
+		}
+		if p.System == "" {
+			wixFile.Env.Path[i].System = "yes"
+			if wixFile.InstallScope == "perUser" {
+				wixFile.Env.Path[i].System = "no"
+			}
+		}
+	}
+
+	// ui fix
+	if wixFile.UI.Dialogs == "" {
+		wixFile.UI.Dialogs = "installDir"
+	}
+	wixFile.UI.ShowInstallDir = wixFile.UI.InstallDirDialog == nil || *wixFile.UI.InstallDirDialog
+	wixFile.UI.ShowLicense = wixFile.License != "" && (wixFile.UI.LicenseDialog == nil || *wixFile.UI.LicenseDialog)
+	if l := wixFile.UI.Launch; l != nil {
+		launch := *l
+		launch.Target = l.URL
+		if l.File != "" {
+			id, found := wixFile.fileID(l.File)
+			if !found {
+				return fmt.Errorf("Launch file %q is not a files.items, nor a file-groups items, entry", l.File)
+			}
+			launch.Target = "[#" + id + "]"
+		}
+		if launch.Text == "" {
+			launch.Text = "Launch " + wixFile.Product
+		}
+		wixFile.UI.Launch = &launch
+	}
+
+	// upgrade fix, the configs removed on uninstall are kept by removing
+	// the previous version after the install of the new one
+	if wixFile.Upgrade.Schedule == "" && wixFile.removesConfigs() {
+		wixFile.Upgrade.Schedule = "afterInstallExecute"
+	}
+	if wixFile.Upgrade.Schedule == "" {
+		wixFile.Upgrade.Schedule = "afterInstallValidate"
+	}
+
+	// install scope fix
+	if wixFile.InstallScope == "" {
+		wixFile.InstallScope = "perMachine"
+	}
+
+	// services fix
+	for i, svc := range wixFile.Services {
+		wixFile.Services[i].FileIndex = -1
+		for k, f := range wixFile.Files.Items {
+			if filepath.Clean(f) == filepath.Clean(svc.File) {
+				wixFile.Services[i].FileIndex = k
+			}
+		}
+		if wixFile.Services[i].FileIndex < 0 {
+			return fmt.Errorf("Service file %q is not a files.items entry", svc.File)
+		}
+		if svc.Start == "" {
+			wixFile.Services[i].Start = "auto"
+		}
+		if svc.DisplayName == "" {
+			wixFile.Services[i].DisplayName = svc.Name
+		}
+		if r := svc.Recovery; r != nil {
+			for _, a := range []*string{&r.First, &r.Second, &r.Subsequent} {
+				if *a == "" {
+					*a = "none"
+				}
+			}
+		}
+	}
+
+	// registry fix
+	for i, r := range wixFile.Registry {
+		if r.Type == "" {
+			wixFile.Registry[i].Type = "string"
+		}
+	}
+
+	// launch conditions never block the uninstall
+	for i, c := range wixFile.Conditions {
+		parts := []string{}
+		if strings.TrimSpace(c.Condition) != "" {
+			parts = append(parts, "("+c.Condition+")")
+		}
+		if c.MinWindowsBuild > 0 {
+			parts = append(parts, fmt.Sprintf("WINDOWSBUILDNUMBER >= %d", c.MinWindowsBuild))
+		}
+		if c.Registry != nil {
+			parts = append(parts, fmt.Sprintf("LAUNCHREGISTRY%d", i))
+		}
+		if c.File != "" {
+			k := strings.LastIndexAny(c.File, `\/]`)
+			wixFile.Conditions[i].FileDir = c.File[:k+1]
+			wixFile.Conditions[i].FileName = c.File[k+1:]
+			parts = append(parts, fmt.Sprintf("LAUNCHFILE%d", i))
+		}
+		if r, ok := Runtimes[c.Runtime]; ok {
+			wixFile.Conditions[i].RuntimeSearch = &r
+			parts = append(parts, fmt.Sprintf(`LAUNCHRUNTIME%d >= "%v"`, i, r.Min))
+		}
+		if c.Conflict != "" {
+			wixFile.Conditions[i].Conflict = strings.ToUpper(c.Conflict)
+			parts = append(parts, fmt.Sprintf("NOT LAUNCHCONFLICT%d", i))
+		}
+		if len(parts) == 0 {
+			// the disk space is checked once the install directory is costed
+			continue
+		}
+		wixFile.Conditions[i].CookedCondition = "Installed OR (" + strings.Join(parts, " AND ") + ")"
+	}
+
+	// signing fix
+	wixFile.Signing.SetDefaults()
+
 	// Escape hook commands and ensure the command name is enclosed in quotes (needed by wix)
 	for i, hook := range wixFile.Hooks {
 		cmd := strings.Trim(hook.Command, " ")
@@ -285,6 +4210,33 @@ func (wixFile *WixManifest) Normalize() error {
 		wixFile.Hooks[i].CookedCommand = buf.String()
 	}
 
+	// Scheduled tasks are created, or replaced, by schtasks,
+	// a command relative to the install directory runs an installed file
+	for i, t := range wixFile.ScheduledTasks {
+		command := t.Command
+		if wixFile.installsFile(command) {
+			command = "[INSTALLDIR]" + strings.Replace(strings.Trim(filepath.ToSlash(command), "/"), "/", "\\", -1)
+		}
+		run := `"` + command + `"`
+		if t.Arguments != "" {
+			run += " " + t.Arguments
+		}
+		if t.RunLevel == "" {
+			wixFile.ScheduledTasks[i].RunLevel = "limited"
+		}
+		if t.User == "" {
+			wixFile.ScheduledTasks[i].User = "SYSTEM"
+		}
+		create := fmt.Sprintf(`"[SystemFolder]schtasks.exe" /Create /F /TN "%v" /TR "%v" /SC %v /RL %v /RU "%v"`,
+			t.Name, strings.Replace(run, `"`, `\"`, -1), TaskTriggers[t.Trigger],
+			TaskRunLevels[wixFile.ScheduledTasks[i].RunLevel], wixFile.ScheduledTasks[i].User)
+		if t.Time != "" {
+			create += " /ST " + t.Time
+		}
+		wixFile.ScheduledTasks[i].CreateCommand = create
+		wixFile.ScheduledTasks[i].DeleteCommand = fmt.Sprintf(`"[SystemFolder]schtasks.exe" /Delete /F /TN "%v"`, t.Name)
+	}
+
 	// Separate install and uninstall hooks to simplify templating
 	for _, hook := range wixFile.Hooks {
 		switch hook.When {
@@ -295,5 +4247,335 @@ func (wixFile *WixManifest) Normalize() error {
 		}
 	}
 
+	// Firewall rule programs refer to an installed file
+	for i, r := range wixFile.Firewall.Rules {
+		if r.Program == "" || strings.Contains(r.Program, "[") {
+			wixFile.Firewall.Rules[i].CookedProgram = r.Program
+			continue
+		}
+		id, found := wixFile.fileID(r.Program)
+		if !found {
+			return fmt.Errorf("Firewall rule %q program %q is not a files.items, nor a file-groups items, entry", r.Name, r.Program)
+		}
+		wixFile.Firewall.Rules[i].CookedProgram = "[#" + id + "]"
+	}
+
+	// File groups sub directories are created one level at a time
+	for g, group := range wixFile.FileGroups {
+		wixFile.FileGroups[g].DirSegments = strings.Split(filepath.ToSlash(filepath.Clean(group.Dir)), "/")
+	}
+
+	// Cleanup patterns are split into directories and file names
+	wixFile.Cleanup.Dirs = []WixCleanupDir{}
+	wixFile.Cleanup.Files = []WixCleanupFile{}
+	cleanupDirs := map[string]string{"": "INSTALLDIR"}
+	for _, item := range wixFile.Cleanup.Items {
+		p := filepath.ToSlash(item)
+		name := "*"
+		segs := strings.Split(strings.Trim(p, "/"), "/")
+		if !strings.HasSuffix(p, "/") {
+			name = segs[len(segs)-1]
+			segs = segs[:len(segs)-1]
+		}
+		parent := ""
+		for k, seg := range segs {
+			dir := strings.Join(segs[:k+1], "/")
+			if _, ok := cleanupDirs[dir]; !ok {
+				cleanupDirs[dir] = "CLEANUPDIR" + strconv.Itoa(len(wixFile.Cleanup.Dirs))
+				wixFile.Cleanup.Dirs = append(wixFile.Cleanup.Dirs, WixCleanupDir{
+					ID:       cleanupDirs[dir],
+					ParentID: cleanupDirs[parent],
+					Name:     seg,
+					depth:    k,
+				})
+			}
+			parent = dir
+		}
+		wixFile.Cleanup.Files = append(wixFile.Cleanup.Files, WixCleanupFile{
+			DirID: cleanupDirs[parent],
+			Name:  name,
+		})
+	}
+	// sub directories must be removed before their parent
+	sort.SliceStable(wixFile.Cleanup.Dirs, func(i, j int) bool {
+		return wixFile.Cleanup.Dirs[i].depth > wixFile.Cleanup.Dirs[j].depth
+	})
+
+	// Permissions apply to an installed file, or to a directory,
+	// declared like the cleanup directories, and created by its own component.
+	ns, err := uuid.FromString(wixFile.UpgradeCode)
+	if err != nil {
+		ns = uuid.NamespaceURL
+	}
+	wixFile.PermissionDirs = []WixSubDir{}
+	permissionDirs := map[string]string{"": "INSTALLDIR"}
+	for i := range wixFile.Permissions {
+		p := &wixFile.Permissions[i]
+		rel := permissionPath(p.Path)
+		if key, ok := wixFile.installedFileID(rel); ok {
+			p.FileKey = key
+			continue
+		}
+		if rel != "" && wixFile.installsFile(rel) {
+			p.TreeFile = true
+			continue
+		}
+		p.DirID = subDirID(permissionDirs, &wixFile.PermissionDirs, "PERMISSIONDIR", rel)
+		p.GUID = strings.ToUpper(uuid.NewV5(ns, fmt.Sprintf("permissions[%d]/%v", i, rel)).String())
+	}
+
+	// Configs get a guid derived from their installed path,
+	// so the upgrades keep the component, and the file, of the previous version.
+	wixFile.ConfigDirs = []WixSubDir{}
+	configDirs := map[string]string{"": "INSTALLDIR"}
+	for i := range wixFile.Configs {
+		c := &wixFile.Configs[i]
+		if c.Name == "" {
+			c.Name = strings.TrimSuffix(filepath.Base(c.Source), ".tmpl")
+		}
+		rel := permissionPath(c.Dir)
+		c.DirID = subDirID(configDirs, &wixFile.ConfigDirs, "CONFIGDIR", rel)
+		c.GUID = strings.ToUpper(uuid.NewV5(ns, strings.ToLower("configs/"+strings.TrimPrefix(rel+"/"+c.Name, "/"))).String())
+	}
+
+	// COM registrations are children of the File element of an installed file
+	for i, c := range wixFile.Com {
+		id, found := wixFile.fileID(c.File)
+		if !found {
+			return fmt.Errorf("COM file %q is not a files.items, nor a file-groups items, entry", c.File)
+		}
+		wixFile.Com[i].FileKey = id
+		wixFile.Com[i].GUID = strings.ToUpper(uuid.NewV5(ns, "com/"+id).String())
+		for k, class := range c.Classes {
+			class.CLSID = strings.ToUpper(class.CLSID)
+			if class.Server == "" {
+				class.Server = "inproc"
+			}
+			if class.ThreadingModel == "" && class.Server == "inproc" {
+				class.ThreadingModel = "apartment"
+			}
+			wixFile.Com[i].Classes[k] = class
+		}
+		if t := c.TypeLib; t != nil {
+			typeLib := *t
+			typeLib.ID = strings.ToUpper(t.ID)
+			wixFile.Com[i].TypeLib = &typeLib
+		}
+	}
+
+	// Event sources and performance categories get a guid derived from their name
+	for i, e := range wixFile.EventSources {
+		if e.Log == "" {
+			wixFile.EventSources[i].Log = "Application"
+		}
+		if e.MessageFile == "" {
+			wixFile.EventSources[i].MessageFile = DefaultEventMessageFile
+		} else if id, found := wixFile.fileID(e.MessageFile); found {
+			wixFile.EventSources[i].MessageFile = "[#" + id + "]"
+		}
+		wixFile.EventSources[i].GUID = strings.ToUpper(uuid.NewV5(ns, strings.ToLower("event-sources/"+e.Name)).String())
+	}
+	for i, c := range wixFile.PerfCategories {
+		for k, counter := range c.Counters {
+			if counter.Type == "" {
+				wixFile.PerfCategories[i].Counters[k].Type = "numberOfItems32"
+			}
+		}
+		wixFile.PerfCategories[i].GUID = strings.ToUpper(uuid.NewV5(ns, strings.ToLower("performance-counters/"+c.Name)).String())
+	}
+
+	// Drivers are installed into their own directory, DIFxApp requires one inf per directory
+	wixFile.DriverDirs = []WixSubDir{}
+	driverDirs := map[string]string{"": "INSTALLDIR"}
+	for i, d := range wixFile.Drivers {
+		rel := wixFile.driverDir(d)
+		wixFile.Drivers[i].DirID = subDirID(driverDirs, &wixFile.DriverDirs, "DRIVERDIR", rel)
+		wixFile.Drivers[i].GUID = strings.ToUpper(uuid.NewV5(ns, strings.ToLower("drivers/"+rel)).String())
+	}
+
+	// Each language gets the strings of the manifest, overridden by
+	// the localization file and the localization of the default language, then by its own.
+	localization := map[string]map[string]string{}
+	for l, strs := range wixFile.Localization {
+		localization[cultureName(l)] = strs
+	}
+	files := map[string]map[string]string{}
+	for l, p := range wixFile.LocalizationFiles {
+		strs, err := readWxl(p)
+		if err != nil {
+			return fmt.Errorf("Failed to read the localization file of %q: %v", l, err)
+		}
+		files[cultureName(l)] = strs
+	}
+	wixFile.Cultures = []WixCulture{}
+	for i, l := range wixFile.Languages {
+		name := cultureName(l)
+		c := WixCulture{
+			Culture: KnownCultures[name],
+			Name:    name,
+			Default: i == 0,
+			Strings: map[string]string{
+				"ProductName":  wixFile.Product,
+				"Manufacturer": wixFile.Company,
+			},
+		}
+		for k, s := range wixFile.Shortcuts.Items {
+			c.Strings[fmt.Sprintf("Shortcut%dDescription", k)] = s.Description
+		}
+		if wixFile.UI.Launch != nil {
+			c.Strings["LaunchText"] = wixFile.UI.Launch.Text
+		}
+		for _, from := range []string{cultureName(wixFile.Languages[0]), name} {
+			for id, text := range files[from] {
+				c.Strings[id] = text
+			}
+			for id, text := range localization[from] {
+				c.Strings[id] = text
+			}
+		}
+		c.Strings["ProductLanguage"] = strconv.Itoa(c.LCID)
+		wixFile.Cultures = append(wixFile.Cultures, c)
+	}
+
+	// Custom actions run an installed file
+	for i, a := range wixFile.CustomActions {
+		id, found := wixFile.fileID(a.File)
+		if !found {
+			return fmt.Errorf("Custom action file %q is not a files.items, nor a file-groups items, entry", a.File)
+		}
+		wixFile.CustomActions[i].FileKey = id
+		wixFile.CustomActions[i].Script = strings.ToLower(filepath.Ext(a.File)) == ".ps1"
+	}
+
+	// File associations open an installed file
+	for i, a := range wixFile.FileAssociations {
+		fa := &wixFile.FileAssociations[i]
+		id, found := wixFile.fileID(a.File)
+		if !found {
+			return fmt.Errorf("File association file %q is not a files.items, nor a file-groups items, entry", a.File)
+		}
+		fa.FileKey = id
+		fa.IconKey = id
+		if a.Icon != "" {
+			if fa.IconKey, found = wixFile.fileID(a.Icon); !found {
+				return fmt.Errorf("File association icon %q is not a files.items, nor a file-groups items, entry", a.Icon)
+			}
+		}
+		fa.Extension = strings.ToLower(strings.TrimPrefix(a.Extension, "."))
+		if a.ProgID == "" {
+			fa.ProgID = progIDRe.ReplaceAllString(wixFile.Product, "") + "." + fa.Extension
+		}
+		if len(a.Verbs) == 0 {
+			fa.Verbs = []WixVerb{{ID: "open"}}
+		}
+		for k, v := range fa.Verbs {
+			if v.Arguments == "" {
+				fa.Verbs[k].Arguments = `"%1"`
+			}
+		}
+	}
+
+	// Shortcuts goes to the start menu by default
+	for i, s := range wixFile.Shortcuts.Items {
+		wixFile.Shortcuts.Items[i].Location, _ = shortcutLocation(s.Location)
+	}
+	if wixFile.Shortcuts.StartMenuFolder == "" {
+		wixFile.Shortcuts.StartMenuFolder = wixFile.Product
+	}
+
+	// Features collect the components of their items
+	features := map[string]*WixFeature{}
+	walkFeatures(wixFile.Features, func(f *WixFeature) {
+		if f.Level == 0 {
+			f.Level = 1
+			if f.Default == "absent" {
+				f.Level = 1000
+			}
+		}
+		if f.Display == "" {
+			f.Display = "collapse"
+		}
+		f.Components = []string{}
+		f.Groups = []string{}
+		features[f.ID] = f
+	})
+	for _, item := range wixFile.featureItems() {
+		if features[item.feature] == nil {
+			return fmt.Errorf("The feature %q of %v is not declared in features", item.feature, item.field)
+		}
+		if item.group {
+			features[item.feature].Groups = append(features[item.feature].Groups, item.component)
+		} else if item.component != "" {
+			features[item.feature].Components = append(features[item.feature].Components, item.component)
+		}
+	}
+	wixFile.Env.Components = wixFile.envComponents()
+	wixFile.Shortcuts.Components = wixFile.shortcutComponents()
+	for _, c := range wixFile.Env.Components {
+		if c.Feature != "" {
+			features[c.Feature].Components = append(features[c.Feature].Components, c.ID)
+		}
+	}
+	for _, c := range wixFile.Shortcuts.Components {
+		if c.Feature != "" {
+			features[c.Feature].Components = append(features[c.Feature].Components, c.ID)
+		}
+	}
+
 	return nil
 }
+
+// envComponents groups the variables by feature, in order of appearance.
+func (wixFile *WixManifest) envComponents() []WixEnvComponent {
+	ret := []WixEnvComponent{}
+	get := func(feature string) *WixEnvComponent {
+		for i := range ret {
+			if ret[i].Feature == feature {
+				return &ret[i]
+			}
+		}
+		c := WixEnvComponent{ID: "ENVS", GUID: wixFile.Env.GUID}
+		if feature != "" {
+			c = WixEnvComponent{ID: "ENVS_" + feature, GUID: "*", Feature: feature}
+		}
+		ret = append(ret, c)
+		return &ret[len(ret)-1]
+	}
+	for i, e := range wixFile.Env.Vars {
+		c := get(e.Feature)
+		c.Vars = append(c.Vars, i)
+	}
+	for i, p := range wixFile.Env.Path {
+		c := get(p.Feature)
+		c.Path = append(c.Path, i)
+	}
+	return ret
+}
+
+// shortcutComponents groups the shortcuts by location, then by feature, in order of appearance.
+func (wixFile *WixManifest) shortcutComponents() []WixShortcutComponent {
+	ret := []WixShortcutComponent{}
+	for _, l := range shortcutComponents {
+		start := len(ret)
+		for i, s := range wixFile.Shortcuts.Items {
+			if s.Location != l.location {
+				continue
+			}
+			k := start
+			for k < len(ret) && ret[k].Feature != s.Feature {
+				k++
+			}
+			if k == len(ret) {
+				c := WixShortcutComponent{ID: l.id, GUID: l.guid(wixFile.Shortcuts), Location: l.location, Dir: l.dir, Key: l.key}
+				if s.Feature != "" {
+					c.ID += "_" + s.Feature
+					c.GUID = "*"
+					c.Feature = s.Feature
+				}
+				ret = append(ret, c)
+			}
+			ret[k].Items = append(ret[k].Items, i)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,172 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeManifests writes the files into a temporary directory, it returns the directory.
+func writeManifests(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "go-msi-manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			os.RemoveAll(dir)
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoadExtended(t *testing.T) {
+	dir := writeManifests(t, map[string]string{
+		"base.json": `{
+			"product": "base",
+			"company": "base company",
+			"version": "1.0.0",
+			"upgrade-code": "11111111-1111-1111-1111-111111111111",
+			"files": {"guid": "22222222-2222-2222-2222-222222222222", "items": ["base.exe"]},
+			"env": {"guid": "33333333-3333-3333-3333-333333333333", "vars": [{"name": "BASE"}], "path": [{"dir": "base"}]}
+		}`,
+		"parent.json": `{
+			"extends": "base.json",
+			"product": "parent",
+			"license": "parent.rtf",
+			"files": {"items": ["parent.exe"], "exclude": ["*.pdb"]},
+			"env": {"vars": [{"name": "PARENT"}], "path": [{"dir": "parent"}]}
+		}`,
+		"child.json": `{
+			"extends": "parent.json",
+			"merge-lists": {"env.path": "replace"},
+			"product": "child",
+			"company": "",
+			"version": null,
+			"files": {"items": ["child.exe"], "exclude": ["*.log"]},
+			"env": {"vars": [{"name": "CHILD"}], "path": [{"dir": "child"}]}
+		}`,
+	})
+	defer os.RemoveAll(dir)
+
+	wixFile := WixManifest{}
+	if err := wixFile.LoadExtended(filepath.Join(dir, "child.json")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the child wins, unless its value is null or an empty string
+	for _, c := range []struct{ name, got, want string }{
+		{"product", wixFile.Product, "child"},
+		{"company", wixFile.Company, "base company"},
+		{"version", wixFile.Version, "1.0.0"},
+		{"license", wixFile.License, "parent.rtf"},
+	} {
+		if c.got != c.want {
+			t.Errorf("%v: got %q, want %q", c.name, c.got, c.want)
+		}
+	}
+
+	// files.items and env.vars are concatenated, the parent items first
+	if want := []string{"base.exe", "parent.exe", "child.exe"}; !reflect.DeepEqual(wixFile.Files.Items, want) {
+		t.Errorf("files.items: got %v, want %v", wixFile.Files.Items, want)
+	}
+	vars := []string{}
+	for _, v := range wixFile.Env.Vars {
+		vars = append(vars, v.Name)
+	}
+	if want := []string{"BASE", "PARENT", "CHILD"}; !reflect.DeepEqual(vars, want) {
+		t.Errorf("env.vars: got %v, want %v", vars, want)
+	}
+
+	// the other lists, and those merge-lists replaces, are replaced
+	if want := []string{"*.log"}; !reflect.DeepEqual(wixFile.Files.Exclude, want) {
+		t.Errorf("files.exclude: got %v, want %v", wixFile.Files.Exclude, want)
+	}
+	if len(wixFile.Env.Path) != 1 || wixFile.Env.Path[0].Dir != "child" {
+		t.Errorf("env.path: got %v, want the child entry only", wixFile.Env.Path)
+	}
+
+	// guids and upgrade-code are not inherited
+	for _, c := range []struct{ name, got string }{
+		{"upgrade-code", wixFile.UpgradeCode},
+		{"files.guid", wixFile.Files.GUID},
+		{"env.guid", wixFile.Env.GUID},
+	} {
+		if c.got != "" {
+			t.Errorf("%v: got %q, want it not inherited", c.name, c.got)
+		}
+	}
+}
+
+func TestLoadExtendedAppend(t *testing.T) {
+	dir := writeManifests(t, map[string]string{
+		"parent.json": `{"product": "parent", "files": {"items": ["parent.exe"], "exclude": ["*.pdb"]}}`,
+		"child.json": `{
+			"extends": "parent.json",
+			"merge-lists": {"files.exclude": "append", "files.items": "replace"},
+			"files": {"items": ["child.exe"], "exclude": ["*.log"]}
+		}`,
+	})
+	defer os.RemoveAll(dir)
+
+	wixFile := WixManifest{}
+	if err := wixFile.LoadExtended(filepath.Join(dir, "child.json")); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"child.exe"}; !reflect.DeepEqual(wixFile.Files.Items, want) {
+		t.Errorf("files.items: got %v, want %v", wixFile.Files.Items, want)
+	}
+	if want := []string{"*.pdb", "*.log"}; !reflect.DeepEqual(wixFile.Files.Exclude, want) {
+		t.Errorf("files.exclude: got %v, want %v", wixFile.Files.Exclude, want)
+	}
+}
+
+func TestLoadExtendedInvalidMergeLists(t *testing.T) {
+	dir := writeManifests(t, map[string]string{
+		"parent.json": `{"product": "parent"}`,
+		"child.json":  `{"extends": "parent.json", "merge-lists": {"files.items": "prepend"}}`,
+	})
+	defer os.RemoveAll(dir)
+
+	wixFile := WixManifest{}
+	err := wixFile.LoadExtended(filepath.Join(dir, "child.json"))
+	if err == nil || !strings.Contains(err.Error(), "merge-lists.files.items") {
+		t.Errorf("got %v, want an invalid merge-lists error", err)
+	}
+}
+
+func TestLoadExtendedCycle(t *testing.T) {
+	dir := writeManifests(t, map[string]string{
+		"a.json": `{"extends": "b.json", "product": "a"}`,
+		"b.json": `{"extends": "c.json", "product": "b"}`,
+		"c.json": `{"extends": "a.json", "product": "c"}`,
+	})
+	defer os.RemoveAll(dir)
+
+	wixFile := WixManifest{}
+	err := wixFile.LoadExtended(filepath.Join(dir, "a.json"))
+	if err == nil || !strings.Contains(err.Error(), "extends itself") {
+		t.Errorf("got %v, want a cycle error", err)
+	}
+}
+
+func TestNormalizeVersionPolicy(t *testing.T) {
+	for _, c := range []struct{ version, policy, want string }{
+		{"1.2.3+77", "", "1.2.3"},
+		{"1.2.3+77", "build", "1.2.3.77"},
+		{"1.2.3-rc.4", "prerelease", "1.2.3.4"},
+		{"1.2.3+77", "drop", "1.2.3"},
+	} {
+		wixFile := WixManifest{Product: "p", Version: c.version, VersionPolicy: c.policy}
+		if err := wixFile.Normalize(); err != nil {
+			t.Fatal(err)
+		}
+		if wixFile.VersionOk != c.want {
+			t.Errorf("%v with %q: got %q, want %q", c.version, c.policy, wixFile.VersionOk, c.want)
+		}
+	}
+}
@@ -1,9 +1,14 @@
 package rtf
 
 import (
+	"bytes"
+	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"regexp"
 	"strings"
 	"unicode"
+	"unicode/utf16"
 
 	"golang.org/x/text/encoding/charmap"
 	"golang.org/x/text/runes"
@@ -75,6 +80,210 @@ func WriteAsRtf(src string, dst string, reencode bool) error {
 	return ioutil.WriteFile(dst, []byte(sDat), 0644)
 }
 
+// WriteAsUnicodeRtf Reads given UTF-8 src file,
+// formats the content to a minimal RTF file
+// and writes the result to dst.
+// RTF control characters are escaped,
+// non ASCII characters are written as RTF unicode escapes.
+func WriteAsUnicodeRtf(src string, dst string) error {
+	bSrc, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	bSrc = bytes.TrimPrefix(bSrc, []byte("\xef\xbb\xbf")) // utf-8 bom
+	return ioutil.WriteFile(dst, []byte(Encode(string(bSrc))), 0644)
+}
+
+const header = "{\\rtf1\\ansi\\ansicpg1252\\deff0\\uc1{\\fonttbl{\\f0\\fswiss Tahoma;}}\\f0\\fs16\r\n"
+
+// Encode formats given text to a minimal RTF document.
+func Encode(text string) string {
+	var b bytes.Buffer
+	b.WriteString(header)
+	for _, r := range text {
+		writeRune(&b, r)
+	}
+	b.WriteString("\r\n}")
+	return b.String()
+}
+
+// writeRune writes r to b, escaped for RTF.
+func writeRune(b *bytes.Buffer, r rune) {
+	switch {
+	case r == '\\' || r == '{' || r == '}':
+		b.WriteRune('\\')
+		b.WriteRune(r)
+	case r == '\r':
+	case r == '\n':
+		b.WriteString("\\par\r\n")
+	case r == '\t':
+		b.WriteString("\\tab ")
+	case r < 0x80:
+		b.WriteRune(r)
+	case r > 0xffff:
+		r1, r2 := utf16.EncodeRune(r)
+		fmt.Fprintf(b, "\\u%d?\\u%d?", int16(r1), int16(r2))
+	default:
+		fmt.Fprintf(b, "\\u%d?", int16(r))
+	}
+}
+
+// WriteMarkdownAsRtf Reads given UTF-8 Markdown src file,
+// formats the content to a minimal RTF file
+// and writes the result to dst.
+func WriteMarkdownAsRtf(src string, dst string) error {
+	bSrc, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	bSrc = bytes.TrimPrefix(bSrc, []byte("\xef\xbb\xbf")) // utf-8 bom
+	return ioutil.WriteFile(dst, []byte(EncodeMarkdown(string(bSrc))), 0644)
+}
+
+// IsMarkdown Detects if the given src file is a Markdown file, by its extension.
+func IsMarkdown(src string) bool {
+	ext := strings.ToLower(filepath.Ext(src))
+	return ext == ".md" || ext == ".markdown"
+}
+
+var (
+	mdHeading = regexp.MustCompile(`^\s{0,3}(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdItem    = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	mdRule    = regexp.MustCompile(`^\s{0,3}(-\s*-\s*-[-\s]*|\*\s*\*\s*\*[*\s]*|_\s*_\s*_[_\s]*)$`)
+	mdFence   = regexp.MustCompile("^\\s{0,3}(```|~~~)")
+	mdImage   = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLink    = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)[^)]*\)`)
+	mdAuto    = regexp.MustCompile(`<((?:https?|mailto):[^>\s]+)>`)
+	mdCode    = regexp.MustCompile("`([^`]+)`")
+	mdBold    = regexp.MustCompile(`\*\*(.+?)\*\*|__(.+?)__`)
+	mdItalic  = regexp.MustCompile(`\*([^*\s](?:[^*]*[^*\s])?)\*|\b_([^_\s](?:[^_]*[^_\s])?)_\b`)
+)
+
+// markers of the inline styles, replaced with RTF groups when written.
+const (
+	boldOn    = '\x01'
+	italicOn  = '\x02'
+	styledOff = '\x03'
+)
+
+// EncodeMarkdown formats given Markdown text to a minimal RTF document.
+// Headings and strong emphasis are written in bold, emphasis in italic,
+// the lines of a paragraph are joined, list items are indented,
+// code blocks keep their lines, links are written as their text followed by their url.
+func EncodeMarkdown(text string) string {
+	var b bytes.Buffer
+	b.WriteString(header)
+
+	var para []string
+	prefix := ""
+	blank := true
+	flush := func() {
+		if len(para) == 0 {
+			return
+		}
+		if prefix != "" {
+			b.WriteString("\\li360\\fi-360 ")
+			writeInline(&b, prefix)
+			b.WriteString("\\tab ")
+		}
+		writeInline(&b, strings.Join(para, " "))
+		b.WriteString("\\par\\pard\r\n")
+		para = nil
+		prefix = ""
+		blank = false
+	}
+	space := func() {
+		if !blank {
+			b.WriteString("\\par\r\n")
+			blank = true
+		}
+	}
+
+	inCode := false
+	text = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(text)
+	text = strings.TrimRight(text, " \t\n")
+	for _, line := range strings.Split(text, "\n") {
+		if mdFence.MatchString(line) {
+			flush()
+			if inCode {
+				space()
+			}
+			inCode = !inCode
+			continue
+		}
+		if inCode {
+			for _, r := range line {
+				writeRune(&b, r)
+			}
+			b.WriteString("\\line\r\n")
+			blank = false
+			continue
+		}
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+			flush()
+			space()
+		case mdRule.MatchString(line):
+			flush()
+			space()
+		case mdHeading.MatchString(line):
+			flush()
+			m := mdHeading.FindStringSubmatch(line)
+			size := "\\fs16"
+			if len(m[1]) < 3 {
+				size = "\\fs20"
+			}
+			b.WriteString("{\\b" + size + " ")
+			writeInline(&b, m[2])
+			b.WriteString("}\\par\r\n")
+			blank = false
+		case mdItem.MatchString(line):
+			flush()
+			m := mdItem.FindStringSubmatch(line)
+			prefix = m[2]
+			if prefix == "-" || prefix == "*" || prefix == "+" {
+				prefix = "\u2022"
+			}
+			para = append(para, m[3])
+		default:
+			para = append(para, trimmed)
+		}
+	}
+	flush()
+	b.WriteString("\r\n}")
+	return b.String()
+}
+
+// writeInline writes a line of Markdown text to b,
+// with its inline styles.
+func writeInline(b *bytes.Buffer, s string) {
+	s = mdImage.ReplaceAllString(s, "$1")
+	s = mdLink.ReplaceAllStringFunc(s, func(l string) string {
+		m := mdLink.FindStringSubmatch(l)
+		if m[1] == m[2] {
+			return m[2]
+		}
+		return m[1] + " (" + m[2] + ")"
+	})
+	s = mdAuto.ReplaceAllString(s, "$1")
+	s = mdCode.ReplaceAllString(s, "$1")
+	s = mdBold.ReplaceAllString(s, string(boldOn)+"$1$2"+string(styledOff))
+	s = mdItalic.ReplaceAllString(s, string(italicOn)+"$1$2"+string(styledOff))
+	for _, r := range s {
+		switch r {
+		case boldOn:
+			b.WriteString("{\\b ")
+		case italicOn:
+			b.WriteString("{\\i ")
+		case styledOff:
+			b.WriteString("}")
+		default:
+			writeRune(b, r)
+		}
+	}
+}
+
 // IsRtf Detects if the given src file is formatted with RTF format.
 func IsRtf(src string) bool {
 	dat, err := ioutil.ReadFile(src)
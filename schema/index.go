@@ -0,0 +1,254 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Enums are the known values of string properties, by path,
+// such as env.vars[].action, [] stands for any item of an array.
+type Enums map[string][]string
+
+// Generate returns the JSON Schema describing values of type t,
+// properties are named after the json tags of the struct fields.
+// Recursive struct types are described once in the definitions of the schema.
+func Generate(t reflect.Type, title string, enums Enums) map[string]interface{} {
+	g := &generator{
+		visiting:  map[reflect.Type]bool{},
+		recursive: map[reflect.Type]bool{},
+		defs:      map[string]interface{}{},
+		enums:     enums,
+	}
+	ret := g.generate(t, "")
+	ret["$schema"] = "http://json-schema.org/draft-07/schema#"
+	ret["title"] = title
+	if len(g.defs) > 0 {
+		ret["definitions"] = g.defs
+	}
+	return ret
+}
+
+type generator struct {
+	visiting  map[reflect.Type]bool // the struct types being generated
+	recursive map[reflect.Type]bool // the struct types referencing themselves
+	defs      map[string]interface{}
+	enums     Enums
+}
+
+func (g *generator) generate(t reflect.Type, path string) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return g.generate(t.Elem(), path)
+	case reflect.String:
+		if values, ok := g.enums[path]; ok {
+			return map[string]interface{}{"type": "string", "enum": values}
+		}
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": g.generate(t.Elem(), path+"[]")}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.generate(t.Elem(), join(path, "*"))}
+	case reflect.Struct:
+		if g.visiting[t] {
+			g.recursive[t] = true
+			return map[string]interface{}{"$ref": "#/definitions/" + t.Name()}
+		}
+		g.visiting[t] = true
+		props := map[string]interface{}{}
+		for name, f := range fields(t) {
+			props[name] = g.generate(f.Type, join(path, name))
+		}
+		delete(g.visiting, t)
+		ret := map[string]interface{}{
+			"type":                 "object",
+			"properties":           props,
+			"additionalProperties": false,
+		}
+		if g.recursive[t] {
+			g.defs[t.Name()] = ret
+		}
+		return ret
+	}
+	return map[string]interface{}{}
+}
+
+// fields returns the json encoded fields of the struct type t, by name.
+func fields(t reflect.Type) map[string]reflect.StructField {
+	ret := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" { // unexported
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		ret[name] = f
+	}
+	return ret
+}
+
+// Check decodes the JSON dat and checks it against the type t,
+// it returns a description of every unknown field, type mismatch
+// and unknown value of the enums found, located by their path.
+func Check(t reflect.Type, dat []byte, enums Enums) ([]string, error) {
+	var v interface{}
+	if err := json.Unmarshal(dat, &v); err != nil {
+		return nil, err
+	}
+	c := &checker{enums: enums}
+	c.check(t, v, "", "")
+	return c.problems, nil
+}
+
+type checker struct {
+	enums    Enums
+	problems []string
+}
+
+// check checks v against t, path locates v,
+// pattern is path without the indexes of the arrays, the key of the enums.
+func (c *checker) check(t reflect.Type, v interface{}, path, pattern string) {
+	if v == nil {
+		return
+	}
+	at := path
+	if at == "" {
+		at = "the manifest"
+	}
+	mismatch := func(expected string) {
+		c.problems = append(c.problems, fmt.Sprintf("%q must be %v, got %v", at, expected, jsonType(v)))
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		c.check(t.Elem(), v, path, pattern)
+	case reflect.String:
+		s, ok := v.(string)
+		if !ok {
+			mismatch("a string")
+			return
+		}
+		if values, ok := c.enums[pattern]; ok && s != "" && !contains(values, s) {
+			c.problems = append(c.problems, fmt.Sprintf("%q must be one of %v, got %q", at, strings.Join(values, ", "), s))
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			mismatch("a boolean")
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, ok := v.(float64); !ok || n != float64(int64(n)) {
+			mismatch("an integer")
+		}
+	case reflect.Float32, reflect.Float64:
+		if _, ok := v.(float64); !ok {
+			mismatch("a number")
+		}
+	case reflect.Slice, reflect.Array:
+		items, ok := v.([]interface{})
+		if !ok {
+			mismatch("an array")
+			return
+		}
+		for i, item := range items {
+			c.check(t.Elem(), item, fmt.Sprintf("%v[%d]", path, i), pattern+"[]")
+		}
+	case reflect.Map:
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			mismatch("an object")
+			return
+		}
+		for _, k := range sortedKeys(values) {
+			c.check(t.Elem(), values[k], join(path, k), join(pattern, "*"))
+		}
+	case reflect.Struct:
+		values, ok := v.(map[string]interface{})
+		if !ok {
+			mismatch("an object")
+			return
+		}
+		known := fields(t)
+		for _, k := range sortedKeys(values) {
+			f, ok := known[k]
+			if !ok {
+				msg := fmt.Sprintf("%q is an unknown field", join(path, k))
+				if s := suggest(k, known); s != "" {
+					msg += fmt.Sprintf(", did you mean %q ?", s)
+				}
+				c.problems = append(c.problems, msg)
+				continue
+			}
+			c.check(f.Type, values[k], join(path, k), join(pattern, k))
+		}
+	}
+}
+
+// suggest returns the known field name looking like k,
+// ignoring the case and the dashes.
+func suggest(k string, known map[string]reflect.StructField) string {
+	simple := func(s string) string {
+		return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(s))
+	}
+	for name := range known {
+		if simple(name) == simple(k) {
+			return name
+		}
+	}
+	return ""
+}
+
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func join(path, k string) string {
+	if path == "" {
+		return k
+	}
+	return path + "." + k
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	ret := []string{}
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case float64:
+		return "a number"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return "null"
+}
@@ -0,0 +1,91 @@
+// Package scoop writes the manifest of a Scoop bucket for a msi package.
+package scoop
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mh-cbon/go-msi/manifest"
+)
+
+// Download is the url of the msi file of an architecture, and its hash.
+type Download struct {
+	URL  string `json:"url"`
+	Hash string `json:"hash,omitempty"`
+}
+
+// Manifest is the app manifest of a Scoop bucket.
+type Manifest struct {
+	Version      string              `json:"version"`
+	Description  string              `json:"description,omitempty"`
+	Homepage     string              `json:"homepage,omitempty"`
+	License      string              `json:"license,omitempty"`
+	Architecture map[string]Download `json:"architecture"`
+	ExtractDir   string              `json:"extract_dir,omitempty"`
+	Bin          []string            `json:"bin,omitempty"`
+	Checkver     string              `json:"checkver,omitempty"`
+	Autoupdate   *Autoupdate         `json:"autoupdate,omitempty"`
+}
+
+// Autoupdate is the autoupdate stanza of a Manifest.
+type Autoupdate struct {
+	Architecture map[string]Download `json:"architecture"`
+}
+
+// Architectures maps the archs of the manifest to the scoop architectures.
+var Architectures = map[string]string{
+	"":      "32bit",
+	"386":   "32bit",
+	"x86":   "32bit",
+	"amd64": "64bit",
+	"x64":   "64bit",
+	"arm64": "arm64",
+}
+
+// New returns the manifest of the msi package of wixFile, the normalized manifest,
+// hash is the SHA256 of the msi file.
+// The url is the scoop.url-template of wixFile, with $version replaced by its version,
+// the template is kept for the autoupdate.
+func New(wixFile *manifest.WixManifest, hash string) (*Manifest, error) {
+	spec := wixFile.Scoop
+	if spec.URLTemplate == "" {
+		return nil, fmt.Errorf(`The url template must be set, with "scoop.url-template" or --url-template`)
+	}
+	arch, ok := Architectures[wixFile.Arch]
+	if !ok {
+		return nil, fmt.Errorf("Unknown arch %q", wixFile.Arch)
+	}
+	ret := &Manifest{
+		Version:     wixFile.Version,
+		Description: spec.Description,
+		Homepage:    spec.Homepage,
+		License:     spec.License,
+		Architecture: map[string]Download{
+			arch: {URL: strings.Replace(spec.URLTemplate, "$version", wixFile.Version, -1), Hash: strings.ToLower(hash)},
+		},
+		ExtractDir: spec.ExtractDir,
+		Bin:        spec.Bin,
+		Checkver:   spec.Checkver,
+	}
+	if strings.Contains(spec.URLTemplate, "$version") {
+		ret.Autoupdate = &Autoupdate{Architecture: map[string]Download{
+			arch: {URL: spec.URLTemplate},
+		}}
+	}
+	return ret, nil
+}
+
+// Write writes the manifest m to p, indented as the scoop buckets.
+func (m *Manifest) Write(p string) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "    ")
+	if err := enc.Encode(m); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, b.Bytes(), 0644)
+}
@@ -0,0 +1,78 @@
+package sign
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/mh-cbon/go-msi/logger"
+	"github.com/mh-cbon/go-msi/manifest"
+	"github.com/mh-cbon/go-msi/wix"
+)
+
+// Sign signs given file with signtool using given spec.
+// The timestamp step is run separately,
+// so it can be retried when the timestamp server fails.
+// signtool is run with wix.Run, by the runner and with the tool options of ctx.
+func Sign(ctx context.Context, spec manifest.SigningSpec, file string) error {
+	bin, err := exec.LookPath("signtool")
+	if err != nil {
+		return fmt.Errorf("signtool not found, cannot sign %q: %v", file, err)
+	}
+
+	args := []string{"sign", "/fd", spec.Digest}
+	if spec.Certificate != "" {
+		args = append(args, "/f", spec.Certificate)
+	} else {
+		args = append(args, "/sha1", spec.Thumbprint)
+	}
+	if spec.Password != "" {
+		args = append(args, "/p", spec.Password)
+	}
+	args = append(args, file)
+	if err := run(ctx, bin, args...); err != nil {
+		return fmt.Errorf("signtool failed to sign %q: %v", file, err)
+	}
+
+	if spec.TimestampURL == "" {
+		return nil
+	}
+	args = []string{"timestamp", "/tr", spec.TimestampURL, "/td", spec.Digest, file}
+	for i := 0; ; i++ {
+		err = run(ctx, bin, args...)
+		if err == nil {
+			return nil
+		}
+		if i >= spec.TimestampRetries {
+			break
+		}
+		logger.Default.Warn("Timestamp failed, retrying (%d/%d)", i+1, spec.TimestampRetries)
+		select {
+		case <-time.After(time.Duration(i+1) * 2 * time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("signtool failed to timestamp %q with %q: %v", file, spec.TimestampURL, err)
+}
+
+func run(ctx context.Context, bin string, args ...string) error {
+	logger.Default.Debug("signtool %v", strings.Join(masked(args), " "))
+	oCmd := exec.Command(bin, args...)
+	oCmd.Stdout = logger.Default.Output("signtool")
+	oCmd.Stderr = oCmd.Stdout
+	return wix.Run(ctx, oCmd)
+}
+
+// masked returns a copy of args whose password, the value of /p, is masked.
+func masked(args []string) []string {
+	ret := append([]string{}, args...)
+	for i := 1; i < len(ret); i++ {
+		if ret[i-1] == "/p" {
+			ret[i] = "***"
+		}
+	}
+	return ret
+}
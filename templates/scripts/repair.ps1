@@ -0,0 +1,26 @@
+# Repairs {{.Product}} silently, the installed product is found by its upgrade code,
+# its missing files are reinstalled, its registry entries and shortcuts are written again.
+param([string]$LogDir = $env:TEMP)
+
+$ErrorActionPreference = 'Stop'
+$upgradeCode = '{' + '{{.UpgradeCode}}'.Trim('{}') + '}'
+
+$installer = New-Object -ComObject WindowsInstaller.Installer
+$products = $installer.GetType().InvokeMember('RelatedProducts', 'GetProperty', $null, $installer, @($upgradeCode))
+if ($products.Count -eq 0) {
+    Write-Error "{{.Product}} is not installed"
+    exit 1
+}
+
+$code = 0
+foreach ($productCode in $products) {
+    $log = Join-Path $LogDir "{{.Product}}-repair-$($productCode.Trim('{}')).log"
+    Write-Host "Repairing {{.Product}} $productCode, see $log"
+    $p = Start-Process msiexec.exe -ArgumentList @('/fomus', $productCode, '/qn', '/norestart', '/l*v', "`"$log`"") -Wait -PassThru
+    # 3010 tells a reboot is required to complete the repair
+    if ($p.ExitCode -ne 0 -and $p.ExitCode -ne 3010) {
+        Write-Error "msiexec failed with the exit code $($p.ExitCode), see $log" -ErrorAction Continue
+        $code = $p.ExitCode
+    }
+}
+exit $code
@@ -0,0 +1,25 @@
+# Uninstalls {{.Product}} silently, the installed product is found by its upgrade code.
+param([string]$LogDir = $env:TEMP)
+
+$ErrorActionPreference = 'Stop'
+$upgradeCode = '{' + '{{.UpgradeCode}}'.Trim('{}') + '}'
+
+$installer = New-Object -ComObject WindowsInstaller.Installer
+$products = $installer.GetType().InvokeMember('RelatedProducts', 'GetProperty', $null, $installer, @($upgradeCode))
+if ($products.Count -eq 0) {
+    Write-Host "{{.Product}} is not installed"
+    exit 0
+}
+
+$code = 0
+foreach ($productCode in $products) {
+    $log = Join-Path $LogDir "{{.Product}}-uninstall-$($productCode.Trim('{}')).log"
+    Write-Host "Uninstalling {{.Product}} $productCode, see $log"
+    $p = Start-Process msiexec.exe -ArgumentList @('/x', $productCode, '/qn', '/norestart', '/l*v', "`"$log`"") -Wait -PassThru
+    # 3010 tells a reboot is required to complete the uninstall
+    if ($p.ExitCode -ne 0 -and $p.ExitCode -ne 3010) {
+        Write-Error "msiexec failed with the exit code $($p.ExitCode), see $log" -ErrorAction Continue
+        $code = $p.ExitCode
+    }
+}
+exit $code
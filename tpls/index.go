@@ -2,12 +2,14 @@ package tpls
 
 import (
 	"bytes"
+	"encoding/xml"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"text/template"
 
@@ -39,6 +41,19 @@ var funcMap = template.FuncMap{
 		return b.String()
 	},
 	"upper": strings.ToUpper,
+	"xml": func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	},
+}
+
+// AddFuncs adds funcs to the functions of the templates,
+// a function replaces the built-in function of the same name.
+func AddFuncs(funcs template.FuncMap) {
+	for name, fn := range funcs {
+		funcMap[name] = fn
+	}
 }
 
 // Find all wxs fies in given directory
@@ -47,21 +62,240 @@ func Find(srcDir string, pattern string) ([]string, error) {
 	return zglob.Glob(glob)
 }
 
-// GenerateTemplate generates given src template to out file using given manifest
-func GenerateTemplate(wixFile *manifest.WixManifest, src string, out string) error {
-	tpl, err := template.New("").Funcs(funcMap).ParseFiles(src)
+// FindWithOverrides finds all templates matching pattern in srcDir,
+// any file of overrideDir with the same name replaces the built-in template,
+// files only present in overrideDir are added to the result.
+// Results are sorted by file name so the resolution order is deterministic.
+func FindWithOverrides(srcDir string, overrideDir string, pattern string) ([]string, error) {
+	builtins, err := Find(srcDir, pattern)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	byName := map[string]string{}
+	for _, tpl := range builtins {
+		byName[filepath.Base(tpl)] = tpl
 	}
+	if overrideDir != "" {
+		if s, err := os.Stat(overrideDir); err != nil {
+			return nil, err
+		} else if !s.IsDir() {
+			return nil, fmt.Errorf("templates override path %q is not a directory", overrideDir)
+		}
+		overrides, err := Find(overrideDir, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, tpl := range overrides {
+			byName[filepath.Base(tpl)] = tpl
+		}
+	}
+	names := []string{}
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	ret := []string{}
+	for _, name := range names {
+		ret = append(ret, byName[name])
+	}
+	return ret, nil
+}
 
+// FindPartials finds the *.tmpl files of overrideDir,
+// their {{define}} actions replace the blocks of the same name of the templates,
+// such as the ui block of product.wxs.
+func FindPartials(overrideDir string) ([]string, error) {
+	if overrideDir == "" {
+		return nil, nil
+	}
+	return Find(overrideDir, "*.tmpl")
+}
+
+// GenerateTemplate generates given src template to out file using given manifest,
+// see ExecuteTemplate.
+func GenerateTemplate(wixFile *manifest.WixManifest, src string, out string, partials ...string) error {
 	fileWriter, err := os.Create(out)
 	if err != nil {
 		return err
 	}
 	defer fileWriter.Close()
-	err = tpl.ExecuteTemplate(fileWriter, filepath.Base(src), wixFile)
+	return ExecuteTemplate(wixFile, src, fileWriter, partials...)
+}
+
+// ExecuteTemplate executes given src template to w using given manifest,
+// the templates defined by the partials files replace those of src.
+// The path function of the templates writes a file path as the toolchain sees it, see manifest.WixManifest.BuildPath.
+func ExecuteTemplate(wixFile *manifest.WixManifest, src string, w io.Writer, partials ...string) error {
+	tpl, err := parse(wixFile, src, partials...)
 	if err != nil {
 		return err
 	}
-	return nil
+	return tpl.ExecuteTemplate(w, filepath.Base(src), wixFile)
+}
+
+func parse(wixFile *manifest.WixManifest, src string, partials ...string) (*template.Template, error) {
+	funcs := template.FuncMap{"path": wixFile.BuildPath}
+	return template.New("").Funcs(funcMap).Funcs(funcs).ParseFiles(append([]string{src}, partials...)...)
+}
+
+// FragmentBlock is the block of the templates which generates the fragment of a file group,
+// each file group gets its own fragment file, so it is compiled, and cached, on its own.
+const FragmentBlock = "filegroup"
+
+// Fragment is the data of the FragmentBlock, the manifest and the index of the file group.
+type Fragment struct {
+	*manifest.WixManifest
+	Group int
+}
+
+// FragmentName returns the file name of the fragment of the file group g.
+func FragmentName(g int) string {
+	return fmt.Sprintf("FileGroup%d.wxs", g)
+}
+
+// GenerateFragments writes the fragments of the file groups of wixFile into the dir directory,
+// with the FragmentBlock of the first of the templates, or of the partials, which defines it,
+// it returns their paths, none when no template defines the block.
+// An empty dir executes the fragments without writing them.
+func GenerateFragments(wixFile *manifest.WixManifest, templates []string, dir string, partials ...string) ([]string, error) {
+	for _, src := range templates {
+		tpl, err := parse(wixFile, src, partials...)
+		if err != nil {
+			return nil, err
+		}
+		if tpl.Lookup(FragmentBlock) == nil {
+			continue
+		}
+		ret := []string{}
+		for g := range wixFile.FileGroups {
+			p := filepath.Join(dir, FragmentName(g))
+			if err := executeFragment(tpl, Fragment{wixFile, g}, dir, p); err != nil {
+				return nil, fmt.Errorf("Failed to generate %q: %v", p, err)
+			}
+			ret = append(ret, p)
+		}
+		return ret, nil
+	}
+	return nil, nil
+}
+
+func executeFragment(tpl *template.Template, data Fragment, dir, p string) error {
+	if dir == "" {
+		return tpl.ExecuteTemplate(ioutil.Discard, FragmentBlock, data)
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return tpl.ExecuteTemplate(f, FragmentBlock, data)
+}
+
+// Diff returns the unified diff, with 3 lines of context, of the lines of a, named aName,
+// and of b, named bName, it is empty when they are equal, their line endings aside.
+func Diff(aName string, a []byte, bName string, b []byte) string {
+	x, y := splitLines(a), splitLines(b)
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// op is ' ' for a line of both, '-' for a line of a only, '+' for a line of b only,
+	// i and j are the lines of a and b before it.
+	type edit struct {
+		op   byte
+		line string
+		i, j int
+	}
+	edits := []edit{}
+	changed := false
+	for i, j := 0, 0; i < len(x) || j < len(y); {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			edits = append(edits, edit{' ', x[i], i, j})
+			i++
+			j++
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, edit{'-', x[i], i, j})
+			i++
+			changed = true
+		default:
+			edits = append(edits, edit{'+', y[j], i, j})
+			j++
+			changed = true
+		}
+	}
+	if !changed {
+		return ""
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "--- %v\n+++ %v\n", aName, bName)
+	for k := 0; k < len(edits); {
+		if edits[k].op == ' ' {
+			k++
+			continue
+		}
+		start := k - 3
+		if start < 0 {
+			start = 0
+		}
+		// the hunk goes on while the changes are less than 7 lines apart
+		end := k
+		for end < len(edits) {
+			if edits[end].op != ' ' {
+				end++
+				continue
+			}
+			next := end
+			for next < len(edits) && edits[next].op == ' ' {
+				next++
+			}
+			if next == len(edits) || next-end > 6 {
+				if end += 3; end > len(edits) {
+					end = len(edits)
+				}
+				break
+			}
+			end = next
+		}
+		na, nb := 0, 0
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				na++
+			}
+			if e.op != '-' {
+				nb++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", edits[start].i+1, na, edits[start].j+1, nb)
+		for _, e := range edits[start:end] {
+			out.WriteByte(e.op)
+			out.WriteString(e.line)
+			out.WriteByte('\n')
+		}
+		k = end
+	}
+	return out.String()
+}
+
+// splitLines returns the lines of b, without their line endings.
+func splitLines(b []byte) []string {
+	s := strings.Replace(string(b), "\r\n", "\n", -1)
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
 }
@@ -0,0 +1,184 @@
+// Package winget writes the manifests of the Windows Package Manager for a msi package,
+// as expected by the winget-pkgs repository.
+package winget
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/mh-cbon/go-msi/manifest"
+	"gopkg.in/yaml.v3"
+)
+
+// ManifestVersion is the version of the manifest schema written.
+const ManifestVersion = "1.6.0"
+
+// DefaultLocale is the locale of the package metadata.
+const DefaultLocale = "en-US"
+
+// Installer is the msi file of the package.
+type Installer struct {
+	Architecture    string `yaml:"Architecture"` // x86, x64 or arm64, the arch of the manifest when empty
+	Scope           string `yaml:"Scope,omitempty"`
+	InstallerURL    string `yaml:"InstallerUrl"`
+	InstallerSha256 string `yaml:"InstallerSha256"`
+	ProductCode     string `yaml:"ProductCode"`
+}
+
+// Switches are the arguments of msiexec for the install modes.
+type Switches struct {
+	Silent             string `yaml:"Silent"`
+	SilentWithProgress string `yaml:"SilentWithProgress"`
+	InstallLocation    string `yaml:"InstallLocation"`
+}
+
+// DefaultSwitches install the package without a restart, INSTALLDIR is the install location.
+var DefaultSwitches = Switches{
+	Silent:             "/quiet /norestart",
+	SilentWithProgress: "/passive /norestart",
+	InstallLocation:    `INSTALLDIR="<INSTALLPATH>"`,
+}
+
+type versionManifest struct {
+	PackageIdentifier string `yaml:"PackageIdentifier"`
+	PackageVersion    string `yaml:"PackageVersion"`
+	DefaultLocale     string `yaml:"DefaultLocale"`
+	ManifestType      string `yaml:"ManifestType"`
+	ManifestVersion   string `yaml:"ManifestVersion"`
+}
+
+type installerManifest struct {
+	PackageIdentifier string      `yaml:"PackageIdentifier"`
+	PackageVersion    string      `yaml:"PackageVersion"`
+	InstallerType     string      `yaml:"InstallerType"`
+	InstallModes      []string    `yaml:"InstallModes"`
+	InstallerSwitches Switches    `yaml:"InstallerSwitches"`
+	UpgradeBehavior   string      `yaml:"UpgradeBehavior"`
+	Installers        []Installer `yaml:"Installers"`
+	ManifestType      string      `yaml:"ManifestType"`
+	ManifestVersion   string      `yaml:"ManifestVersion"`
+}
+
+type localeManifest struct {
+	PackageIdentifier string   `yaml:"PackageIdentifier"`
+	PackageVersion    string   `yaml:"PackageVersion"`
+	PackageLocale     string   `yaml:"PackageLocale"`
+	Publisher         string   `yaml:"Publisher"`
+	PublisherURL      string   `yaml:"PublisherUrl,omitempty"`
+	PackageName       string   `yaml:"PackageName"`
+	PackageURL        string   `yaml:"PackageUrl,omitempty"`
+	License           string   `yaml:"License"`
+	LicenseURL        string   `yaml:"LicenseUrl,omitempty"`
+	ShortDescription  string   `yaml:"ShortDescription"`
+	Description       string   `yaml:"Description,omitempty"`
+	Moniker           string   `yaml:"Moniker,omitempty"`
+	Tags              []string `yaml:"Tags,omitempty"`
+	ManifestType      string   `yaml:"ManifestType"`
+	ManifestVersion   string   `yaml:"ManifestVersion"`
+}
+
+// scopes maps the install scopes of the manifest to the winget scopes.
+var scopes = map[string]string{
+	"perMachine": "machine",
+	"perUser":    "user",
+}
+
+// Write writes the version, installer and default locale manifests of the msi package
+// of wixFile, the normalized manifest, into dir, it returns their paths.
+func Write(wixFile *manifest.WixManifest, installer Installer, dir string) ([]string, error) {
+	spec := wixFile.Winget
+	if spec.License == "" {
+		return nil, fmt.Errorf(`"winget.license" must not be empty`)
+	}
+	if spec.ShortDescription == "" {
+		return nil, fmt.Errorf(`"winget.short-description" must not be empty`)
+	}
+	if installer.InstallerURL == "" {
+		return nil, fmt.Errorf(`The installer url must be set, with "winget.installer-url" or --url`)
+	}
+	if installer.Architecture == "" {
+		installer.Architecture = "x86"
+		if arch, ok := manifest.Archs[wixFile.Arch]; ok {
+			installer.Architecture = arch
+		}
+	}
+	if installer.Scope == "" {
+		installer.Scope = scopes[wixFile.InstallScope]
+	}
+
+	version := versionManifest{
+		PackageIdentifier: spec.ID,
+		PackageVersion:    wixFile.Version,
+		DefaultLocale:     DefaultLocale,
+		ManifestType:      "version",
+		ManifestVersion:   ManifestVersion,
+	}
+	inst := installerManifest{
+		PackageIdentifier: spec.ID,
+		PackageVersion:    wixFile.Version,
+		InstallerType:     "wix",
+		InstallModes:      []string{"interactive", "silent", "silentWithProgress"},
+		InstallerSwitches: DefaultSwitches,
+		UpgradeBehavior:   "install",
+		Installers:        []Installer{installer},
+		ManifestType:      "installer",
+		ManifestVersion:   ManifestVersion,
+	}
+	locale := localeManifest{
+		PackageIdentifier: spec.ID,
+		PackageVersion:    wixFile.Version,
+		PackageLocale:     DefaultLocale,
+		Publisher:         wixFile.Company,
+		PublisherURL:      spec.PublisherURL,
+		PackageName:       wixFile.Product,
+		PackageURL:        spec.PackageURL,
+		License:           spec.License,
+		LicenseURL:        spec.LicenseURL,
+		ShortDescription:  spec.ShortDescription,
+		Description:       spec.Description,
+		Moniker:           spec.Moniker,
+		Tags:              spec.Tags,
+		ManifestType:      "defaultLocale",
+		ManifestVersion:   ManifestVersion,
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files := []struct {
+		name string
+		kind string
+		v    interface{}
+	}{
+		{spec.ID + ".yaml", "version", version},
+		{spec.ID + ".installer.yaml", "installer", inst},
+		{spec.ID + ".locale." + DefaultLocale + ".yaml", "defaultLocale", locale},
+	}
+	ret := []string{}
+	for _, f := range files {
+		p := filepath.Join(dir, f.name)
+		if err := writeYaml(p, f.kind, f.v); err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
+
+// writeYaml writes v to p, after the schema comment of the manifest type kind.
+func writeYaml(p, kind string, v interface{}) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# yaml-language-server: $schema=https://aka.ms/winget-manifest.%v.%v.schema.json\n\n", kind, ManifestVersion)
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	if err := enc.Close(); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(p, b.Bytes(), 0644)
+}
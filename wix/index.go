@@ -1,59 +1,1245 @@
 package wix
 
 import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/mh-cbon/go-msi/logger"
 	"github.com/mh-cbon/go-msi/manifest"
 )
 
 var eol = "\r\n"
 
+// Toolchain drives a version of the WiX toolset to produce the msi packages.
+type Toolchain interface {
+	// Cmd returns the command lines to produce the msi packages.
+	Cmd(wixFile *manifest.WixManifest, templates []string, msiOutFile string, arch string) string
+	// Build produces the msi packages from the templates of the dir directory,
+	// the commands are run by the Runner of ctx, see WithRunner.
+	Build(ctx context.Context, wixFile *manifest.WixManifest, dir string, templates []string, msiOutFile string, arch string, jobs int, cacheDir string) error
+	// Validate runs the ICE validation of the msi file in the dir directory,
+	// the ICEs suppressed by the manifest are not run.
+	Validate(ctx context.Context, wixFile *manifest.WixManifest, dir string, msiFile string) ([]IceMessage, error)
+}
+
+// Toolchains maps the major versions of WiX to their toolchain,
+// WiX 5 builds as WiX 4.
+var Toolchains = map[string]Toolchain{
+	"3": Wix3{},
+	"4": Wix4{},
+	"5": Wix4{},
+}
+
+// FindToolchain returns the toolchain of the given WiX version,
+// auto, or an empty version, detects the installed toolset:
+// WiX 3 when candle is found, WiX 4 when wix is found.
+func FindToolchain(version string) (Toolchain, error) {
+	if version == "" || version == "auto" {
+		version = "3"
+		if _, err := exec.LookPath("candle"); err != nil {
+			if _, err := exec.LookPath("wix"); err == nil {
+				version = "4"
+			}
+		}
+	}
+	if t, ok := Toolchains[strings.TrimPrefix(version, "v")]; ok {
+		return t, nil
+	}
+	return nil, fmt.Errorf("Unknown WiX version %q, expected 3, 4, 5 or auto", version)
+}
+
+// ToolchainVersion returns the name and the version of the toolset of toolchain,
+// the version is read from the output of its tools, it is empty when they are not found.
+func ToolchainVersion(toolchain Toolchain) (string, string) {
+	name, cmd := "wix", []string{"wix", "--version"}
+	switch toolchain.(type) {
+	case Wix3:
+		cmd = []string{"candle", "-?"}
+	case Wixl:
+		name, cmd = "wixl", []string{"wixl", "--version"}
+	}
+	out, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput()
+	if err != nil && len(out) == 0 {
+		return name, ""
+	}
+	return name, toolVersionRe.FindString(string(out))
+}
+
+var toolVersionRe = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// Wix3 is the toolchain of WiX 3, templates are compiled by candle and linked by light.
+type Wix3 struct{}
+
+// Cmd returns the candle and light command lines, see GenerateCmd.
+func (Wix3) Cmd(wixFile *manifest.WixManifest, templates []string, msiOutFile string, arch string) string {
+	return GenerateCmd(wixFile, templates, msiOutFile, arch)
+}
+
+// Build compiles and links the templates, see Compile and Link.
+func (Wix3) Build(ctx context.Context, wixFile *manifest.WixManifest, dir string, templates []string, msiOutFile string, arch string, jobs int, cacheDir string) error {
+	if err := Compile(ctx, wixFile, dir, templates, arch, jobs, cacheDir); err != nil {
+		return err
+	}
+	return Link(ctx, wixFile, dir, templates, msiOutFile)
+}
+
+// Validate runs smoke on msiFile.
+func (Wix3) Validate(ctx context.Context, wixFile *manifest.WixManifest, dir string, msiFile string) ([]IceMessage, error) {
+	args := []string{"-nologo"}
+	for _, ice := range SuppressedIces(wixFile) {
+		args = append(args, "-sice:"+ice)
+	}
+	return validate(ctx, wixFile, dir, "smoke", append(args, msiFile)...)
+}
+
+// Wix4 is the toolchain of WiX 4 and later, the templates are written for WiX 3,
+// wix convert upgrades them to the new schema, then wix build produces the packages.
+type Wix4 struct{}
+
+// Cmd returns the wix convert and wix build command lines.
+func (Wix4) Cmd(wixFile *manifest.WixManifest, templates []string, msiOutFile string, arch string) string {
+	cmd := "wix " + strings.Join(ConvertArgs(wixFile, templates), " ") + eol
+	for _, args := range BuildArgs(wixFile, templates, msiOutFile, arch) {
+		cmd += "wix " + strings.Join(args, " ") + eol
+	}
+	return cmd
+}
+
+// Build converts the templates of the dir directory, then builds the packages,
+// jobs and cacheDir are not used, wix build compiles and links at once.
+func (Wix4) Build(ctx context.Context, wixFile *manifest.WixManifest, dir string, templates []string, msiOutFile string, arch string, jobs int, cacheDir string) error {
+	if len(wixFile.Drivers) > 0 {
+		return fmt.Errorf("WiX 4 does not support drivers, DIFxApp was removed, build the package with WiX 3")
+	}
+	// wix convert exits with the count of the converted elements,
+	// the build reports the sources it failed to convert.
+	if err := Run(ctx, command(dir, "wix", ConvertArgs(wixFile, templates)...)); ctx.Err() != nil {
+		return err
+	}
+
+	for _, args := range BuildArgs(wixFile, templates, msiOutFile, arch) {
+		if err := Run(ctx, command(dir, "wix", args...)); err != nil {
+			return fmt.Errorf("wix build failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// Validate runs wix msi validate on msiFile.
+func (Wix4) Validate(ctx context.Context, wixFile *manifest.WixManifest, dir string, msiFile string) ([]IceMessage, error) {
+	args := []string{"msi", "validate"}
+	for _, ice := range SuppressedIces(wixFile) {
+		args = append(args, "-sice", ice)
+	}
+	return validate(ctx, wixFile, dir, "wix", append(args, msiFile)...)
+}
+
+// Wixl is the toolchain of msitools, wixl builds the msi package on linux or macOS,
+// it supports a subset of WiX 3, the templates are translated by WixlMarkup
+// and the templates of dialog sets are skipped, the package has no UI.
+type Wixl struct{}
+
+// Cmd returns the wixl command line.
+func (Wixl) Cmd(wixFile *manifest.WixManifest, templates []string, msiOutFile string, arch string) string {
+	return "wixl " + strings.Join(WixlArgs(templates, msiOutFile, arch), " ") + "\n"
+}
+
+// Build translates the templates of the dir directory, then runs wixl,
+// jobs and cacheDir are not used.
+func (Wixl) Build(ctx context.Context, wixFile *manifest.WixManifest, dir string, templates []string, msiOutFile string, arch string, jobs int, cacheDir string) error {
+	if len(wixFile.Cultures) > 0 {
+		return fmt.Errorf("wixl does not support languages, build the package with WiX")
+	}
+	if wixFile.Media.Split {
+		return fmt.Errorf("wixl does not support split cabs, build the package with WiX")
+	}
+	if len(wixFile.Drivers) > 0 {
+		return fmt.Errorf("wixl does not support drivers, build the package with WiX 3")
+	}
+	if len(wixFile.PathMap) > 0 {
+		return fmt.Errorf("wixl reads the files on the host, remove the path-map")
+	}
+	for _, tpl := range wixlTemplates(templates) {
+		p := filepath.Join(dir, filepath.Base(tpl))
+		src, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(p, WixlMarkup(src), 0644); err != nil {
+			return err
+		}
+	}
+	if err := Run(ctx, command(dir, "wixl", WixlArgs(templates, msiOutFile, arch)...)); err != nil {
+		return fmt.Errorf("wixl failed: %v", err)
+	}
+	return nil
+}
+
+// Validate is not supported by msitools.
+func (Wixl) Validate(ctx context.Context, wixFile *manifest.WixManifest, dir string, msiFile string) ([]IceMessage, error) {
+	return nil, fmt.Errorf("wixl does not validate the msi packages, validate them with WiX")
+}
+
+// WixlArgs returns the arguments of wixl.
+func WixlArgs(templates []string, msiOutFile string, arch string) []string {
+	args := []string{}
+	if arch != "" {
+		if a, ok := manifest.Archs[arch]; ok {
+			arch = a
+		}
+		args = append(args, "--arch", arch)
+	}
+	args = append(args, "-o", msiOutFile)
+	for _, tpl := range wixlTemplates(templates) {
+		args = append(args, filepath.Base(tpl))
+	}
+	return args
+}
+
+// wixlTemplates returns the templates wixl builds, the dialog sets are skipped.
+func wixlTemplates(templates []string) []string {
+	ret := []string{}
+	for _, tpl := range templates {
+		name := filepath.Base(tpl)
+		if strings.HasPrefix(name, "WixUI_") || strings.HasPrefix(name, "LicenseAgreementDlg_") {
+			continue
+		}
+		ret = append(ret, tpl)
+	}
+	return ret
+}
+
+// wixlUnsupported are the elements wixl does not know,
+// they are removed with their content.
+var wixlUnsupported = []string{
+	"UI",
+	"UIRef",
+	"WixVariable",
+	"fire:FirewallException",
+	"util:ServiceConfig",
+	"util:PermissionEx",
+	"util:EventSource",
+	"util:PerformanceCategory",
+}
+
+// WixlMarkup translates a WiX 3 source to the subset wixl compiles,
+// the UI and the elements of the WiX extensions are removed.
+func WixlMarkup(src []byte) []byte {
+	s := string(src)
+	for _, name := range wixlUnsupported {
+		s = removeElements(s, name)
+	}
+	return []byte(s)
+}
+
+// removeElements removes the elements named name of the xml source s.
+func removeElements(s, name string) string {
+	open := "<" + name
+	ret := ""
+	for {
+		i := strings.Index(s, open)
+		if i < 0 {
+			return ret + s
+		}
+		rest := s[i+len(open):]
+		if rest == "" || !strings.ContainsAny(rest[:1], " \t\r\n/>") {
+			ret += s[:i+len(open)]
+			s = rest
+			continue
+		}
+		end := strings.Index(rest, ">")
+		if end < 0 {
+			return ret + s
+		}
+		if end == 0 || rest[end-1] != '/' {
+			closing := "</" + name + ">"
+			if c := strings.Index(rest, closing); c > -1 {
+				end = c + len(closing) - 1
+			}
+		}
+		ret += s[:i]
+		s = rest[end+1:]
+	}
+}
+
+// ConvertArgs returns the arguments of wix convert,
+// to upgrade the templates and the localization files to the WiX 4 schema.
+func ConvertArgs(wixFile *manifest.WixManifest, templates []string) []string {
+	args := []string{"convert"}
+	for _, tpl := range templates {
+		args = append(args, filepath.Base(tpl))
+	}
+	for _, c := range wixFile.Cultures {
+		args = append(args, c.WxlFile())
+	}
+	return args
+}
+
+// BuildArgs returns the arguments of wix build,
+// one command per msi package, see manifest.WixManifest.MsiFiles.
+func BuildArgs(wixFile *manifest.WixManifest, templates []string, msiOutFile string, arch string) [][]string {
+	args := []string{"build", "-ext", "WixToolset.UI.wixext", "-ext", "WixToolset.Util.wixext"}
+	if len(wixFile.Firewall.Rules) > 0 {
+		args = append(args, "-ext", "WixToolset.Firewall.wixext")
+	}
+	if arch != "" {
+		if a, ok := manifest.Archs[arch]; ok {
+			arch = a
+		}
+		args = append(args, "-arch", arch)
+	}
+	args = append(args, "-pdbtype", "none")
+	for _, ice := range SuppressedIces(wixFile) {
+		args = append(args, "-sice", ice)
+	}
+	if wixFile.Media.CompressionLevel != "" {
+		args = append(args, "-dcl", wixFile.Media.CompressionLevel)
+	}
+	srcs := []string{}
+	for _, tpl := range templates {
+		srcs = append(srcs, filepath.Base(tpl))
+	}
+	ret := [][]string{}
+	if len(wixFile.Cultures) == 0 {
+		ret = append(ret, append(append(args, "-o", msiOutFile), srcs...))
+	}
+	for _, c := range wixFile.Cultures {
+		a := append([]string{}, args...)
+		a = append(a, "-culture", c.Name, "-loc", c.WxlFile(), "-o", c.MsiFile(msiOutFile))
+		ret = append(ret, append(a, srcs...))
+	}
+	return ret
+}
+
 // GenerateCmd generates required command lines to produce an msi package,
 func GenerateCmd(wixFile *manifest.WixManifest, templates []string, msiOutFile string, arch string) string {
 
-	cmd := ""
+	candle := append([]string{"candle"}, CandleArgs(wixFile, arch)...)
+	for _, tpl := range templates {
+		candle = append(candle, filepath.Base(tpl))
+	}
+	cmd := strings.Join(candle, " ") + eol
+	for _, args := range LightArgs(wixFile, templates, msiOutFile) {
+		cmd += "light " + strings.Join(args, " ") + eol
+	}
 
-	for i, dir := range wixFile.RelDirs {
-		sI := strconv.Itoa(i)
-		cmd += "heat dir " + dir + " -nologo -cg AppFiles" + sI
-		cmd += " -gg -g1 -srd -sfrag -template fragment -dr APPDIR" + sI
-		cmd += " -var var.SourceDir" + sI
-		cmd += " -out AppFiles" + sI + ".wxs"
-		cmd += eol
+	return cmd
+}
+
+func exts(wixFile *manifest.WixManifest) []string {
+	args := []string{}
+	if len(wixFile.Firewall.Rules) > 0 {
+		args = append(args, "-ext", "WixFirewallExtension")
+	}
+	if len(wixFile.Drivers) > 0 {
+		args = append(args, "-ext", "WixDifxAppExtension")
+	}
+	return args
+}
+
+// difxLib returns the DIFxApp library linked with the packages installing drivers,
+// it is in the bin directory of WiX 3, the WIX environment variable set by its installer.
+func difxLib(wixFile *manifest.WixManifest) string {
+	arch := "x86"
+	if manifest.Archs[wixFile.Arch] == "x64" {
+		arch = "x64"
+	}
+	return filepath.Join(os.Getenv("WIX"), "bin", "difxapp_"+arch+".wixlib")
+}
+
+// CandleArgs returns the arguments of candle, but the templates to compile.
+func CandleArgs(wixFile *manifest.WixManifest, arch string) []string {
+	args := exts(wixFile)
+	if len(wixFile.Services) > 0 || len(wixFile.Permissions) > 0 || len(wixFile.EventSources) > 0 || len(wixFile.PerfCategories) > 0 {
+		args = append(args, "-ext", "WixUtilExtension")
 	}
-	cmd += "candle"
 	if arch != "" {
-		if arch == "386" {
-			arch = "x86"
-		} else if arch == "amd64" {
-			arch = "x64"
+		if a, ok := manifest.Archs[arch]; ok {
+			arch = a
+		}
+		args = append(args, "-arch", arch)
+	}
+	return args
+}
+
+// LightArgs returns the arguments of light to link the compiled templates,
+// one command per msi package, see manifest.WixManifest.MsiFiles.
+func LightArgs(wixFile *manifest.WixManifest, templates []string, msiOutFile string) [][]string {
+	args := append([]string{"-ext", "WixUIExtension", "-ext", "WixUtilExtension"}, exts(wixFile)...)
+	args = append(args, "-sacl", "-spdb")
+	for _, ice := range SuppressedIces(wixFile) {
+		args = append(args, "-sice:"+ice)
+	}
+	// the default level of the cabs without CompressionLevel, such as those of merge modules.
+	if wixFile.Media.CompressionLevel != "" {
+		args = append(args, "-dcl:"+wixFile.Media.CompressionLevel)
+	}
+	objs := []string{}
+	for _, tpl := range templates {
+		objs = append(objs, objFile(tpl))
+	}
+	if len(wixFile.Drivers) > 0 {
+		objs = append(objs, difxLib(wixFile))
+	}
+	ret := [][]string{}
+	if len(wixFile.Cultures) == 0 {
+		ret = append(ret, append(append(args, "-out", msiOutFile), objs...))
+	}
+	// one msi package per language
+	for _, c := range wixFile.Cultures {
+		a := append([]string{}, args...)
+		a = append(a, "-cultures:"+c.Name, "-loc", c.WxlFile(), "-out", c.MsiFile(msiOutFile))
+		ret = append(ret, append(a, objs...))
+	}
+	return ret
+}
+
+// SuppressedIces returns the ICEs not to run, those of the manifest,
+// and those a per user package fails as its components are not keyed by HKCU registry values.
+func SuppressedIces(wixFile *manifest.WixManifest) []string {
+	ret := []string{}
+	if wixFile.InstallScope == "perUser" {
+		ret = append(ret, "ICE38", "ICE64", "ICE91")
+	}
+	for _, ice := range wixFile.Validation.Suppress {
+		found := false
+		for _, r := range ret {
+			found = found || r == ice
+		}
+		if !found {
+			ret = append(ret, ice)
+		}
+	}
+	return ret
+}
+
+// IceMessage is a message of the ICE validation of a msi package.
+type IceMessage struct {
+	ICE     string // such as ICE38
+	Level   string // error or warning
+	Message string
+	Field   string // the field of the manifest which likely produced it, when known
+}
+
+func (m IceMessage) String() string {
+	s := fmt.Sprintf("%v %v: %v", m.ICE, m.Level, m.Message)
+	if m.Field != "" {
+		s += fmt.Sprintf(" (see %q)", m.Field)
+	}
+	return s
+}
+
+// iceFields maps the ICEs to the fields of the manifest which produce the tables they validate.
+var iceFields = map[string]string{
+	"ICE18": "directories",
+	"ICE30": "files",
+	"ICE33": "file-associations",
+	"ICE38": "install-scope",
+	"ICE43": "install-scope",
+	"ICE50": "shortcuts.items.icon",
+	"ICE57": "install-scope",
+	"ICE61": "upgrade",
+	"ICE64": "install-scope",
+	"ICE69": "shortcuts.items.target",
+	"ICE77": "custom-actions",
+	"ICE80": "arch",
+	"ICE82": "module",
+	"ICE91": "install-scope",
+}
+
+var iceRe = regexp.MustCompile(`\b(error|warning)\b.*?\b(ICE[0-9]+)\s*:\s*(.*)$`)
+
+// ParseIces returns the ICE messages of the output of smoke, light or wix,
+// but those of the suppressed ICEs.
+func ParseIces(wixFile *manifest.WixManifest, output string) []IceMessage {
+	suppressed := map[string]bool{}
+	for _, ice := range SuppressedIces(wixFile) {
+		suppressed[ice] = true
+	}
+	ret := []IceMessage{}
+	for _, line := range strings.Split(output, "\n") {
+		m := iceRe.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil || suppressed[m[2]] {
+			continue
+		}
+		ret = append(ret, IceMessage{ICE: m[2], Level: m[1], Message: m[3], Field: iceFields[m[2]]})
+	}
+	return ret
+}
+
+// validate runs the validation tool name with args in the dir directory, and parses its output.
+func validate(ctx context.Context, wixFile *manifest.WixManifest, dir string, name string, args ...string) ([]IceMessage, error) {
+	var out bytes.Buffer
+	cmd := command(dir, name, args...)
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	err := Run(ctx, cmd)
+	ices := ParseIces(wixFile, out.String())
+	if err != nil && (len(ices) == 0 || ctx.Err() != nil) {
+		return nil, fmt.Errorf("%v failed: %v", name, err)
+	}
+	return ices, nil
+}
+
+func objFile(tpl string) string {
+	return strings.Replace(filepath.Base(tpl), ".wxs", ".wixobj", -1)
+}
+
+// Compile runs candle on each of the templates of the dir directory,
+// with at most jobs candle processes at once.
+// A template is not compiled again when cacheDir holds its object file
+// for the same content, arguments and inputs, see cacheFile,
+// an empty cacheDir disables the cache.
+// The output of the processes is printed in the templates order.
+func Compile(ctx context.Context, wixFile *manifest.WixManifest, dir string, templates []string, arch string, jobs int, cacheDir string) error {
+	if jobs < 1 {
+		jobs = 1
+	}
+	if cacheDir != "" {
+		if err := os.MkdirAll(cacheDir, 0744); err != nil {
+			return err
+		}
+	}
+	args := CandleArgs(wixFile, arch)
+
+	outputs := make([]bytes.Buffer, len(templates))
+	errs := make([]error, len(templates))
+	cached := make([]string, len(templates))
+	sem := make(chan bool, jobs)
+	var wg sync.WaitGroup
+	for i, tpl := range templates {
+		wg.Add(1)
+		go func(i int, tpl string) {
+			defer wg.Done()
+			sem <- true
+			defer func() { <-sem }()
+			if cacheDir != "" {
+				if cached[i], errs[i] = cacheFile(args, dir, filepath.Base(tpl), cacheDir); errs[i] != nil {
+					return
+				}
+			}
+			errs[i] = compile(ctx, args, dir, filepath.Base(tpl), cached[i], &outputs[i])
+		}(i, tpl)
+	}
+	wg.Wait()
+
+	candle := logger.Default.Output("candle")
+	for i, tpl := range templates {
+		candle.Write(outputs[i].Bytes())
+		if errs[i] != nil {
+			return fmt.Errorf("candle failed to compile %q: %v", tpl, errs[i])
+		}
+	}
+	if cacheDir != "" {
+		return pruneCache(cacheDir, cached)
+	}
+	return nil
+}
+
+// cacheFile returns the path of the object file of the template tpl in cacheDir,
+// it is named by the hash of the inputs of the template, its arguments, its content,
+// and the paths, sizes and modification times of the files its Source attributes reference,
+// so a template, such as the fragment of a file group, is compiled again only when its own inputs change.
+func cacheFile(args []string, dir, tpl, cacheDir string) (string, error) {
+	content, err := ioutil.ReadFile(filepath.Join(dir, tpl))
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%v\n%v\n", strings.Join(args, " "), tpl)
+	h.Write(content)
+	for _, m := range sourceRe.FindAllSubmatch(content, -1) {
+		f := xmlUnescape.Replace(string(m[1]))
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(dir, f)
+		}
+		// the files of the build machine, such as those of a path-map, are not found
+		if s, err := os.Stat(f); err == nil {
+			fmt.Fprintf(h, "\n%v %v %v", m[1], s.Size(), s.ModTime().UnixNano())
 		}
-		cmd += " -arch " + arch
 	}
-	for i, dir := range wixFile.RelDirs {
-		sI := strconv.Itoa(i)
-		cmd += " -dSourceDir" + sI + "=" + dir
+	return filepath.Join(cacheDir, hex.EncodeToString(h.Sum(nil))+".wixobj"), nil
+}
+
+// sourceRe matches the Source and SourceFile attributes of the templates.
+var sourceRe = regexp.MustCompile(`\bSource(?:File)?="([^"]+)"`)
+
+var xmlUnescape = strings.NewReplacer("&amp;", "&", "&quot;", `"`, "&apos;", "'", "&lt;", "<", "&gt;", ">", "&#39;", "'", "&#34;", `"`)
+
+// compile runs candle on the template tpl, unless the cached object file exists,
+// the object file is then copied into the cache, an empty cached disables the cache.
+func compile(ctx context.Context, args []string, dir, tpl, cached string, out *bytes.Buffer) error {
+	obj := filepath.Join(dir, objFile(tpl))
+	if cached != "" {
+		if byt, err := ioutil.ReadFile(cached); err == nil {
+			fmt.Fprintf(out, "%s is up to date\n", tpl)
+			return ioutil.WriteFile(obj, byt, 0644)
+		}
+	}
+
+	oCmd := command(dir, "candle", append(append([]string{}, args...), tpl)...)
+	oCmd.Stdout = out
+	oCmd.Stderr = out
+	if err := Run(ctx, oCmd); err != nil {
+		return err
+	}
+
+	if cached == "" {
+		return nil
+	}
+	byt, err := ioutil.ReadFile(obj)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cached, byt, 0644)
+}
+
+// pruneCache removes the object files of cacheDir the last build did not use.
+func pruneCache(cacheDir string, used []string) error {
+	keep := map[string]bool{}
+	for _, f := range used {
+		keep[filepath.Base(f)] = true
+	}
+	files, err := filepath.Glob(filepath.Join(cacheDir, "*.wixobj"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if !keep[filepath.Base(f)] {
+			if err := os.Remove(f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Link runs light in the dir directory to produce the msi packages
+// from the compiled templates.
+func Link(ctx context.Context, wixFile *manifest.WixManifest, dir string, templates []string, msiOutFile string) error {
+	for _, args := range LightArgs(wixFile, templates, msiOutFile) {
+		if err := Run(ctx, command(dir, "light", args...)); err != nil {
+			return fmt.Errorf("light failed: %v", err)
+		}
+	}
+	return nil
+}
+
+// bundleExts are the extensions of the bundle templates.
+var bundleExts = []string{"-ext", "WixBalExtension", "-ext", "WixUtilExtension"}
+
+// HeatArgs returns the arguments of heat to harvest the registration of file into out.
+func HeatArgs(file, out string) []string {
+	return []string{"file", file, "-ag", "-srd", "-sfrag", "-nologo", "-out", out}
+}
+
+// ComRegistration is the COM registration of a file, harvested by heat.
+type ComRegistration struct {
+	File     string // the children of the File element, such as Class and TypeLib
+	Registry string // the other registry elements of the component
+}
+
+// HarvestCom runs heat in the dir directory to harvest the COM registration of file,
+// the references to the file in the registration use the File Id fileKey.
+func HarvestCom(ctx context.Context, dir, file, fileKey string) (*ComRegistration, error) {
+	out := fileKey + ".heat"
+	if err := run(ctx, dir, "heat", HeatArgs(file, out)...); err != nil {
+		return nil, err
+	}
+	src, err := ioutil.ReadFile(filepath.Join(dir, out))
+	if err != nil {
+		return nil, err
+	}
+	return ParseHeat(src, fileKey)
+}
+
+// ParseHeat returns the COM registration of the component of src, a source heat generated,
+// its File Id is replaced by fileKey.
+func ParseHeat(src []byte, fileKey string) (*ComRegistration, error) {
+	ret := &ComRegistration{}
+	d := xml.NewDecoder(bytes.NewReader(src))
+	names := []string{}
+	starts := []int64{}
+	component := -1 // depth of the Component element
+	heatID := ""
+	for {
+		offset := d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to parse the heat output: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			names = append(names, t.Name.Local)
+			starts = append(starts, offset)
+			if t.Name.Local == "Component" && component < 0 {
+				component = len(names)
+			}
+			if t.Name.Local == "File" && len(names) == component+1 {
+				for _, a := range t.Attr {
+					if a.Name.Local == "Id" {
+						heatID = a.Value
+					}
+				}
+			}
+		case xml.EndElement:
+			depth := len(names)
+			raw := string(src[starts[depth-1]:d.InputOffset()])
+			if depth == component+1 && t.Name.Local != "File" {
+				ret.Registry += raw + "\n"
+			} else if depth == component+2 && names[component] == "File" {
+				ret.File += raw + "\n"
+			}
+			names, starts = names[:depth-1], starts[:depth-1]
+		}
+	}
+	if component < 0 {
+		return nil, fmt.Errorf("heat harvested no component")
+	}
+	if heatID != "" {
+		for _, prefix := range []string{"[#", "[!"} {
+			ret.File = strings.Replace(ret.File, prefix+heatID+"]", prefix+fileKey+"]", -1)
+			ret.Registry = strings.Replace(ret.Registry, prefix+heatID+"]", prefix+fileKey+"]", -1)
+		}
+	}
+	return ret, nil
+}
+
+// WixNamespaces are the namespaces of the Wix element, of WiX 3 and of WiX 4.
+var WixNamespaces = map[string]bool{
+	"http://schemas.microsoft.com/wix/2006/wi": true,
+	"http://wixtoolset.org/schemas/v4/wxs":     true,
+}
+
+// lintRoots are the elements the Wix element may contain.
+var lintRoots = map[string]bool{
+	"Bundle":        true,
+	"Fragment":      true,
+	"Module":        true,
+	"Package":       true,
+	"Patch":         true,
+	"PatchCreation": true,
+	"Product":       true,
+}
+
+// lintIds are the elements whose Id must be unique.
+var lintIds = map[string]bool{
+	"Binary":         true,
+	"Component":      true,
+	"ComponentGroup": true,
+	"CustomAction":   true,
+	"Directory":      true,
+	"Feature":        true,
+	"File":           true,
+	"Icon":           true,
+	"Property":       true,
+	"Shortcut":       true,
+}
+
+// Lint checks the generated template src is well-formed XML,
+// its root is a Wix element of a WiX namespace, holding products, modules, fragments,
+// bundles, or patches, and the Ids of its components, directories, features, files,
+// custom actions, properties, and the like, are unique.
+// It returns the problems found, with their line.
+func Lint(src []byte) []string {
+	problems := []string{}
+	d := xml.NewDecoder(bytes.NewReader(src))
+	line := func(offset int64) int {
+		return bytes.Count(src[:offset], []byte("\n")) + 1
 	}
-	for i := range wixFile.Directories {
-		sI := strconv.Itoa(i)
-		cmd += " AppFiles" + sI + ".wxs"
+	ids := map[string]int{}
+	depth := 0
+	for {
+		offset := d.InputOffset()
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return append(problems, err.Error())
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			depth++
+			name := t.Name.Local
+			switch {
+			case depth == 1 && name != "Wix":
+				problems = append(problems, fmt.Sprintf("line %d: the root element is %v, expected Wix", line(offset), name))
+			case depth == 1 && !WixNamespaces[t.Name.Space]:
+				problems = append(problems, fmt.Sprintf("line %d: unknown Wix namespace %q", line(offset), t.Name.Space))
+			case depth == 2 && !lintRoots[name]:
+				problems = append(problems, fmt.Sprintf("line %d: unexpected %v element in the Wix element", line(offset), name))
+			}
+			if !lintIds[name] {
+				continue
+			}
+			for _, a := range t.Attr {
+				if a.Name.Local != "Id" || a.Name.Space != "" {
+					continue
+				}
+				key := name + " " + a.Value
+				if first, ok := ids[key]; ok {
+					problems = append(problems, fmt.Sprintf("line %d: duplicate %v Id %q, first declared at line %d", line(offset), name, a.Value, first))
+				} else {
+					ids[key] = line(offset)
+				}
+			}
+		case xml.EndElement:
+			depth--
+		}
 	}
+	return problems
+}
+
+// Bundle compiles and links the bundle templates of the dir directory
+// to produce the bootstrapper exeOutFile, it requires WiX 3.
+func Bundle(ctx context.Context, dir string, templates []string, exeOutFile string) error {
+	args := append([]string{}, bundleExts...)
+	objs := []string{}
 	for _, tpl := range templates {
-		cmd += " " + filepath.Base(tpl)
+		args = append(args, filepath.Base(tpl))
+		objs = append(objs, objFile(tpl))
 	}
-	cmd += eol
-	cmd += "light -ext WixUIExtension -ext WixUtilExtension -sacl -spdb "
-	cmd += " -out " + msiOutFile
-	for i := range wixFile.Directories {
-		sI := strconv.Itoa(i)
-		cmd += " AppFiles" + sI + ".wixobj"
+	if err := run(ctx, dir, "candle", args...); err != nil {
+		return err
 	}
+	return run(ctx, dir, "light", append(append(append([]string{}, bundleExts...), "-out", exeOutFile), objs...)...)
+}
+
+// Patch builds the msp mspOutFile updating the oldFile release to the newFile release,
+// both are msi packages, or wixout files made by light -xo.
+// torch computes the differences, then pyro applies them to the patch templates of the dir directory,
+// it requires WiX 3.
+func Patch(ctx context.Context, dir string, templates []string, oldFile, newFile, mspOutFile string) error {
+	args := []string{}
+	objs := []string{}
 	for _, tpl := range templates {
-		cmd += " " + strings.Replace(filepath.Base(tpl), ".wxs", ".wixobj", -1)
+		args = append(args, filepath.Base(tpl))
+		objs = append(objs, objFile(tpl))
 	}
-	cmd += eol
+	torch := []string{"-p", "-xi"}
+	if strings.EqualFold(filepath.Ext(oldFile), ".msi") {
+		// the files of the msi packages are extracted for pyro
+		torch = []string{"-p", "-ax", "binaries"}
+	}
+	torch = append(torch, oldFile, newFile, "-out", "diff.wixmst")
+	steps := [][]string{
+		append([]string{"candle"}, args...),
+		append([]string{"light", "-out", "patch.wixmsp"}, objs...),
+		append([]string{"torch"}, torch...),
+		{"pyro", "patch.wixmsp", "-out", mspOutFile, "-t", "RTM", "diff.wixmst"},
+	}
+	for _, step := range steps {
+		if err := run(ctx, dir, step[0], step[1:]...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return cmd
+// run runs the WiX tool name with args in the dir directory.
+func run(ctx context.Context, dir, name string, args ...string) error {
+	if err := Run(ctx, command(dir, name, args...)); err != nil {
+		return fmt.Errorf("%v failed: %v", name, err)
+	}
+	return nil
+}
+
+// command returns the command running the tool name with args in the dir directory,
+// its output is sent to the logger.
+func command(dir, name string, args ...string) *exec.Cmd {
+	logger.Default.Debug("%v %v", name, strings.Join(args, " "))
+	oCmd := exec.Command(name, args...)
+	oCmd.Dir = dir
+	oCmd.Stdout = logger.Default.Output(name)
+	oCmd.Stderr = oCmd.Stdout
+	return oCmd
+}
+
+// MsixArgs returns the arguments of makeappx packing the layout directory into msixOutFile.
+func MsixArgs(layout string, msixOutFile string) []string {
+	return []string{"pack", "/o", "/d", layout, "/p", msixOutFile}
+}
+
+// MsixCmd returns the makeappx command line packing the layout directory into msixOutFile.
+func MsixCmd(layout string, msixOutFile string) string {
+	return "makeappx " + strings.Join(MsixArgs(layout, msixOutFile), " ") + eol
+}
+
+// Msix runs makeappx of the Windows SDK in the dir directory
+// to pack the layout directory into the msix package msixOutFile.
+func Msix(ctx context.Context, dir string, layout string, msixOutFile string) error {
+	return run(ctx, dir, "makeappx", MsixArgs(layout, msixOutFile)...)
+}
+
+// tableScript prints the rows of a table of an msi file with the Windows Installer API,
+// tab separated, after the column names,
+// it is formatted with the path of the msi file and the table name.
+var tableScript = `$i = New-Object -ComObject WindowsInstaller.Installer
+$d = $i.GetType().InvokeMember('OpenDatabase', 'InvokeMethod', $null, $i, @('%v', 0))
+$v = $d.GetType().InvokeMember('OpenView', 'InvokeMethod', $null, $d, @('SELECT * FROM ` + "`%v`" + `'))
+$v.GetType().InvokeMember('Execute', 'InvokeMethod', $null, $v, $null)
+$c = $v.GetType().InvokeMember('ColumnInfo', 'GetProperty', $null, $v, 0)
+$n = $c.GetType().InvokeMember('FieldCount', 'GetProperty', $null, $c, $null)
+$row = { param($r) (1..$n | ForEach-Object { $r.GetType().InvokeMember('StringData', 'GetProperty', $null, $r, $_) }) -join "` + "`t" + `" }
+[Console]::WriteLine((& $row $c))
+while (($r = $v.GetType().InvokeMember('Fetch', 'InvokeMethod', $null, $v, $null)) -ne $null) {
+   [Console]::WriteLine((& $row $r))
+}`
+
+// ReadTable reads the rows of the table of the msi file, by column name,
+// with msiinfo of msitools, or with the Windows Installer API on windows when msiinfo is not installed.
+func ReadTable(ctx context.Context, msi string, table string) ([]map[string]string, error) {
+	msi, err := filepath.Abs(msi)
+	if err != nil {
+		return nil, err
+	}
+	var cmd *exec.Cmd
+	// msiinfo prints the column types and the table keys after the column names
+	skip := 2
+	_, lookErr := exec.LookPath("msiinfo")
+	if lookErr == nil || runtime.GOOS != "windows" {
+		cmd = exec.Command("msiinfo", "export", msi, table)
+	} else {
+		script := fmt.Sprintf(tableScript, strings.Replace(msi, "'", "''", -1), table)
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", script)
+		skip = 0
+	}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = logger.Default.Output(filepath.Base(cmd.Args[0]))
+	if err := Run(ctx, cmd); err != nil {
+		return nil, fmt.Errorf("Failed to read the %v table of %q: %v", table, msi, err)
+	}
+	lines := strings.Split(strings.Replace(out.String(), "\r\n", "\n", -1), "\n")
+	columns := strings.Split(lines[0], "\t")
+	ret := []map[string]string{}
+	for n, line := range lines[1:] {
+		if n < skip || line == "" {
+			continue
+		}
+		row := map[string]string{}
+		for k, v := range strings.Split(line, "\t") {
+			if k < len(columns) {
+				row[columns[k]] = v
+			}
+		}
+		ret = append(ret, row)
+	}
+	return ret, nil
+}
+
+// ReadProperties reads the Property table of the msi file.
+func ReadProperties(ctx context.Context, msi string) (map[string]string, error) {
+	rows, err := ReadTable(ctx, msi, "Property")
+	if err != nil {
+		return nil, err
+	}
+	ret := map[string]string{}
+	for _, row := range rows {
+		ret[row["Property"]] = row["Value"]
+	}
+	return ret, nil
+}
+
+// ProductCode reads the ProductCode property of the msi file, see ReadTable.
+func ProductCode(ctx context.Context, msi string) (string, error) {
+	props, err := ReadProperties(ctx, msi)
+	if err != nil {
+		return "", err
+	}
+	if props["ProductCode"] == "" {
+		return "", fmt.Errorf("The msi file %q has no product code", msi)
+	}
+	return props["ProductCode"], nil
+}
+
+// MsiInfo describes a msi package.
+type MsiInfo struct {
+	ProductCode    string            `json:"product-code"`
+	UpgradeCode    string            `json:"upgrade-code,omitempty"`
+	ProductVersion string            `json:"product-version"`
+	ProductName    string            `json:"product-name"`
+	Manufacturer   string            `json:"manufacturer"`
+	Properties     map[string]string `json:"properties"`
+	Files          []MsiFile         `json:"files"`
+}
+
+// MsiFile is a row of the File table of a msi package.
+type MsiFile struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"` // the long file name
+	Component string `json:"component"`
+	Size      int64  `json:"size"`
+	Version   string `json:"version,omitempty"`
+}
+
+// ReadInfo reads the properties and the files of the msi file, see ReadTable.
+func ReadInfo(ctx context.Context, msi string) (*MsiInfo, error) {
+	props, err := ReadProperties(ctx, msi)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := ReadTable(ctx, msi, "File")
+	if err != nil {
+		return nil, err
+	}
+	ret := &MsiInfo{
+		ProductCode:    props["ProductCode"],
+		UpgradeCode:    props["UpgradeCode"],
+		ProductVersion: props["ProductVersion"],
+		ProductName:    props["ProductName"],
+		Manufacturer:   props["Manufacturer"],
+		Properties:     props,
+		Files:          []MsiFile{},
+	}
+	for _, row := range rows {
+		// FileName is the short name, then the long name when it differs
+		name := row["FileName"]
+		if i := strings.Index(name, "|"); i > -1 {
+			name = name[i+1:]
+		}
+		size, _ := strconv.ParseInt(row["FileSize"], 10, 64)
+		ret.Files = append(ret.Files, MsiFile{
+			ID:        row["File"],
+			Name:      name,
+			Component: row["Component_"],
+			Size:      size,
+			Version:   row["Version"],
+		})
+	}
+	return ret, nil
+}
+
+// Runner runs the command cmd of a toolchain, it stops it when ctx is done.
+type Runner func(ctx context.Context, cmd *exec.Cmd) error
+
+type runnerKey struct{}
+
+// WithRunner returns a copy of ctx whose toolchain commands are run by r,
+// such as a fake recording them in tests.
+func WithRunner(ctx context.Context, r Runner) context.Context {
+	return context.WithValue(ctx, runnerKey{}, r)
+}
+
+// ToolOptions of the tool commands, see WithToolOptions.
+type ToolOptions struct {
+	Timeout time.Duration // maximum duration of a command, unlimited when 0
+	Retries int           // the runs again of a command which timed out
+	Env     []string      // KEY=value variables added to the environment of the commands
+}
+
+type toolOptionsKey struct{}
+
+// WithToolOptions returns a copy of ctx whose tool commands are run with opts.
+func WithToolOptions(ctx context.Context, opts ToolOptions) context.Context {
+	return context.WithValue(ctx, toolOptionsKey{}, opts)
+}
+
+// Run runs cmd with the Runner of ctx, ExecRunner by default, and the ToolOptions of ctx.
+// A failure is returned as a *ToolError, with the output of cmd.
+func Run(ctx context.Context, cmd *exec.Cmd) error {
+	runner := Runner(ExecRunner)
+	if r, ok := ctx.Value(runnerKey{}).(Runner); ok {
+		runner = r
+	}
+	opts, _ := ctx.Value(toolOptionsKey{}).(ToolOptions)
+	if len(opts.Env) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		cmd.Env = append(cmd.Env, opts.Env...)
+	}
+
+	var output bytes.Buffer
+	stdout, stderr := cmd.Stdout, cmd.Stderr
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			// a command runs once, a copy runs again
+			cmd = &exec.Cmd{Path: cmd.Path, Args: cmd.Args, Env: cmd.Env, Dir: cmd.Dir}
+		}
+		output.Reset()
+		cmd.Stdout = capture(stdout, &output)
+		cmd.Stderr = cmd.Stdout
+		if !sameWriter(stdout, stderr) {
+			cmd.Stderr = capture(stderr, &output)
+		}
+
+		runCtx, cancel := ctx, context.CancelFunc(func() {})
+		if opts.Timeout > 0 {
+			runCtx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		}
+		err := runner(runCtx, cmd)
+		timedOut := ctx.Err() == nil && runCtx.Err() == context.DeadlineExceeded
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if timedOut {
+			err = fmt.Errorf("timed out after %v", opts.Timeout)
+			if attempt < opts.Retries {
+				logger.Default.Warn("%v %v, run again", filepath.Base(cmd.Args[0]), err)
+				continue
+			}
+		}
+		return newToolError(cmd, err, output.String())
+	}
+}
+
+// capture returns the writer of the output of a command, to w and to buf.
+func capture(w io.Writer, buf *bytes.Buffer) io.Writer {
+	if w == nil {
+		return buf
+	}
+	return io.MultiWriter(w, buf)
+}
+
+// sameWriter tells if a and b are the same writer, as exec.Cmd compares its Stdout and Stderr.
+func sameWriter(a, b io.Writer) (same bool) {
+	defer func() { recover() }()
+	return a == b
+}
+
+// ToolError is the failure of a tool command.
+type ToolError struct {
+	Tool     string   // name of the tool, such as light
+	Args     []string // arguments of the command
+	Dir      string   // working directory of the command
+	ExitCode int      // -1 when the command did not exit, such as when it is not found or timed out
+	Output   string   // the output of the command, stdout and stderr
+	Err      error
+	Hints    []string // the likely causes of the errors, see WixErrorHints
+}
+
+func newToolError(cmd *exec.Cmd, err error, output string) *ToolError {
+	ret := &ToolError{
+		Tool:     strings.TrimSuffix(filepath.Base(cmd.Args[0]), ".exe"),
+		Args:     cmd.Args[1:],
+		Dir:      cmd.Dir,
+		ExitCode: -1,
+		Output:   output,
+		Err:      err,
+	}
+	if e, ok := err.(*exec.ExitError); ok {
+		ret.ExitCode = e.ExitCode()
+	}
+	ret.Hints = wixHints(ret.Tool, ret.ExitCode, output)
+	return ret
+}
+
+// Error returns the error, the error lines of the output, or its last lines, and the hints.
+func (e *ToolError) Error() string {
+	var b bytes.Buffer
+	b.WriteString(e.Err.Error())
+	lines := []string{}
+	all := strings.Split(strings.TrimSpace(strings.Replace(e.Output, "\r\n", "\n", -1)), "\n")
+	for _, line := range all {
+		if errorLineRe.MatchString(line) {
+			lines = append(lines, line)
+		}
+	}
+	if len(lines) == 0 {
+		lines = all
+	}
+	if len(lines) > maxErrorLines {
+		lines = lines[len(lines)-maxErrorLines:]
+	}
+	for _, line := range lines {
+		if line = strings.TrimSpace(line); line != "" {
+			b.WriteString("\n  " + line)
+		}
+	}
+	for _, h := range e.Hints {
+		b.WriteString("\nhint: " + h)
+	}
+	return b.String()
+}
+
+// maxErrorLines is the maximum count of the lines of the output of a ToolError.
+const maxErrorLines = 10
+
+var errorLineRe = regexp.MustCompile(`(?i)\berror\b`)
+
+// wixErrorRe matches the error numbers of the WiX tools, such as LGHT0103.
+var wixErrorRe = regexp.MustCompile(`(?i)\berror:? +(?:CNDL|LGHT|SMOK|WIX)(\d{4})\b`)
+
+// WixErrorHints are the likely causes of the errors of the WiX tools, by error number,
+// such as 103 for CNDL0103, LGHT0103 or WIX0103, light exits with the number of its last error.
+var WixErrorHints = map[int]string{
+	1:   "the msi file may be open, or locked by an antivirus, close it and build again",
+	5:   "a template override, or a *.tmpl block, puts an element where WiX does not allow it, see go-msi templates-diff",
+	10:  "an element misses a required attribute, check the manifest fields of the element and the template overrides",
+	91:  "two elements have the same Id, check files.items, file-groups, shortcuts, registry and the template overrides for duplicates",
+	94:  "an element references an undeclared element, check features[].components and the template overrides",
+	103: "a file is not found, check files.items, file-groups, directories, license, the icons, ui.banner, ui.background and path-map",
+	104: "a template is not valid XML, check the template overrides and the *.tmpl blocks with go-msi templates-diff",
+	130: "two elements have the same Id, check files.items, file-groups, shortcuts, registry and the template overrides for duplicates",
+	144: "a WiX extension is not installed, run go-msi check-env",
+	204: "an ICE validation failed, fix the field reported by go-msi make --validate, or add the ICE to validation.suppress",
+	217: "the ICE validation could not run, build as an administrator, or add the ICEs to validation.suppress",
+}
+
+// wixHints returns the hints of the errors of the output of the WiX tool,
+// or of its exit code when the output has no error numbers.
+func wixHints(tool string, exitCode int, output string) []string {
+	switch tool {
+	case "candle", "light", "smoke", "wix":
+	default:
+		return nil
+	}
+	codes := []int{}
+	for _, m := range wixErrorRe.FindAllStringSubmatch(output, -1) {
+		n, _ := strconv.Atoi(m[1])
+		codes = append(codes, n)
+	}
+	if len(codes) == 0 && exitCode > 0 {
+		codes = append(codes, exitCode)
+	}
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, n := range codes {
+		if h, ok := WixErrorHints[n]; ok && !seen[h] {
+			seen[h] = true
+			ret = append(ret, h)
+		}
+	}
+	return ret
+}
+
+// ExecRunner runs cmd, it is killed when ctx is done.
+func ExecRunner(ctx context.Context, cmd *exec.Cmd) error {
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		return fmt.Errorf("%v not found: %v", cmd.Args[0], err)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		<-done
+		return ctx.Err()
+	}
 }
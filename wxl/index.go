@@ -0,0 +1,39 @@
+package wxl
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"sort"
+
+	"github.com/mh-cbon/go-msi/manifest"
+)
+
+type localization struct {
+	XMLName  xml.Name `xml:"http://schemas.microsoft.com/wix/2006/localization WixLocalization"`
+	Culture  string   `xml:"Culture,attr"`
+	Codepage int      `xml:"Codepage,attr"`
+	Strings  []str    `xml:"String"`
+}
+
+type str struct {
+	ID    string `xml:"Id,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Write writes the WiX localization file of given culture to dst,
+// its strings are sorted by id.
+func Write(c manifest.WixCulture, dst string) error {
+	l := localization{Culture: c.Name, Codepage: c.Codepage}
+	for id, value := range c.Strings {
+		l.Strings = append(l.Strings, str{ID: id, Value: value})
+	}
+	sort.Slice(l.Strings, func(i, j int) bool {
+		return l.Strings[i].ID < l.Strings[j].ID
+	})
+	byt, err := xml.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	byt = append([]byte(xml.Header), byt...)
+	return ioutil.WriteFile(dst, append(byt, '\n'), 0644)
+}